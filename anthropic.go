@@ -0,0 +1,783 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/coalaura/openingrouter"
+)
+
+const (
+	anthropicVersion = "2023-06-01"
+
+	// format of the thinking blocks stored with tool calls
+	anthropicReasoningFormat = "anthropic-thinking"
+
+	// forced tool used to answer with a json schema
+	anthropicJSONTool = "json_response"
+
+	// output tokens on top of the thinking budget
+	anthropicMaxTokens = 8000
+)
+
+var anthropicThinkingBudgets = map[string]int{
+	"minimal": 1024,
+	"low":     4096,
+	"medium":  8192,
+	"high":    16384,
+	"xhigh":   24000,
+}
+
+type AnthropicModels struct {
+	Data    []AnthropicModel `json:"data"`
+	HasMore bool             `json:"has_more"`
+	LastID  string           `json:"last_id"`
+}
+
+type AnthropicModel struct {
+	ID          string    `json:"id"`
+	DisplayName string    `json:"display_name"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type AnthropicRequest struct {
+	Model       string               `json:"model"`
+	System      []AnthropicBlock     `json:"system,omitempty"`
+	Messages    []AnthropicMessage   `json:"messages"`
+	Tools       []AnthropicTool      `json:"tools,omitempty"`
+	ToolChoice  *AnthropicToolChoice `json:"tool_choice,omitempty"`
+	MaxTokens   int                  `json:"max_tokens"`
+	Temperature *float64             `json:"temperature,omitempty"`
+	Thinking    *AnthropicThinking   `json:"thinking,omitempty"`
+	Stream      bool                 `json:"stream"`
+}
+
+type AnthropicMessage struct {
+	Role    string           `json:"role"`
+	Content []AnthropicBlock `json:"content"`
+}
+
+type AnthropicBlock struct {
+	Type         string                 `json:"type"`
+	Text         string                 `json:"text,omitempty"`
+	Source       *AnthropicSource       `json:"source,omitempty"`
+	ID           string                 `json:"id,omitempty"`
+	Name         string                 `json:"name,omitempty"`
+	Input        json.RawMessage        `json:"input,omitempty"`
+	ToolUseID    string                 `json:"tool_use_id,omitempty"`
+	Content      string                 `json:"content,omitempty"`
+	Thinking     string                 `json:"thinking,omitempty"`
+	Signature    string                 `json:"signature,omitempty"`
+	Data         string                 `json:"data,omitempty"`
+	CacheControl *AnthropicCacheControl `json:"cache_control,omitempty"`
+}
+
+type AnthropicSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	URL       string `json:"url,omitempty"`
+}
+
+type AnthropicCacheControl struct {
+	Type string `json:"type"`
+}
+
+type AnthropicTool struct {
+	Name         string                 `json:"name"`
+	Description  string                 `json:"description,omitempty"`
+	InputSchema  any                    `json:"input_schema"`
+	CacheControl *AnthropicCacheControl `json:"cache_control,omitempty"`
+}
+
+type AnthropicToolChoice struct {
+	Type string `json:"type"`
+	Name string `json:"name,omitempty"`
+}
+
+type AnthropicThinking struct {
+	Type         string `json:"type"`
+	BudgetTokens int    `json:"budget_tokens"`
+}
+
+type AnthropicEvent struct {
+	Type         string          `json:"type"`
+	Index        int             `json:"index"`
+	Message      *AnthropicStart `json:"message"`
+	ContentBlock *AnthropicBlock `json:"content_block"`
+	Delta        *AnthropicDelta `json:"delta"`
+	Usage        *AnthropicUsage `json:"usage"`
+	Error        *AnthropicError `json:"error"`
+}
+
+type AnthropicStart struct {
+	ID    string         `json:"id"`
+	Model string         `json:"model"`
+	Usage AnthropicUsage `json:"usage"`
+}
+
+type AnthropicDelta struct {
+	Type        string             `json:"type"`
+	Text        string             `json:"text"`
+	Thinking    string             `json:"thinking"`
+	Signature   string             `json:"signature"`
+	PartialJSON string             `json:"partial_json"`
+	Citation    *AnthropicCitation `json:"citation"`
+	StopReason  string             `json:"stop_reason"`
+}
+
+type AnthropicCitation struct {
+	Type          string `json:"type"`
+	URL           string `json:"url"`
+	Title         string `json:"title"`
+	DocumentTitle string `json:"document_title"`
+}
+
+type AnthropicUsage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+}
+
+type AnthropicError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// AnthropicStream maps the events of the messages api to completion deltas.
+type AnthropicStream struct {
+	body io.ReadCloser
+	sse  *SSEReader
+
+	id    string
+	model string
+	usage AnthropicUsage
+
+	types     map[int]string
+	arguments map[int]bool
+	citations map[int][]AnthropicCitation
+
+	// thinking blocks have to be sent back along with tool calls
+	thinking  []AnthropicBlock
+	current   map[int]int
+	encrypted bool
+}
+
+// LoadAnthropicModels returns the models of an anthropic backend. The api
+// lists no capabilities, all current models take images and tools.
+func LoadAnthropicModels(ctx context.Context, backend *EnvBackend) ([]*Model, []*Model, error) {
+	var (
+		models []*Model
+		after  string
+	)
+
+	for {
+		query := url.Values{}
+
+		query.Set("limit", "1000")
+
+		if after != "" {
+			query.Set("after_id", after)
+		}
+
+		var page AnthropicModels
+
+		_, err := BackendRequest(ctx, backend, nil, "GET", "/models?"+query.Encode(), nil, &page)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, model := range page.Data {
+			m := &Model{
+				ID:          GetModelShortID(model.ID),
+				Slug:        model.ID,
+				Created:     model.CreatedAt.Unix(),
+				Name:        model.DisplayName,
+				Description: fmt.Sprintf("%s by Anthropic.", model.DisplayName),
+				Author:      "anthropic",
+
+				Context: ModelContext{
+					Total: 200000,
+				},
+
+				Vision: true,
+				Tools:  true,
+				Text:   true,
+
+				Tags: []string{"tools", "vision"},
+			}
+
+			// extended thinking was introduced with claude 3.7
+			if !strings.HasPrefix(model.ID, "claude-3-") || strings.HasPrefix(model.ID, "claude-3-7") {
+				m.Reasoning = true
+
+				m.Tags = append(m.Tags, "reasoning")
+			}
+
+			sort.Strings(m.Tags)
+
+			models = append(models, m)
+		}
+
+		if !page.HasMore || page.LastID == "" {
+			break
+		}
+
+		after = page.LastID
+	}
+
+	sort.Slice(models, func(i, j int) bool {
+		return models[i].Slug < models[j].Slug
+	})
+
+	return models, nil, nil
+}
+
+func NewAnthropicRequest(request *openingrouter.ChatCompletionRequest) (*AnthropicRequest, error) {
+	anthropic := &AnthropicRequest{
+		Model:     request.Model,
+		MaxTokens: anthropicMaxTokens,
+		Stream:    true,
+	}
+
+	if request.MaxTokens != nil {
+		anthropic.MaxTokens = *request.MaxTokens
+	}
+
+	add := func(role string, blocks []AnthropicBlock) {
+		if len(blocks) == 0 {
+			return
+		}
+
+		// roles have to alternate
+		if last := len(anthropic.Messages) - 1; last >= 0 && anthropic.Messages[last].Role == role {
+			anthropic.Messages[last].Content = append(anthropic.Messages[last].Content, blocks...)
+
+			return
+		}
+
+		anthropic.Messages = append(anthropic.Messages, AnthropicMessage{
+			Role:    role,
+			Content: blocks,
+		})
+	}
+
+	for _, message := range request.Messages {
+		switch message.Role {
+		case openingrouter.ChatRoleSystem:
+			text := message.Content.String()
+			if text == "" {
+				continue
+			}
+
+			// only leading system messages are system prompts
+			if len(anthropic.Messages) == 0 {
+				anthropic.System = append(anthropic.System, AnthropicBlock{
+					Type: "text",
+					Text: text,
+				})
+			} else {
+				add("user", []AnthropicBlock{{
+					Type: "text",
+					Text: text,
+				}})
+			}
+		case openingrouter.ChatRoleUser:
+			blocks, err := anthropicContent(message.Content)
+			if err != nil {
+				return nil, err
+			}
+
+			add("user", blocks)
+		case openingrouter.ChatRoleAssistant:
+			var blocks []AnthropicBlock
+
+			for _, details := range message.ReasoningDetails {
+				if details.Type != openingrouter.ChatReasoningDetailTypeEncrypted || string(details.Format) != anthropicReasoningFormat {
+					continue
+				}
+
+				var thinking []AnthropicBlock
+
+				if err := json.Unmarshal([]byte(details.Data), &thinking); err == nil {
+					blocks = append(blocks, thinking...)
+				}
+			}
+
+			if text := message.Content.String(); strings.TrimSpace(text) != "" {
+				blocks = append(blocks, AnthropicBlock{
+					Type: "text",
+					Text: text,
+				})
+			}
+
+			for _, call := range message.ToolCalls {
+				input := json.RawMessage(call.Function.Arguments)
+
+				if !json.Valid(input) {
+					input = json.RawMessage("{}")
+				}
+
+				blocks = append(blocks, AnthropicBlock{
+					Type:  "tool_use",
+					ID:    call.ID,
+					Name:  call.Function.Name,
+					Input: input,
+				})
+			}
+
+			add("assistant", blocks)
+		case openingrouter.ChatRoleTool:
+			add("user", []AnthropicBlock{{
+				Type:      "tool_result",
+				ToolUseID: message.ToolCallID,
+				Content:   message.Content.String(),
+			}})
+		}
+	}
+
+	for _, function := range RequestFunctions(request) {
+		anthropic.Tools = append(anthropic.Tools, AnthropicTool{
+			Name:        function.Name,
+			Description: function.Description,
+			InputSchema: function.Parameters,
+		})
+	}
+
+	if request.Reasoning != nil && request.Reasoning.Effort != "none" {
+		budget, ok := anthropicThinkingBudgets[string(request.Reasoning.Effort)]
+		if !ok {
+			budget = anthropicThinkingBudgets["medium"]
+		}
+
+		anthropic.Thinking = &AnthropicThinking{
+			Type:         "enabled",
+			BudgetTokens: budget,
+		}
+
+		anthropic.MaxTokens += budget
+	}
+
+	// thinking only works with the default temperature, which is also the max
+	if request.Temperature != nil && anthropic.Thinking == nil {
+		temperature := min(*request.Temperature, 1)
+
+		anthropic.Temperature = &temperature
+	}
+
+	// json schemas are answered through a forced tool call
+	if format := request.ResponseFormat; format != nil && format.JSONSchema != nil && anthropic.Thinking == nil {
+		anthropic.Tools = append(anthropic.Tools, AnthropicTool{
+			Name:        anthropicJSONTool,
+			Description: "Respond with json matching the schema.",
+			InputSchema: format.JSONSchema.Schema,
+		})
+
+		anthropic.ToolChoice = &AnthropicToolChoice{
+			Type: "tool",
+			Name: anthropicJSONTool,
+		}
+	}
+
+	anthropic.SetCacheBreakpoints()
+
+	return anthropic, nil
+}
+
+func anthropicContent(content openingrouter.ChatContent) ([]AnthropicBlock, error) {
+	if len(content.Parts) == 0 {
+		if content.Text == "" {
+			return nil, nil
+		}
+
+		return []AnthropicBlock{{
+			Type: "text",
+			Text: content.Text,
+		}}, nil
+	}
+
+	blocks := make([]AnthropicBlock, 0, len(content.Parts))
+
+	for _, part := range content.Parts {
+		if part.ImageURL == nil {
+			if part.Text != "" {
+				blocks = append(blocks, AnthropicBlock{
+					Type: "text",
+					Text: part.Text,
+				})
+			}
+
+			continue
+		}
+
+		source := &AnthropicSource{
+			Type: "url",
+			URL:  part.ImageURL.URL,
+		}
+
+		if strings.HasPrefix(part.ImageURL.URL, "data:") {
+			mime, data, err := DecodeDataURL(part.ImageURL.URL)
+			if err != nil {
+				return nil, err
+			}
+
+			source = &AnthropicSource{
+				Type:      "base64",
+				MediaType: mime,
+				Data:      base64.StdEncoding.EncodeToString(data),
+			}
+		}
+
+		blocks = append(blocks, AnthropicBlock{
+			Type:   "image",
+			Source: source,
+		})
+	}
+
+	return blocks, nil
+}
+
+// SetCacheBreakpoints caches the system prompt, the tools and the whole
+// conversation, so follow-up requests and tool iterations only pay for the
+// new messages.
+func (r *AnthropicRequest) SetCacheBreakpoints() {
+	ephemeral := &AnthropicCacheControl{
+		Type: "ephemeral",
+	}
+
+	if len(r.System) > 0 {
+		r.System[len(r.System)-1].CacheControl = ephemeral
+	}
+
+	if len(r.Tools) > 0 {
+		r.Tools[len(r.Tools)-1].CacheControl = ephemeral
+	}
+
+	if len(r.Messages) == 0 {
+		return
+	}
+
+	content := r.Messages[len(r.Messages)-1].Content
+
+	for i := len(content) - 1; i >= 0; i-- {
+		switch content[i].Type {
+		case "thinking", "redacted_thinking":
+			continue
+		}
+
+		content[i].CacheControl = ephemeral
+
+		return
+	}
+}
+
+func AnthropicStartStream(ctx context.Context, request *openingrouter.ChatCompletionRequest, backend *EnvBackend, proxy *EnvProxy) (CompletionStream, error) {
+	anthropic, err := NewAnthropicRequest(request)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := BackendRequest(ctx, backend, proxy, "POST", "/messages", anthropic, nil)
+	if err != nil {
+		log.Warnln(err)
+
+		return nil, err
+	}
+
+	return &AnthropicStream{
+		body:      resp.Body,
+		sse:       NewSSEReader(resp.Body),
+		types:     make(map[int]string),
+		arguments: make(map[int]bool),
+		citations: make(map[int][]AnthropicCitation),
+		current:   make(map[int]int),
+	}, nil
+}
+
+func (s *AnthropicStream) Recv() (*CompletionDelta, error) {
+	for {
+		_, data, err := s.sse.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var event AnthropicEvent
+
+		if err := json.Unmarshal(data, &event); err != nil {
+			return nil, err
+		}
+
+		switch event.Type {
+		case "error":
+			if event.Error != nil {
+				return nil, fmt.Errorf("anthropic %s: %s", event.Error.Type, event.Error.Message)
+			}
+
+			return nil, errors.New("anthropic stream error")
+		case "message_start":
+			if event.Message == nil {
+				continue
+			}
+
+			s.id = event.Message.ID
+			s.model = event.Message.Model
+			s.usage = event.Message.Usage
+
+			return &CompletionDelta{
+				ID: s.id,
+			}, nil
+		case "content_block_start":
+			if delta := s.startBlock(event.Index, event.ContentBlock); delta != nil {
+				return delta, nil
+			}
+		case "content_block_delta":
+			if delta := s.updateBlock(event.Index, event.Delta); delta != nil {
+				return delta, nil
+			}
+		case "content_block_stop":
+			if delta := s.stopBlock(event.Index); delta != nil {
+				return delta, nil
+			}
+		case "message_delta":
+			delta := &CompletionDelta{}
+
+			if event.Delta != nil && event.Delta.StopReason != "" {
+				delta.Finish = anthropicFinishReason(event.Delta.StopReason)
+				delta.Native = event.Delta.StopReason
+			}
+
+			if event.Usage != nil {
+				s.usage.OutputTokens = event.Usage.OutputTokens
+
+				if event.Usage.InputTokens > 0 {
+					s.usage.InputTokens = event.Usage.InputTokens
+				}
+			}
+
+			delta.Usage = s.statistics()
+
+			return delta, nil
+		}
+	}
+}
+
+func (s *AnthropicStream) startBlock(index int, block *AnthropicBlock) *CompletionDelta {
+	if block == nil {
+		return nil
+	}
+
+	s.types[index] = block.Type
+
+	switch block.Type {
+	case "text":
+		if block.Text == "" {
+			return nil
+		}
+
+		return &CompletionDelta{
+			Content: block.Text,
+		}
+	case "thinking":
+		s.current[index] = len(s.thinking)
+
+		s.thinking = append(s.thinking, AnthropicBlock{
+			Type: "thinking",
+		})
+	case "redacted_thinking":
+		s.thinking = append(s.thinking, AnthropicBlock{
+			Type: "redacted_thinking",
+			Data: block.Data,
+		})
+	case "tool_use":
+		if block.Name == anthropicJSONTool {
+			s.types[index] = anthropicJSONTool
+
+			return nil
+		}
+
+		delta := &CompletionDelta{
+			ToolCalls: []CompletionToolCall{{
+				Index: index,
+				ID:    block.ID,
+				Name:  block.Name,
+			}},
+		}
+
+		// thinking preceding the first tool call is stored with it
+		if !s.encrypted && len(s.thinking) > 0 {
+			encoded, err := json.Marshal(s.thinking)
+			if err == nil {
+				delta.Encrypted = []ChatToolReasoning{{
+					Format:    anthropicReasoningFormat,
+					Encrypted: string(encoded),
+				}}
+
+				s.encrypted = true
+			}
+		}
+
+		return delta
+	}
+
+	return nil
+}
+
+func (s *AnthropicStream) updateBlock(index int, delta *AnthropicDelta) *CompletionDelta {
+	if delta == nil {
+		return nil
+	}
+
+	switch delta.Type {
+	case "text_delta":
+		return &CompletionDelta{
+			Content: delta.Text,
+		}
+	case "thinking_delta":
+		if i, ok := s.current[index]; ok {
+			s.thinking[i].Thinking += delta.Thinking
+		}
+
+		return &CompletionDelta{
+			Reasoning:     delta.Thinking,
+			ReasoningType: ReasoningTypeText,
+		}
+	case "signature_delta":
+		if i, ok := s.current[index]; ok {
+			s.thinking[i].Signature += delta.Signature
+		}
+	case "input_json_delta":
+		if delta.PartialJSON == "" {
+			return nil
+		}
+
+		if s.types[index] == anthropicJSONTool {
+			return &CompletionDelta{
+				Content: delta.PartialJSON,
+			}
+		}
+
+		s.arguments[index] = true
+
+		return &CompletionDelta{
+			ToolCalls: []CompletionToolCall{{
+				Index: index,
+				Args:  delta.PartialJSON,
+			}},
+		}
+	case "citations_delta":
+		if delta.Citation != nil {
+			s.citations[index] = append(s.citations[index], *delta.Citation)
+		}
+	}
+
+	return nil
+}
+
+func (s *AnthropicStream) stopBlock(index int) *CompletionDelta {
+	switch s.types[index] {
+	case "tool_use":
+		// tools without parameters stream no input at all
+		if s.arguments[index] {
+			return nil
+		}
+
+		return &CompletionDelta{
+			ToolCalls: []CompletionToolCall{{
+				Index: index,
+				Args:  "{}",
+			}},
+		}
+	case "text":
+		sources := anthropicSources(s.citations[index])
+		if sources == "" {
+			return nil
+		}
+
+		return &CompletionDelta{
+			Content: sources,
+		}
+	}
+
+	return nil
+}
+
+func (s *AnthropicStream) statistics() *Statistics {
+	// input tokens exclude the cached ones
+	input := s.usage.InputTokens + s.usage.CacheCreationInputTokens + s.usage.CacheReadInputTokens
+
+	debug("usage chunk: model=%q provider=%q prompt=%d completion=%d cached=%d", s.model, APIAnthropic, input, s.usage.OutputTokens, s.usage.CacheReadInputTokens)
+
+	return &Statistics{
+		Provider:     APIAnthropic,
+		Model:        s.model,
+		InputTokens:  input,
+		OutputTokens: s.usage.OutputTokens,
+		CachedTokens: s.usage.CacheReadInputTokens,
+	}
+}
+
+func (s *AnthropicStream) Close() error {
+	return s.body.Close()
+}
+
+// anthropicSources renders the citations of a text block as links.
+func anthropicSources(citations []AnthropicCitation) string {
+	var (
+		seen  = make(map[string]bool)
+		links []string
+	)
+
+	for _, citation := range citations {
+		var link string
+
+		switch {
+		case citation.URL != "":
+			title := citation.Title
+			if title == "" {
+				title = citation.URL
+			}
+
+			link = fmt.Sprintf("[%s](%s)", title, citation.URL)
+		case citation.DocumentTitle != "":
+			link = fmt.Sprintf("*%s*", citation.DocumentTitle)
+		default:
+			continue
+		}
+
+		if seen[link] {
+			continue
+		}
+
+		seen[link] = true
+
+		links = append(links, link)
+	}
+
+	if len(links) == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf(" (%s)", strings.Join(links, ", "))
+}
+
+func anthropicFinishReason(reason string) openingrouter.ChatFinishReason {
+	switch reason {
+	case "max_tokens":
+		return openingrouter.ChatFinishReasonLength
+	case "refusal":
+		return openingrouter.ChatFinishReasonContentFilter
+	case "tool_use":
+		return "tool_calls"
+	default:
+		return "stop"
+	}
+}
@@ -0,0 +1,658 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coalaura/openingrouter"
+)
+
+// OpenAI-compatible api, so editors and scripts can use whiskr's prompts,
+// tools, proxies and model filters. Tools run server-side, clients only see
+// the final answer.
+
+type APIContentPart struct {
+	Type     string `json:"type"`
+	Text     string `json:"text"`
+	ImageURL *struct {
+		URL string `json:"url"`
+	} `json:"image_url"`
+}
+
+// APIContent is either a plain string or a list of content parts. Images are
+// turned into markdown images, which the chat parser splits into parts again.
+type APIContent string
+
+type APIToolCall struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+type APIMessage struct {
+	Role       string        `json:"role"`
+	Content    APIContent    `json:"content"`
+	ToolCalls  []APIToolCall `json:"tool_calls"`
+	ToolCallID string        `json:"tool_call_id"`
+}
+
+type APIResponseFormat struct {
+	Type string `json:"type"`
+}
+
+type APIStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type APICompletionRequest struct {
+	Model               string             `json:"model"`
+	Messages            []APIMessage       `json:"messages"`
+	Stream              bool               `json:"stream"`
+	StreamOptions       *APIStreamOptions  `json:"stream_options"`
+	Temperature         *float64           `json:"temperature"`
+	MaxTokens           *int               `json:"max_tokens"`
+	MaxCompletionTokens *int               `json:"max_completion_tokens"`
+	ReasoningEffort     string             `json:"reasoning_effort"`
+	ResponseFormat      *APIResponseFormat `json:"response_format"`
+	Tools               []json.RawMessage  `json:"tools"`
+
+	// whiskr extensions
+	Prompt     string `json:"prompt"`
+	Proxy      string `json:"proxy"`
+	Provider   string `json:"provider"`
+	Search     *bool  `json:"search"`
+	Iterations int64  `json:"iterations"`
+	Timezone   string `json:"timezone"`
+}
+
+type APIModel struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+}
+
+type APIUsage struct {
+	PromptTokens            int     `json:"prompt_tokens"`
+	CompletionTokens        int     `json:"completion_tokens"`
+	TotalTokens             int     `json:"total_tokens"`
+	Cost                    float64 `json:"cost"`
+	CompletionTokensDetails struct {
+		ReasoningTokens int `json:"reasoning_tokens"`
+	} `json:"completion_tokens_details"`
+}
+
+type APIDelta struct {
+	Role      string `json:"role,omitempty"`
+	Content   string `json:"content,omitempty"`
+	Reasoning string `json:"reasoning_content,omitempty"`
+}
+
+type APIChoice struct {
+	Index        int       `json:"index"`
+	Message      *APIDelta `json:"message,omitempty"`
+	Delta        *APIDelta `json:"delta,omitempty"`
+	FinishReason *string   `json:"finish_reason"`
+}
+
+type APICompletion struct {
+	ID      string      `json:"id"`
+	Object  string      `json:"object"`
+	Created int64       `json:"created"`
+	Model   string      `json:"model"`
+	Choices []APIChoice `json:"choices"`
+	Usage   *APIUsage   `json:"usage,omitempty"`
+}
+
+// APIWriter collects the chunks of a chat and, when streaming, forwards text
+// and reasoning as chat.completion.chunk events.
+type APIWriter struct {
+	mx  sync.Mutex
+	wr  http.ResponseWriter
+	ctx context.Context
+
+	stream   bool
+	id       string
+	model    string
+	created  int64
+	text     strings.Builder
+	reason   strings.Builder
+	usage    APIUsage
+	err      string
+	finish   string
+	separate bool
+}
+
+// native finish reasons reported as length or content_filter
+var apiFinishReasons = map[string]string{
+	"MAX_TOKENS":                    "length",
+	"model_context_window_exceeded": "length",
+
+	"SAFETY":                   "content_filter",
+	"BLOCKLIST":                "content_filter",
+	"PROHIBITED_CONTENT":       "content_filter",
+	"SPII":                     "content_filter",
+	"RECITATION":               "content_filter",
+	"MODEL_ARMOR":              "content_filter",
+	"IMAGE_SAFETY":             "content_filter",
+	"IMAGE_PROHIBITED_CONTENT": "content_filter",
+	"IMAGE_RECITATION":         "content_filter",
+}
+
+func (c *APIContent) UnmarshalJSON(data []byte) error {
+	var text string
+
+	if json.Unmarshal(data, &text) == nil {
+		*c = APIContent(text)
+
+		return nil
+	}
+
+	var parts []APIContentPart
+
+	if err := json.Unmarshal(data, &parts); err != nil {
+		return errors.New("content must be a string or a list of content parts")
+	}
+
+	var result []string
+
+	for _, part := range parts {
+		switch part.Type {
+		case "text":
+			result = append(result, part.Text)
+		case "image_url":
+			if part.ImageURL == nil || part.ImageURL.URL == "" {
+				return errors.New("image_url part without url")
+			}
+
+			result = append(result, fmt.Sprintf("![image](%s)", part.ImageURL.URL))
+		default:
+			return fmt.Errorf("unsupported content part %q", part.Type)
+		}
+	}
+
+	*c = APIContent(strings.Join(result, "\n\n"))
+
+	return nil
+}
+
+func RespondAPIError(w http.ResponseWriter, status int, typ, message string) {
+	RespondJson(w, status, map[string]any{
+		"error": map[string]any{
+			"message": message,
+			"type":    typ,
+		},
+	})
+}
+
+func HandleAPIModels(w http.ResponseWriter, r *http.Request) {
+	modelMx.RLock()
+	defer modelMx.RUnlock()
+
+	models := make([]APIModel, len(ModelList))
+
+	for i, model := range ModelList {
+		owner, _, _ := strings.Cut(model.Upstream(), "/")
+
+		if model.Backend != "" {
+			owner = model.Backend
+		}
+
+		models[i] = APIModel{
+			ID:      model.Slug,
+			Object:  "model",
+			Created: model.Created,
+			OwnedBy: owner,
+		}
+	}
+
+	RespondJson(w, http.StatusOK, map[string]any{
+		"object": "list",
+		"data":   models,
+	})
+}
+
+// Chat converts the request into a whiskr chat request.
+func (c *APICompletionRequest) Chat() (*ChatRequest, error) {
+	if len(c.Tools) > 0 {
+		return nil, errors.New("client-side tools are not supported, whiskr runs its own tools (disable them with \"search\": false)")
+	}
+
+	model := GetModel(c.Model)
+	if model == nil {
+		return nil, fmt.Errorf("unknown model: %q", c.Model)
+	}
+
+	raw := ChatRequest{
+		ProxyName:   c.Proxy,
+		Prompt:      c.Prompt,
+		Model:       c.Model,
+		Provider:    c.Provider,
+		Temperature: 1,
+		Iterations:  c.Iterations,
+		Reasoning:   c.ReasoningEffort,
+		Image: ChatImage{
+			MaxImages: env.Settings.MaxImages,
+		},
+		Tools: ChatTools{
+			Search: c.Search == nil || *c.Search,
+			JSON:   c.ResponseFormat != nil && c.ResponseFormat.Type == "json_object",
+		},
+		Metadata: ChatMetadata{
+			Timezone: c.Timezone,
+			Platform: "API",
+		},
+	}
+
+	if c.Temperature != nil {
+		raw.Temperature = *c.Temperature
+	}
+
+	if raw.Iterations == 0 {
+		raw.Iterations = env.Settings.Iterations
+	}
+
+	// pick a sensible default, the frontend always sends an effort
+	if raw.Reasoning == "" && len(model.ReasoningLevels) > 0 {
+		raw.Reasoning = model.ReasoningLevels[0]
+
+		if slices.Contains(model.ReasoningLevels, "medium") {
+			raw.Reasoning = "medium"
+		}
+	}
+
+	results := make(map[string]string)
+
+	for _, message := range c.Messages {
+		if message.Role == "tool" {
+			results[message.ToolCallID] = string(message.Content)
+		}
+	}
+
+	for i, message := range c.Messages {
+		switch message.Role {
+		case "system", "developer":
+			raw.Messages = append(raw.Messages, ChatMessage{
+				Role: "system",
+				Text: string(message.Content),
+			})
+		case "user":
+			raw.Messages = append(raw.Messages, ChatMessage{
+				Role: "user",
+				Text: string(message.Content),
+			})
+		case "assistant":
+			if len(message.ToolCalls) == 0 {
+				raw.Messages = append(raw.Messages, ChatMessage{
+					Role: "assistant",
+					Text: string(message.Content),
+				})
+
+				continue
+			}
+
+			// whiskr stores one tool call per message
+			for n, call := range message.ToolCalls {
+				result, ok := results[call.ID]
+				if !ok {
+					return nil, fmt.Errorf("message %d: tool call %q has no result", i, call.ID)
+				}
+
+				var text string
+
+				if n == 0 {
+					text = string(message.Content)
+				}
+
+				raw.Messages = append(raw.Messages, ChatMessage{
+					Role: "assistant",
+					Text: text,
+					Tool: &ChatToolCall{
+						ID:     call.ID,
+						Name:   call.Function.Name,
+						Args:   call.Function.Arguments,
+						Result: result,
+						Done:   true,
+					},
+				})
+			}
+		case "tool":
+			// attached to its assistant message above
+		default:
+			return nil, fmt.Errorf("message %d: invalid role %q", i, message.Role)
+		}
+	}
+
+	return &raw, nil
+}
+
+func HandleAPICompletion(w http.ResponseWriter, r *http.Request) {
+	var completion APICompletionRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&completion); err != nil {
+		RespondAPIError(w, http.StatusBadRequest, "invalid_request_error", err.Error())
+
+		return
+	}
+
+	raw, err := completion.Chat()
+	if err != nil {
+		RespondAPIError(w, http.StatusBadRequest, "invalid_request_error", err.Error())
+
+		return
+	}
+
+	request, err := raw.Parse()
+	if err != nil {
+		RespondAPIError(w, http.StatusBadRequest, "invalid_request_error", err.Error())
+
+		return
+	}
+
+	if completion.MaxCompletionTokens != nil {
+		request.MaxTokens = completion.MaxCompletionTokens
+	} else if completion.MaxTokens != nil {
+		request.MaxTokens = completion.MaxTokens
+	}
+
+	id, err := CreateSecret(12)
+	if err != nil {
+		RespondAPIError(w, http.StatusInternalServerError, "server_error", err.Error())
+
+		return
+	}
+
+	writer := &APIWriter{
+		wr:      w,
+		ctx:     r.Context(),
+		stream:  completion.Stream,
+		id:      "chatcmpl-" + id,
+		model:   raw.Model,
+		created: time.Now().Unix(),
+	}
+
+	var user string
+
+	if u := GetAPIUser(r); u != nil {
+		user = u.Username
+	}
+
+	debug("api completion for %q (stream: %v)", user, completion.Stream)
+
+	if completion.Stream {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+
+		writer.WriteDelta(APIDelta{
+			Role: "assistant",
+		}, nil)
+
+		ctx, cancel := context.WithCancel(r.Context())
+
+		go writer.KeepAlive(ctx, 15*time.Second)
+
+		RunChat(r.Context(), writer, raw, request, nil)
+
+		cancel()
+
+		writer.Finish(completion.StreamOptions != nil && completion.StreamOptions.IncludeUsage)
+
+		return
+	}
+
+	RunChat(r.Context(), writer, raw, request, nil)
+
+	if writer.err != "" {
+		RespondAPIError(w, http.StatusBadGateway, "upstream_error", writer.err)
+
+		return
+	}
+
+	stop := writer.FinishReason()
+
+	RespondJson(w, http.StatusOK, APICompletion{
+		ID:      writer.id,
+		Object:  "chat.completion",
+		Created: writer.created,
+		Model:   writer.model,
+		Choices: []APIChoice{
+			{
+				Message: &APIDelta{
+					Role:      "assistant",
+					Content:   writer.text.String(),
+					Reasoning: writer.reason.String(),
+				},
+				FinishReason: &stop,
+			},
+		},
+		Usage: writer.Usage(),
+	})
+}
+
+func (a *APIWriter) WriteChunk(chunk *Chunk) error {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+
+	switch chunk.Type {
+	case ChunkStart:
+		// keep the text of separate iterations apart
+		a.separate = a.text.Len() > 0
+
+		a.finish = ""
+	case ChunkText, ChunkImage:
+		text, _ := chunk.Data.(string)
+
+		if chunk.Type == ChunkImage {
+			text = fmt.Sprintf("![image](%s)", text)
+		}
+
+		if text == "" {
+			return nil
+		}
+
+		if a.separate {
+			text = "\n\n" + text
+
+			a.separate = false
+		}
+
+		a.text.WriteString(text)
+
+		return a.writeDelta(APIDelta{
+			Content: text,
+		}, nil)
+	case ChunkReasoning:
+		text, _ := chunk.Data.(string)
+
+		a.reason.WriteString(text)
+
+		return a.writeDelta(APIDelta{
+			Reasoning: text,
+		}, nil)
+	case ChunkUsage:
+		statistics, ok := chunk.Data.(Statistics)
+		if !ok {
+			return nil
+		}
+
+		a.usage.PromptTokens += statistics.InputTokens
+		a.usage.CompletionTokens += statistics.OutputTokens
+		a.usage.Cost += statistics.Cost
+		a.usage.CompletionTokensDetails.ReasoningTokens += statistics.ReasoningTokens
+	case ChunkError:
+		// the generation continues after notices
+		var notice *CompletionNotice
+
+		if errors.As(chunk.err, &notice) {
+			a.finish = APIFinishReason(notice)
+
+			return nil
+		}
+
+		a.err, _ = chunk.Data.(string)
+	}
+
+	return nil
+}
+
+// FinishReason returns the finish reason of the last completion.
+func (a *APIWriter) FinishReason() string {
+	if a.finish == "" {
+		return "stop"
+	}
+
+	return a.finish
+}
+
+// APIFinishReason maps a completion stopping early to an openai finish reason.
+func APIFinishReason(notice *CompletionNotice) string {
+	switch notice.Finish {
+	case openingrouter.ChatFinishReasonLength:
+		return "length"
+	case openingrouter.ChatFinishReasonContentFilter:
+		return "content_filter"
+	}
+
+	if reason, ok := apiFinishReasons[notice.Native]; ok {
+		return reason
+	}
+
+	return "stop"
+}
+
+func (a *APIWriter) Usage() *APIUsage {
+	usage := a.usage
+
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+
+	return &usage
+}
+
+func (a *APIWriter) WriteDelta(delta APIDelta, finish *string) error {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+
+	return a.writeDelta(delta, finish)
+}
+
+func (a *APIWriter) writeDelta(delta APIDelta, finish *string) error {
+	if !a.stream {
+		return nil
+	}
+
+	return a.writeEvent(APICompletion{
+		ID:      a.id,
+		Object:  "chat.completion.chunk",
+		Created: a.created,
+		Model:   a.model,
+		Choices: []APIChoice{
+			{
+				Delta:        &delta,
+				FinishReason: finish,
+			},
+		},
+	})
+}
+
+func (a *APIWriter) writeEvent(data any) error {
+	if err := a.ctx.Err(); err != nil {
+		return err
+	}
+
+	buf := GetFreeBuffer()
+	defer pool.Put(buf)
+
+	if data == nil {
+		buf.WriteString(": alive\n\n")
+	} else {
+		buf.WriteString("data: ")
+
+		enc := json.NewEncoder(buf)
+		enc.SetEscapeHTML(false)
+
+		if err := enc.Encode(data); err != nil {
+			return err
+		}
+
+		buf.WriteString("\n")
+	}
+
+	if _, err := a.wr.Write(buf.Bytes()); err != nil {
+		return err
+	}
+
+	if flusher, ok := a.wr.(http.Flusher); ok {
+		flusher.Flush()
+	}
+
+	return nil
+}
+
+// KeepAlive writes sse comments while tools run, so clients don't time out.
+func (a *APIWriter) KeepAlive(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.mx.Lock()
+			a.writeEvent(nil)
+			a.mx.Unlock()
+		}
+	}
+}
+
+// Finish ends a streamed completion with the finish reason (or the error),
+// the optional usage chunk and the terminating [DONE] event.
+func (a *APIWriter) Finish(usage bool) {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+
+	if a.ctx.Err() != nil {
+		return
+	}
+
+	if a.err != "" {
+		a.writeEvent(map[string]any{
+			"error": map[string]any{
+				"message": a.err,
+				"type":    "upstream_error",
+			},
+		})
+	} else {
+		stop := a.FinishReason()
+
+		a.writeDelta(APIDelta{}, &stop)
+
+		if usage {
+			a.writeEvent(APICompletion{
+				ID:      a.id,
+				Object:  "chat.completion.chunk",
+				Created: a.created,
+				Model:   a.model,
+				Choices: []APIChoice{},
+				Usage:   a.Usage(),
+			})
+		}
+	}
+
+	a.wr.Write([]byte("data: [DONE]\n\n"))
+
+	if flusher, ok := a.wr.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Approval actions
+const (
+	ApprovalApprove = "approve"
+	ApprovalDeny    = "deny"
+	ApprovalEdit    = "edit"
+)
+
+type ApprovalChunk struct {
+	ID      string `json:"id" msgpack:"id"`
+	Tool    string `json:"tool" msgpack:"tool"`
+	Name    string `json:"name" msgpack:"name"`
+	Args    any    `json:"args" msgpack:"args"`
+	Timeout int64  `json:"timeout" msgpack:"timeout"`
+}
+
+type ToolApproval struct {
+	ID     string          `json:"id"`
+	Action string          `json:"action"`
+	Args   json.RawMessage `json:"args,omitempty"`
+	Reason string          `json:"reason,omitempty"`
+}
+
+// ToolApprover is implemented by chunk writers that can ask the client to
+// approve a tool call.
+type ToolApprover interface {
+	AwaitApproval(ctx context.Context, call *ChatToolCall, timeout time.Duration) (*ToolApproval, error)
+}
+
+var ErrApprovalTimeout = errors.New("approval timed out")
+
+func RequiresApproval(name string) bool {
+	return slices.Contains(env.Tools.Approval.Tools, name)
+}
+
+// AwaitApproval writes an approval request for the call and blocks until the
+// client responds, the timeout passes or the generation is cancelled.
+func (j *ChatJob) AwaitApproval(ctx context.Context, call *ChatToolCall, timeout time.Duration) (*ToolApproval, error) {
+	id, err := CreateSecret(8)
+	if err != nil {
+		return nil, err
+	}
+
+	var args any
+
+	if json.Unmarshal([]byte(call.Args), &args) != nil {
+		args = call.Args
+	}
+
+	response := make(chan *ToolApproval, 1)
+
+	j.mx.Lock()
+
+	if j.approvals == nil {
+		j.approvals = make(map[string]chan *ToolApproval)
+	}
+
+	j.approvals[id] = response
+
+	j.mx.Unlock()
+
+	defer func() {
+		j.mx.Lock()
+		delete(j.approvals, id)
+		j.mx.Unlock()
+	}()
+
+	j.WriteChunk(NewChunk(ChunkApproval, ApprovalChunk{
+		ID:      id,
+		Tool:    call.ID,
+		Name:    call.Name,
+		Args:    args,
+		Timeout: int64(timeout / time.Second),
+	}))
+
+	debug("waiting for approval of %s (%s)", call.Name, id)
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case approval := <-response:
+		return approval, nil
+	case <-timer.C:
+		return nil, ErrApprovalTimeout
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Approve resolves a pending approval, returning false if there is none.
+func (j *ChatJob) Approve(approval *ToolApproval) bool {
+	j.mx.Lock()
+	defer j.mx.Unlock()
+
+	response, ok := j.approvals[approval.ID]
+	if !ok {
+		return false
+	}
+
+	delete(j.approvals, approval.ID)
+
+	response <- approval
+
+	return true
+}
+
+// RequestApproval asks the client to approve a tool call and applies the
+// decision. It returns false if the call must not run, in which case its
+// result explains why.
+func (r *ChatRequest) RequestApproval(ctx context.Context, response ChunkWriter, call *ChatToolCall) bool {
+	approver, ok := response.(ToolApprover)
+	if !ok {
+		call.Result = "error: this tool requires approval, which is unavailable for this request"
+
+		return false
+	}
+
+	timeout := time.Duration(env.Tools.Approval.Timeout) * time.Second
+
+	approval, err := approver.AwaitApproval(ctx, call, timeout)
+	if err != nil {
+		debug("approval of %s failed: %v", call.Name, err)
+
+		if errors.Is(err, ErrApprovalTimeout) {
+			call.Result = "error: the user did not approve this tool call in time"
+		} else {
+			call.Result = fmt.Sprintf("error: %v", err)
+		}
+
+		return false
+	}
+
+	debug("%s %s", approval.Action, call.Name)
+
+	switch approval.Action {
+	case ApprovalDeny:
+		call.Result = "error: the user denied this tool call"
+
+		if approval.Reason != "" {
+			call.Result += ": " + approval.Reason
+		}
+
+		return false
+	case ApprovalEdit:
+		call.Args = string(approval.Args)
+	}
+
+	return true
+}
+
+// Validate checks the action of an approval and normalizes it.
+func (a *ToolApproval) Validate() error {
+	a.Reason = strings.TrimSpace(a.Reason)
+
+	switch a.Action {
+	case ApprovalApprove, ApprovalDeny:
+		a.Args = nil
+	case ApprovalEdit:
+		var args map[string]any
+
+		if err := json.Unmarshal(a.Args, &args); err != nil || args == nil {
+			return errors.New("edited arguments must be a json object")
+		}
+	default:
+		return fmt.Errorf("invalid action %q", a.Action)
+	}
+
+	return nil
+}
+
+func HandleChatApprove(w http.ResponseWriter, r *http.Request) {
+	job := GetChatJob(chi.URLParam(r, "id"), GetUsername(r), GetSession(r))
+	if job == nil {
+		RespondJson(w, http.StatusNotFound, map[string]any{
+			"error": ErrJobNotFound.Error(),
+		})
+
+		return
+	}
+
+	var approval ToolApproval
+
+	if err := json.NewDecoder(r.Body).Decode(&approval); err != nil {
+		RespondJson(w, http.StatusBadRequest, map[string]any{
+			"error": err.Error(),
+		})
+
+		return
+	}
+
+	if err := approval.Validate(); err != nil {
+		RespondJson(w, http.StatusBadRequest, map[string]any{
+			"error": err.Error(),
+		})
+
+		return
+	}
+
+	if !job.Approve(&approval) {
+		RespondJson(w, http.StatusConflict, map[string]any{
+			"error": "no pending approval",
+		})
+
+		return
+	}
+
+	RespondJson(w, http.StatusOK, map[string]any{
+		"ok": true,
+	})
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/sha3"
 	"encoding/hex"
@@ -14,6 +15,8 @@ import (
 	"github.com/coalaura/whiskr/internal/desktop"
 )
 
+type sessionKey struct{}
+
 type AuthenticationRequest struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
@@ -44,6 +47,28 @@ func (e *Environment) GetUser(username string) *EnvUser {
 	return user
 }
 
+// HashAPIKey returns the hash an api key is stored as. Keys are random, so a
+// plain hash is enough (unlike passwords).
+func HashAPIKey(key string) string {
+	hash := NewHash()
+
+	hash.Write([]byte(key))
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func (e *Environment) GetKeyUser(key string) *EnvUser {
+	e.dmx.RLock()
+	defer e.dmx.RUnlock()
+
+	user, ok := e.Authentication.keys[HashAPIKey(key)]
+	if !ok {
+		return nil
+	}
+
+	return user
+}
+
 func (e *Environment) Authenticate(username, password string) *EnvUser {
 	user := e.GetUser(username)
 	if user == nil {
@@ -105,6 +130,56 @@ func GetAuthenticatedUser(r *http.Request) *EnvUser {
 	return env.VerifyAuthToken(cookie.Value)
 }
 
+// GetUsername returns the authenticated username or an empty string if there is
+// no authenticated user (e.g. authentication is disabled).
+func GetUsername(r *http.Request) string {
+	user := GetAuthenticatedUser(r)
+	if user == nil {
+		return ""
+	}
+
+	return user.Username
+}
+
+// Session gives every browser a random session id, which generations are bound
+// to (with authentication disabled there is no user to tell clients apart).
+func Session(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var session string
+
+		if cookie, err := r.Cookie("whiskr_session"); err == nil && len(cookie.Value) == 64 {
+			session = cookie.Value
+		} else {
+			session, err = CreateSecret(32)
+			if err != nil {
+				RespondJson(w, http.StatusInternalServerError, map[string]any{
+					"error": err.Error(),
+				})
+
+				return
+			}
+
+			http.SetCookie(w, &http.Cookie{
+				Name:     "whiskr_session",
+				Value:    session,
+				Path:     "/",
+				MaxAge:   365 * 24 * 60 * 60,
+				HttpOnly: true,
+				SameSite: http.SameSiteStrictMode,
+			})
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), sessionKey{}, session)))
+	})
+}
+
+// GetSession returns the session id assigned by the Session middleware.
+func GetSession(r *http.Request) string {
+	session, _ := r.Context().Value(sessionKey{}).(string)
+
+	return session
+}
+
 func IsAuthenticated(r *http.Request) bool {
 	if !env.Authentication.Enabled {
 		return true
@@ -113,6 +188,18 @@ func IsAuthenticated(r *http.Request) bool {
 	return GetAuthenticatedUser(r) != nil
 }
 
+// IsAdmin reports whether the request may perform administrative actions.
+// Without authentication everyone is considered an admin.
+func IsAdmin(r *http.Request) bool {
+	if !env.Authentication.Enabled {
+		return true
+	}
+
+	user := GetAuthenticatedUser(r)
+
+	return user != nil && user.Admin
+}
+
 func Authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if !IsAuthenticated(r) {
@@ -127,6 +214,30 @@ func Authenticate(next http.Handler) http.Handler {
 	})
 }
 
+// GetAPIUser returns the user of the api key in the Authorization header.
+func GetAPIUser(r *http.Request) *EnvUser {
+	key, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || key == "" {
+		return nil
+	}
+
+	return env.GetKeyUser(strings.TrimSpace(key))
+}
+
+// AuthenticateKey guards the openai-compatible api, which uses api keys
+// instead of the login cookie.
+func AuthenticateKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if env.Authentication.Enabled && GetAPIUser(r) == nil {
+			RespondAPIError(w, http.StatusUnauthorized, "invalid_api_key", "invalid or missing api key")
+
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 func HandleAuthentication(w http.ResponseWriter, r *http.Request) {
 	var request AuthenticationRequest
 
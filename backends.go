@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"strings"
+)
+
+var (
+	backendNameRgx = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+	// default base urls of the backend types
+	backendBaseURLs = map[string]string{
+		APIOpenRouter: "https://openrouter.ai/api/v1/",
+		APIOpenAI:     "https://api.openai.com/v1/",
+		APIOllama:     "http://localhost:11434/",
+		APIAnthropic:  "https://api.anthropic.com/v1/",
+		APIGemini:     "https://generativelanguage.googleapis.com/v1beta/",
+		APIResponses:  "https://api.openai.com/v1/",
+	}
+)
+
+// Backends returns all configured llm backends. The first one is the primary
+// backend, its model slugs are used as is.
+func Backends() []*EnvBackend {
+	return env.LLM.backends
+}
+
+func PrimaryBackend() *EnvBackend {
+	return env.LLM.backends[0]
+}
+
+func GetBackend(name string) *EnvBackend {
+	for _, backend := range env.LLM.backends {
+		if backend.Name == name {
+			return backend
+		}
+	}
+
+	return nil
+}
+
+// OpenRouterBackend returns the first openrouter backend, which is used for
+// openrouter-only features like text-to-speech and usage.
+func OpenRouterBackend() *EnvBackend {
+	for _, backend := range env.LLM.backends {
+		if backend.IsOpenRouter() {
+			return backend
+		}
+	}
+
+	return nil
+}
+
+// SplitModelSlug returns the backend owning a (possibly qualified) model slug
+// and the slug the backend knows the model by.
+func SplitModelSlug(slug string) (*EnvBackend, string) {
+	if name, rest, ok := strings.Cut(slug, ":"); ok {
+		backend := GetBackend(name)
+
+		if backend != nil && !backend.primary {
+			return backend, rest
+		}
+	}
+
+	return PrimaryBackend(), slug
+}
+
+// HasEmbeddings reports whether the backend serves an openai-compatible
+// embeddings endpoint.
+func (b *EnvBackend) HasEmbeddings() bool {
+	return b.Type != APIAnthropic && b.Type != APIGemini
+}
+
+func (b *EnvBackend) IsOpenRouter() bool {
+	return b.Type == APIOpenRouter
+}
+
+// Qualify prefixes a model slug with the backend name, unless this is the
+// primary backend.
+func (b *EnvBackend) Qualify(slug string) string {
+	if b.primary {
+		return slug
+	}
+
+	return b.Name + ":" + slug
+}
+
+// ResolveProxy returns the proxy to use for a request. A backend's own proxy
+// is required to reach it, so it takes precedence over the selected one.
+func (b *EnvBackend) ResolveProxy(proxy *EnvProxy) *EnvProxy {
+	if b.proxy != nil {
+		return b.proxy
+	}
+
+	return proxy
+}
+
+// ListModels returns the chat and text-to-speech models of the backend with
+// unqualified slugs.
+func (b *EnvBackend) ListModels(ctx context.Context) ([]*Model, []*Model, error) {
+	switch b.Type {
+	case APIOpenAI, APIResponses:
+		return LoadOpenAIModels(ctx, b)
+	case APIOllama:
+		return LoadOllamaModels(ctx, b)
+	case APIAnthropic:
+		return LoadAnthropicModels(ctx, b)
+	case APIGemini:
+		return LoadGeminiModels(ctx, b)
+	default:
+		return LoadOpenRouterModels(ctx, b)
+	}
+}
+
+// GetBackend returns the backend owning the model.
+func (m *Model) GetBackend() *EnvBackend {
+	if m.Backend != "" {
+		if backend := GetBackend(m.Backend); backend != nil {
+			return backend
+		}
+	}
+
+	return PrimaryBackend()
+}
+
+// Upstream returns the slug the owning backend knows the model by.
+func (m *Model) Upstream() string {
+	if m.Backend == "" {
+		return m.Slug
+	}
+
+	return strings.TrimPrefix(m.Slug, m.Backend+":")
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type BraveProvider struct {
+	token string
+}
+
+type BraveResult struct {
+	Title         string   `json:"title"`
+	URL           string   `json:"url"`
+	Description   string   `json:"description"`
+	Age           string   `json:"age"`
+	PageAge       string   `json:"page_age"`
+	ExtraSnippets []string `json:"extra_snippets"`
+	Profile       struct {
+		Name string `json:"name"`
+	} `json:"profile"`
+}
+
+type BraveResponse struct {
+	Web struct {
+		Results []BraveResult `json:"results"`
+	} `json:"web"`
+
+	// news search returns its results at the top level
+	Results []BraveResult `json:"results"`
+}
+
+var braveFreshness = map[string]string{
+	"day":   "pd",
+	"week":  "pw",
+	"month": "pm",
+	"year":  "py",
+}
+
+func (p *BraveProvider) Name() string {
+	return "brave"
+}
+
+func (p *BraveProvider) Search(ctx context.Context, query string, args *SearchWebArguments) (*SearchResponse, error) {
+	params := url.Values{}
+
+	params.Set("q", SiteFilterQuery(query, args.IncludeDomains, args.ExcludeDomains))
+	params.Set("count", fmt.Sprint(args.MaxResults))
+
+	if args.Depth == "thorough" {
+		params.Set("extra_snippets", "true")
+	}
+
+	if freshness, ok := braveFreshness[args.TimeRange]; ok {
+		params.Set("freshness", freshness)
+	} else if args.StartDate != "" || args.EndDate != "" {
+		start, end := args.StartDate, args.EndDate
+
+		if start == "" {
+			start = "1970-01-01"
+		}
+
+		if end == "" {
+			end = time.Now().Format("2006-01-02")
+		}
+
+		params.Set("freshness", start+"to"+end)
+	}
+
+	endpoint := "web"
+
+	if args.Topic == "news" {
+		endpoint = "news"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("https://api.search.brave.com/res/v1/%s/search?%s", endpoint, params.Encode()), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-Subscription-Token", p.token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+
+		return nil, fmt.Errorf("brave api error (%d): %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var response BraveResponse
+
+	err = json.NewDecoder(resp.Body).Decode(&response)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := response.Web.Results
+	if len(entries) == 0 {
+		entries = response.Results
+	}
+
+	results := &SearchResponse{
+		Results: make([]TavilyResult, 0, len(entries)),
+	}
+
+	for i, result := range entries {
+		summary := result.Description
+
+		if len(result.ExtraSnippets) > 0 {
+			summary += "\n" + strings.Join(result.ExtraSnippets, "\n")
+		}
+
+		published := result.PageAge
+		if published == "" {
+			published = result.Age
+		}
+
+		results.Results = append(results.Results, TavilyResult{
+			Title:         result.Title,
+			URL:           result.URL,
+			PublishedDate: published,
+			SiteName:      result.Profile.Name,
+			Score:         RankScore(i),
+			Summary:       summary,
+		})
+	}
+
+	return results, nil
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ToolBudget limits how many tokens tool results add to the context of a
+// single chat request (across all of its iterations).
+type ToolBudget struct {
+	request   int
+	remaining int
+}
+
+func NewToolBudget(model *Model) *ToolBudget {
+	request := int(env.Tools.Budget.Request)
+
+	// leave room for the conversation itself on small models
+	if model.Context.Total > 0 {
+		limit := model.Context.Total / 2
+
+		if request == 0 || limit < request {
+			request = limit
+		}
+	}
+
+	return &ToolBudget{
+		request:   request,
+		remaining: request,
+	}
+}
+
+// Limit returns the token limit for the next result of a tool, 0 means
+// unlimited.
+func (b *ToolBudget) Limit(name string) int {
+	limit := int(env.Tools.Budget.Tool)
+
+	if override, ok := env.Tools.Budget.Tools[name]; ok {
+		limit = int(override)
+	}
+
+	if b.request > 0 && (limit == 0 || b.remaining < limit) {
+		limit = b.remaining
+	}
+
+	return limit
+}
+
+// Apply trims the result of a finished tool call to the budget and deducts
+// its tokens from the request budget.
+func (b *ToolBudget) Apply(call *ChatToolCall) {
+	if b == nil || call.Result == "" {
+		return
+	}
+
+	// too little is left for a useful result
+	if b.request > 0 && b.remaining < 100 {
+		call.Result = fmt.Sprintf("error: the tool result budget of %d tokens for this request is exhausted, answer with the information gathered so far", b.request)
+
+		return
+	}
+
+	limit := b.Limit(call.Name)
+	if limit == 0 {
+		return
+	}
+
+	result, tokens := FitToolResult(call.Result, limit)
+
+	if result != call.Result {
+		debug("trimmed %s result to %d tokens (limit %d)", call.Name, tokens, limit)
+	}
+
+	call.Result = result
+
+	b.remaining -= tokens
+}
+
+// FitToolResult trims a tool result to at most limit tokens and returns it
+// with its token count. Search and fetch results are trimmed by relevance,
+// everything else is cut off.
+func FitToolResult(result string, limit int) (string, int) {
+	tokens := CountTokensUpTo(result, limit)
+	if tokens <= limit {
+		return result, tokens
+	}
+
+	if strings.HasPrefix(result, "{") {
+		var results TavilyResults
+
+		if json.Unmarshal([]byte(result), &results) == nil && len(results.Results) > 0 && results.Results[0].URL != "" {
+			return FitSearchResults(results.Results, limit)
+		}
+	}
+
+	// space for the note
+	text := TruncateTokens(result, limit-40)
+
+	text += fmt.Sprintf("\n\n(result truncated to fit the budget of %d tokens, only the first %d of %d bytes are shown)", limit, len(text), len(result))
+
+	return text, tokenizer.CountTokens(text)
+}
+
+// FitSearchResults drops the least relevant results first and then shortens
+// the remaining texts, giving every result an equal share of the budget.
+func FitSearchResults(results []TavilyResult, limit int) (string, int) {
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+
+	// space for the note and json structure
+	available := limit - 80
+
+	sizes := make([]int, len(results))
+	texts := make([]int, len(results))
+
+	var total int
+
+	for i := range results {
+		body := results[i].Body()
+		text := *body
+
+		// the text is counted separately, it may be very long
+		*body = ""
+
+		encoded, _ := json.Marshal(results[i])
+
+		*body = text
+
+		texts[i] = CountTokensUpTo(text, available)
+		sizes[i] = tokenizer.CountTokens(string(encoded)) + texts[i]
+
+		total += sizes[i]
+	}
+
+	var dropped, truncated int
+
+	// fetched pages have no score, they are only shortened
+	for len(results) > 1 && total > available && results[len(results)-1].Score > 0 {
+		last := len(results) - 1
+
+		total -= sizes[last]
+
+		results = results[:last]
+		sizes = sizes[:last]
+		texts = texts[:last]
+
+		dropped++
+	}
+
+	if total > available {
+		var fixed int
+
+		for i := range results {
+			fixed += sizes[i] - texts[i]
+		}
+
+		// short texts are kept whole, the rest is split between the long ones
+		order := make([]int, len(results))
+
+		for i := range order {
+			order[i] = i
+		}
+
+		sort.Slice(order, func(i, j int) bool {
+			return texts[order[i]] < texts[order[j]]
+		})
+
+		remaining := max(available-fixed, 0)
+
+		for n, i := range order {
+			share := max(remaining/(len(order)-n), 50)
+
+			if texts[i] > share {
+				body := results[i].Body()
+
+				*body = TruncateTokens(*body, share) + " [...]"
+
+				truncated++
+
+				remaining -= share
+			} else {
+				remaining -= texts[i]
+			}
+		}
+	}
+
+	buf := GetFreeBuffer()
+	defer pool.Put(buf)
+
+	response := map[string]any{
+		"results": results,
+	}
+
+	if dropped > 0 || truncated > 0 {
+		var parts []string
+
+		if dropped > 0 {
+			parts = append(parts, fmt.Sprintf("%d less relevant results were omitted", dropped))
+		}
+
+		if truncated > 0 {
+			parts = append(parts, fmt.Sprintf("%d results were shortened", truncated))
+		}
+
+		response["note"] = fmt.Sprintf("%s to fit the budget of %d tokens; fetch fewer urls at once or use more specific queries for details", strings.Join(parts, " and "), limit)
+	}
+
+	json.NewEncoder(buf).Encode(response)
+
+	text := buf.String()
+
+	return text, tokenizer.CountTokens(text)
+}
+
+// CountTokensUpTo counts the tokens of a text. Texts much longer than the limit
+// are only estimated, since they exceed it either way.
+func CountTokensUpTo(text string, limit int) int {
+	// tokens are rarely longer than 8 bytes
+	if len(text) > limit*8 {
+		return len(text) / 4
+	}
+
+	return tokenizer.CountTokens(text)
+}
+
+// Body returns the main text of a result, the full text if available and the
+// snippet otherwise.
+func (r *TavilyResult) Body() *string {
+	if r.Text != "" {
+		return &r.Text
+	}
+
+	return &r.Summary
+}
+
+// TruncateTokens cuts text to at most limit tokens, preferring to cut at a
+// line break.
+func TruncateTokens(text string, limit int) string {
+	if limit <= 0 {
+		return ""
+	}
+
+	if len(text) <= limit {
+		return text
+	}
+
+	// a longer prefix would exceed the limit anyway
+	cut := text[:min(len(text), limit*8)]
+
+	for range 8 {
+		tokens := tokenizer.CountTokens(cut)
+		if tokens <= limit {
+			break
+		}
+
+		cut = cut[:len(cut)*limit/tokens*19/20]
+	}
+
+	cut = strings.ToValidUTF8(cut, "")
+
+	if len(cut) < len(text) {
+		if index := strings.LastIndexByte(cut, '\n'); index > len(cut)*4/5 {
+			cut = cut[:index]
+		}
+	}
+
+	return strings.TrimRight(cut, " \t\r\n")
+}
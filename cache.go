@@ -0,0 +1,396 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type ToolCache struct {
+	mx  sync.Mutex
+	dir string
+}
+
+type ToolCacheEntry struct {
+	Tool    string `json:"tool"`
+	Args    string `json:"args"`
+	Result  string `json:"result"`
+	Created int64  `json:"created"`
+	Expires int64  `json:"expires"`
+}
+
+// ToolCacheResponse is a cached http response used for conditional requests.
+type ToolCacheResponse struct {
+	ETag string          `json:"etag"`
+	Body json.RawMessage `json:"body"`
+}
+
+// ConditionalMaxSize limits the api responses read by DoConditionalRequest.
+const ConditionalMaxSize = 8 * 1024 * 1024
+
+// DefaultToolCacheTTL are the cache durations in minutes for tools that cost
+// credits or rate limit.
+var DefaultToolCacheTTL = map[string]int64{
+	"search_web":         360,
+	"fetch_contents":     1440,
+	"github_repository":  60,
+	"github_contents":    60,
+	"github_search_code": 60,
+	"github_issue":       15,
+	"forge_repository":   60,
+	"forge_contents":     60,
+}
+
+var toolCache *ToolCache
+
+func LoadToolCache() (*ToolCache, error) {
+	err := os.MkdirAll(path.ToolCache, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	cache := &ToolCache{
+		dir: path.ToolCache,
+	}
+
+	go func() {
+		removed, err := cache.Prune()
+		if err != nil {
+			log.Warnf("Unable to prune tool cache: %v\n", err)
+
+			return
+		}
+
+		debug("pruned %d expired tool cache entries", removed)
+	}()
+
+	return cache, nil
+}
+
+// ToolCacheKey hashes a tool call by its name and normalized arguments, so
+// formatting differences (key order, whitespace, empty values) share an entry.
+func ToolCacheKey(name, args string) string {
+	var value any
+
+	decoder := json.NewDecoder(strings.NewReader(args))
+
+	decoder.UseNumber()
+
+	if decoder.Decode(&value) == nil {
+		normalized, err := json.Marshal(normalizeCacheValue(value))
+		if err == nil {
+			args = string(normalized)
+		}
+	}
+
+	sum := sha256.Sum256([]byte(name + "\x00" + args))
+
+	return hex.EncodeToString(sum[:])
+}
+
+func normalizeCacheValue(value any) any {
+	switch v := value.(type) {
+	case string:
+		return strings.TrimSpace(v)
+	case []any:
+		for i, entry := range v {
+			v[i] = normalizeCacheValue(entry)
+		}
+
+		return v
+	case map[string]any:
+		for key, entry := range v {
+			entry = normalizeCacheValue(entry)
+
+			if entry == nil || entry == "" {
+				delete(v, key)
+
+				continue
+			}
+
+			if list, ok := entry.([]any); ok && len(list) == 0 {
+				delete(v, key)
+
+				continue
+			}
+
+			v[key] = entry
+		}
+
+		return v
+	}
+
+	return value
+}
+
+func (c *ToolCache) TTL(name string) time.Duration {
+	if c == nil || !env.Tools.Cache.Enabled {
+		return 0
+	}
+
+	return time.Duration(env.Tools.Cache.TTL[name]) * time.Minute
+}
+
+// Lookup fills the result of a tool call from the cache and reports whether
+// it was found.
+func (c *ToolCache) Lookup(key string, call *ChatToolCall) bool {
+	if c.TTL(call.Name) <= 0 {
+		return false
+	}
+
+	var entry ToolCacheEntry
+
+	if !c.read(filepath.Join(c.dir, key+".json"), &entry) {
+		return false
+	}
+
+	if time.Now().Unix() >= entry.Expires {
+		c.remove(filepath.Join(c.dir, key+".json"))
+
+		return false
+	}
+
+	call.Result = entry.Result
+	call.Cached = true
+
+	return true
+}
+
+// Store caches the result of a successful tool call.
+func (c *ToolCache) Store(key string, call *ChatToolCall) {
+	ttl := c.TTL(call.Name)
+	if ttl <= 0 || call.Invalid || call.Result == "" || strings.HasPrefix(call.Result, "error: ") {
+		return
+	}
+
+	now := time.Now()
+
+	entry := ToolCacheEntry{
+		Tool:    call.Name,
+		Args:    call.Args,
+		Result:  call.Result,
+		Created: now.Unix(),
+		Expires: now.Add(ttl).Unix(),
+	}
+
+	err := c.write(filepath.Join(c.dir, key+".json"), &entry)
+	if err != nil {
+		log.Warnf("Unable to store tool cache entry: %v\n", err)
+	}
+}
+
+// Response returns the stored response for a conditional http request.
+func (c *ToolCache) Response(url string) *ToolCacheResponse {
+	if c == nil || !env.Tools.Cache.Enabled {
+		return nil
+	}
+
+	var response ToolCacheResponse
+
+	if !c.read(c.responsePath(url), &response) || response.ETag == "" {
+		return nil
+	}
+
+	return &response
+}
+
+func (c *ToolCache) StoreResponse(url, etag string, body []byte) {
+	if c == nil || !env.Tools.Cache.Enabled || etag == "" || !json.Valid(body) {
+		return
+	}
+
+	err := c.write(c.responsePath(url), &ToolCacheResponse{
+		ETag: etag,
+		Body: body,
+	})
+	if err != nil {
+		log.Warnf("Unable to store cached response: %v\n", err)
+	}
+}
+
+// DoConditionalRequest performs a GET request, revalidating a previously
+// cached response with its ETag. Not modified responses return the cached
+// body with status 200.
+func DoConditionalRequest(req *http.Request) ([]byte, int, error) {
+	key := req.Header.Get("Accept") + " " + req.URL.String()
+
+	cached := toolCache.Response(key)
+	if cached != nil {
+		req.Header.Set("If-None-Match", cached.ETag)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		debug("%s not modified", req.URL.Path)
+
+		return cached.Body, http.StatusOK, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, ConditionalMaxSize+1))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// a truncated json body is useless, so it is neither cached nor returned
+	if len(body) > ConditionalMaxSize {
+		return nil, 0, fmt.Errorf("response exceeds %d bytes", ConditionalMaxSize)
+	}
+
+	if resp.StatusCode == http.StatusOK {
+		toolCache.StoreResponse(key, resp.Header.Get("ETag"), body)
+	}
+
+	return body, resp.StatusCode, nil
+}
+
+func (c *ToolCache) responsePath(url string) string {
+	sum := sha256.Sum256([]byte(url))
+
+	return filepath.Join(c.dir, "responses", hex.EncodeToString(sum[:])+".json")
+}
+
+// Prune removes all expired tool results.
+func (c *ToolCache) Prune() (int, error) {
+	now := time.Now().Unix()
+
+	return c.walk(false, func(file string) bool {
+		var entry ToolCacheEntry
+
+		return !c.read(file, &entry) || now >= entry.Expires
+	})
+}
+
+// Purge removes all cached tool results and responses.
+func (c *ToolCache) Purge() (int, error) {
+	return c.walk(true, func(_ string) bool {
+		return true
+	})
+}
+
+// walk removes all matching cache files. Responses have no expiry (they are
+// revalidated on use), so they are only included if requested.
+func (c *ToolCache) walk(responses bool, remove func(file string) bool) (int, error) {
+	var removed int
+
+	err := filepath.WalkDir(c.dir, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+
+			return err
+		}
+
+		if entry.IsDir() {
+			if entry.Name() == "responses" && !responses {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if filepath.Ext(file) != ".json" || !remove(file) {
+			return nil
+		}
+
+		if c.remove(file) {
+			removed++
+		}
+
+		return nil
+	})
+
+	return removed, err
+}
+
+func (c *ToolCache) read(file string, out any) bool {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return false
+	}
+
+	return json.Unmarshal(data, out) == nil
+}
+
+func (c *ToolCache) write(file string, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	err = os.MkdirAll(filepath.Dir(file), 0755)
+	if err != nil {
+		return err
+	}
+
+	temp := file + ".tmp"
+
+	err = os.WriteFile(temp, data, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(temp, file)
+}
+
+func (c *ToolCache) remove(file string) bool {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	return os.Remove(file) == nil
+}
+
+func HandlePurgeCache(w http.ResponseWriter, r *http.Request) {
+	if !IsAdmin(r) {
+		RespondJson(w, http.StatusForbidden, map[string]any{
+			"error": "forbidden",
+		})
+
+		return
+	}
+
+	if toolCache == nil {
+		RespondJson(w, http.StatusOK, map[string]any{
+			"removed": 0,
+		})
+
+		return
+	}
+
+	removed, err := toolCache.Purge()
+	if err != nil {
+		RespondJson(w, http.StatusInternalServerError, map[string]any{
+			"error": err.Error(),
+		})
+
+		return
+	}
+
+	log.Printf("Purged %d tool cache entries\n", removed)
+
+	RespondJson(w, http.StatusOK, map[string]any{
+		"removed": removed,
+	})
+}
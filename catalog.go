@@ -0,0 +1,210 @@
+package main
+
+import (
+	"errors"
+	"maps"
+	"os"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// ModelCatalog holds the model list of every backend as of its last
+// successful refresh. It is used while backends are unreachable.
+type ModelCatalog struct {
+	Backends map[string]*CatalogEntry `msgpack:"backends"`
+}
+
+// CatalogEntry is the unfiltered model list of a single backend.
+type CatalogEntry struct {
+	Updated int64    `msgpack:"updated"`
+	Primary bool     `msgpack:"primary"`
+	Models  []*Model `msgpack:"models"`
+	Audio   []*Model `msgpack:"audio"`
+}
+
+const modelRetryDelay = 15 * time.Second
+
+var (
+	// the last successful model list of every backend, when the oldest of
+	// them was loaded and whether any backend is served from the cache,
+	// guarded by modelMx
+	catalog        = make(map[string]*CatalogEntry)
+	catalogUpdated time.Time
+	catalogStale   bool
+)
+
+// StartModelUpdateLoop loads the cached model list, refreshes it from the
+// backends and keeps it up to date. Failed refreshes are retried with backoff.
+func StartModelUpdateLoop() {
+	var failures int
+
+	if err := LoadModelCatalog(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Warnf("Unable to load cached models: %v\n", err)
+	}
+
+	if err := LoadModels(); err != nil {
+		log.Warnf("Unable to load models: %v\n", err)
+
+		failures = 1
+	}
+
+	interval := time.Duration(env.Settings.RefreshInterval) * time.Minute
+
+	go func() {
+		for {
+			delay := interval
+
+			if failures > 0 {
+				delay = min(modelRetryDelay<<min(failures-1, 10), interval)
+			}
+
+			time.Sleep(delay)
+
+			if err := LoadModels(); err != nil {
+				log.Warnln(err)
+
+				failures++
+
+				continue
+			}
+
+			failures = 0
+		}
+	}()
+}
+
+// CachedModels returns a copy of the cached model lists of all backends.
+func CachedModels() map[string]*CatalogEntry {
+	modelMx.RLock()
+	defer modelMx.RUnlock()
+
+	return maps.Clone(catalog)
+}
+
+// StoreModelCatalog persists the model lists of all backends.
+func StoreModelCatalog() error {
+	modelMx.RLock()
+
+	data, err := msgpack.Marshal(ModelCatalog{
+		Backends: catalog,
+	})
+
+	modelMx.RUnlock()
+
+	if err != nil {
+		return err
+	}
+
+	tmp := path.ModelCache + ".tmp"
+
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path.ModelCache)
+}
+
+// LoadModelCatalog replaces the model list with the persisted one.
+func LoadModelCatalog() error {
+	data, err := os.ReadFile(path.ModelCache)
+	if err != nil {
+		return err
+	}
+
+	var cached ModelCatalog
+
+	if err := msgpack.Unmarshal(data, &cached); err != nil {
+		return err
+	}
+
+	list, err := ApplyModelCatalog(cached.Backends, true)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Loaded %d cached models from %s\n", len(list), catalogUpdated.Format(time.DateTime))
+
+	return nil
+}
+
+// ApplyModelCatalog replaces the model list with the given backend entries.
+// Entries of backends that are no longer configured are dropped and the model
+// filters are applied.
+func ApplyModelCatalog(entries map[string]*CatalogEntry, stale bool) ([]*Model, error) {
+	var (
+		newModelList  []*Model
+		newAudioList  []*Model
+		newModelMap   = make(map[string]*Model)
+		newModelIDMap = make(map[string]*Model)
+		newCatalog    = make(map[string]*CatalogEntry)
+
+		updated time.Time
+	)
+
+	for _, backend := range Backends() {
+		entry, ok := entries[backend.Name]
+		if !ok || entry.Primary != backend.primary {
+			continue
+		}
+
+		newCatalog[backend.Name] = entry
+
+		if at := time.Unix(entry.Updated, 0); updated.IsZero() || at.Before(updated) {
+			updated = at
+		}
+
+		for _, m := range entry.Models {
+			if env.Models.filters != nil {
+				matched, err := env.Models.filters.Match(m)
+				if err != nil {
+					return nil, err
+				}
+
+				if !matched {
+					continue
+				}
+			}
+
+			newModelList = append(newModelList, m)
+			newModelMap[m.Slug] = m
+			newModelIDMap[m.ID] = m
+		}
+
+		for _, m := range entry.Audio {
+			newAudioList = append(newAudioList, m)
+			newModelMap[m.Slug] = m
+		}
+	}
+
+	modelMx.Lock()
+
+	AudioList = newAudioList
+	ModelList = newModelList
+	ModelMap = newModelMap
+	ModelIDMap = newModelIDMap
+
+	catalog = newCatalog
+	catalogUpdated = updated
+	catalogStale = stale
+
+	modelMx.Unlock()
+
+	return newModelList, nil
+}
+
+// CatalogStatus describes the age of the model list, modelMx has to be held.
+func CatalogStatus() map[string]any {
+	status := map[string]any{
+		"updated": int64(0),
+		"age":     int64(-1),
+		"stale":   catalogStale,
+	}
+
+	if !catalogUpdated.IsZero() {
+		status["updated"] = catalogUpdated.Unix()
+		status["age"] = int64(time.Since(catalogUpdated).Seconds())
+	}
+
+	return status
+}
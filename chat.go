@@ -13,22 +13,30 @@ import (
 	"time"
 
 	"github.com/coalaura/openingrouter"
+	"github.com/vmihailenco/msgpack/v5"
+	"github.com/vmihailenco/msgpack/v5/msgpcode"
 )
 
 type ChatToolReasoning struct {
-	Format    string `msgpack:"format"`
-	Encrypted string `msgpack:"encrypted"`
+	ID        string `json:"id,omitempty" msgpack:"id,omitempty"`
+	Format    string `json:"format" msgpack:"format"`
+	Encrypted string `json:"encrypted" msgpack:"encrypted"`
 }
 
+// ChatToolReasonings is the encrypted reasoning sent back along with a tool
+// call. Older chats stored a single object.
+type ChatToolReasonings []ChatToolReasoning
+
 type ChatToolCall struct {
-	ID        string             `msgpack:"id"`
-	Name      string             `msgpack:"name"`
-	Args      string             `msgpack:"args"`
-	Result    string             `msgpack:"result,omitempty"`
-	Done      bool               `msgpack:"done,omitempty"`
-	Invalid   bool               `msgpack:"invalid,omitempty"`
-	Cost      float64            `msgpack:"cost,omitempty"`
-	Reasoning *ChatToolReasoning `msgpack:"reasoning,omitempty"`
+	ID        string             `json:"id" msgpack:"id"`
+	Name      string             `json:"name" msgpack:"name"`
+	Args      string             `json:"args" msgpack:"args"`
+	Result    string             `json:"result,omitempty" msgpack:"result,omitempty"`
+	Done      bool               `json:"done,omitempty" msgpack:"done,omitempty"`
+	Invalid   bool               `json:"invalid,omitempty" msgpack:"invalid,omitempty"`
+	Cost      float64            `json:"cost,omitempty" msgpack:"cost,omitempty"`
+	Cached    bool               `json:"cached,omitempty" msgpack:"cached,omitempty"`
+	Reasoning ChatToolReasonings `json:"reasoning,omitempty" msgpack:"reasoning,omitempty"`
 }
 
 type ChatTextFile struct {
@@ -39,9 +47,9 @@ type ChatTextFile struct {
 type ChatMessage struct {
 	Role   string         `json:"role"`
 	Text   string         `json:"text"`
-	Tool   *ChatToolCall  `json:"tool"`
-	Files  []ChatTextFile `json:"files"`
-	Images []string       `json:"images"`
+	Tool   *ChatToolCall  `json:"tool,omitempty"`
+	Files  []ChatTextFile `json:"files,omitempty"`
+	Images []string       `json:"images,omitempty"`
 }
 
 type ChatImage struct {
@@ -51,12 +59,13 @@ type ChatImage struct {
 }
 
 type ChatTools struct {
-	Images  bool `json:"images"`
-	Files   bool `json:"files"`
-	JSON    bool `json:"json"`
-	Search  bool `json:"search"`
-	Bare    bool `json:"bare"`
-	Offline bool `json:"offline"`
+	Images  bool     `json:"images"`
+	Files   bool     `json:"files"`
+	JSON    bool     `json:"json"`
+	Search  bool     `json:"search"`
+	Bare    bool     `json:"bare"`
+	Offline bool     `json:"offline"`
+	Enabled []string `json:"enabled"`
 }
 
 type ChatMetadata struct {
@@ -73,9 +82,16 @@ type ChatSettings struct {
 
 // gost:preserve-layout
 type ChatRequest struct {
-	proxy *EnvProxy
+	backend *EnvBackend
+	proxy   *EnvProxy
+	tools   []Tool
+	budget  *ToolBudget
+
+	// number of messages already in the stored chat
+	stored int
 
 	ProxyName   string        `json:"proxy"`
+	Chat        string        `json:"chat"`
 	Prompt      string        `json:"prompt"`
 	Model       string        `json:"model"`
 	Provider    string        `json:"provider"`
@@ -110,10 +126,23 @@ var (
 		"NO_IMAGE":                  "failed to generate image",
 		"MALFORMED_FUNCTION_CALL":   "invalid function call",
 		"UNEXPECTED_TOOL_CALL":      "unexpected tool call",
+
+		// Anthropic Models
+		"end_turn":      "",
+		"stop_sequence": "",
+		"tool_use":      "",
+
+		"pause_turn":                    "paused long-running turn",
+		"model_context_window_exceeded": "context window exceeded",
 	}
 )
 
 func (t *ChatToolCall) AsAssistantToolCall(content string) openingrouter.ChatMessage {
+	return AssistantToolCalls(content, []*ChatToolCall{t})
+}
+
+// AssistantToolCalls builds the assistant message requesting all tool calls of a turn.
+func AssistantToolCalls(content string, tools []*ChatToolCall) openingrouter.ChatMessage {
 	// Some models require there to be content
 	if content == "" {
 		content = " "
@@ -124,33 +153,77 @@ func (t *ChatToolCall) AsAssistantToolCall(content string) openingrouter.ChatMes
 		Content: openingrouter.ChatContent{
 			Text: content,
 		},
-		ToolCalls: []openingrouter.ChatToolCall{
-			{
-				ID:   t.ID,
-				Type: openingrouter.ChatToolTypeFunction,
-				Function: openingrouter.ChatToolCallFunction{
-					Name:      t.Name,
-					Arguments: t.Args,
-				},
-			},
-		},
+		ToolCalls: make([]openingrouter.ChatToolCall, 0, len(tools)),
 	}
 
-	if t.Reasoning != nil {
-		call.ReasoningDetails = []openingrouter.ChatReasoningDetail{
-			{
-				Type:   openingrouter.ChatReasoningDetailTypeEncrypted,
-				Data:   t.Reasoning.Encrypted,
-				ID:     t.ID,
-				Format: openingrouter.ChatReasoningFormat(t.Reasoning.Format),
-				Index:  0,
+	for _, t := range tools {
+		call.ToolCalls = append(call.ToolCalls, openingrouter.ChatToolCall{
+			ID:   t.ID,
+			Type: openingrouter.ChatToolTypeFunction,
+			Function: openingrouter.ChatToolCallFunction{
+				Name:      t.Name,
+				Arguments: t.Args,
 			},
+		})
+
+		for _, reasoning := range t.Reasoning {
+			id := reasoning.ID
+			if id == "" {
+				id = t.ID
+			}
+
+			call.ReasoningDetails = append(call.ReasoningDetails, openingrouter.ChatReasoningDetail{
+				Type:   openingrouter.ChatReasoningDetailTypeEncrypted,
+				Data:   reasoning.Encrypted,
+				ID:     id,
+				Format: openingrouter.ChatReasoningFormat(reasoning.Format),
+				Index:  len(call.ReasoningDetails),
+			})
 		}
 	}
 
 	return call
 }
 
+func (r *ChatToolReasonings) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		var single ChatToolReasoning
+
+		err := json.Unmarshal(data, &single)
+		if err != nil {
+			return err
+		}
+
+		*r = ChatToolReasonings{single}
+
+		return nil
+	}
+
+	return json.Unmarshal(data, (*[]ChatToolReasoning)(r))
+}
+
+func (r *ChatToolReasonings) DecodeMsgpack(dec *msgpack.Decoder) error {
+	code, err := dec.PeekCode()
+	if err != nil {
+		return err
+	}
+
+	if msgpcode.IsFixedMap(code) || code == msgpcode.Map16 || code == msgpcode.Map32 {
+		var single ChatToolReasoning
+
+		err = dec.Decode(&single)
+		if err != nil {
+			return err
+		}
+
+		*r = ChatToolReasonings{single}
+
+		return nil
+	}
+
+	return dec.Decode((*[]ChatToolReasoning)(r))
+}
+
 func (t *ChatToolCall) AsToolMessage() openingrouter.ChatMessage {
 	return openingrouter.ChatMessage{
 		Role:       openingrouter.ChatRoleTool,
@@ -181,7 +254,7 @@ func (r *ChatRequest) AddToolPrompt(request *openingrouter.ChatCompletionRequest
 
 	if len(request.Tools) == 0 {
 		if needExplicitStop {
-			request.Messages = append(request.Messages, openingrouter.SystemMessage("Do not perform any more search tool calls."))
+			request.Messages = append(request.Messages, openingrouter.SystemMessage("Do not perform any more tool calls."))
 		}
 
 		return false
@@ -204,12 +277,13 @@ func (r *ChatRequest) AddToolPrompt(request *openingrouter.ChatCompletionRequest
 	InternalToolsTmpl.Execute(&tools, map[string]any{
 		"total":     total,
 		"remaining": total - 1,
+		"tools":     ToolPrompts(r.tools),
 	})
 
 	request.Messages = append(request.Messages, openingrouter.SystemMessage(tools.String()))
 
 	if isLastIteration && needExplicitStop {
-		request.Messages = append(request.Messages, openingrouter.SystemMessage("Do not perform any more search tool calls."))
+		request.Messages = append(request.Messages, openingrouter.SystemMessage("Do not perform any more tool calls."))
 	}
 
 	return true
@@ -223,14 +297,15 @@ func (r *ChatRequest) Parse() (*openingrouter.ChatCompletionRequest, error) {
 		return nil, err
 	}
 
-	r.proxy = proxy
-
 	model := GetModel(r.Model)
 	if model == nil {
 		return nil, fmt.Errorf("unknown model: %q", r.Model)
 	}
 
-	request.Model = r.Model
+	r.backend = model.GetBackend()
+	r.proxy = r.backend.ResolveProxy(proxy)
+
+	request.Model = model.Upstream()
 
 	request.MetadataLevel = openingrouter.ChatMetadataLevelEnabled
 
@@ -355,9 +430,14 @@ func (r *ChatRequest) Parse() (*openingrouter.ChatCompletionRequest, error) {
 		request.Messages = append(request.Messages, openingrouter.SystemMessage(prompt))
 	}
 
-	if model.Tools && r.Tools.Search && env.Tokens.Tavily != "" {
+	if r.Tools.Search {
+		r.tools = ResolveTools(r, model)
+		r.budget = NewToolBudget(model)
+	}
+
+	if len(r.tools) > 0 {
 		if r.Iterations > 1 {
-			request.Tools = GetSearchTools()
+			request.Tools = ToolDefinitions(r.tools)
 			request.ToolChoice = &openingrouter.ChatToolChoice{
 				Mode: openingrouter.ChatToolChoiceModeAuto,
 			}
@@ -543,36 +623,85 @@ func HandleChat(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	debug("preparing stream")
-
-	ctx := r.Context()
-
-	response, err := NewStream(w, ctx)
+	job, status, err := StartChat(r, raw, request)
 	if err != nil {
-		RespondJson(w, http.StatusBadRequest, map[string]any{
+		RespondJson(w, status, map[string]any{
 			"error": err.Error(),
 		})
 
 		return
 	}
 
-	debug("handling request")
+	ServeChatJob(w, r, job, 0)
+}
 
-	go func() {
-		ticker := time.NewTicker(5 * time.Second)
-		defer ticker.Stop()
+// StartChat starts the generation of a parsed chat request, returning the
+// http status to respond with if it can't.
+func StartChat(r *http.Request, raw *ChatRequest, request *openingrouter.ChatCompletionRequest) (*ChatJob, int, error) {
+	var user *EnvUser
 
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case <-ticker.C:
-				response.WriteChunk(NewChunk(ChunkAlive, nil))
+	if raw.Chat != "" {
+		user = GetAuthenticatedUser(r)
+		if user == nil {
+			return nil, http.StatusUnauthorized, errors.New("unauthorized")
+		}
+
+		chat, err := chats.Get(user.Username, raw.Chat)
+		if err != nil {
+			if errors.Is(err, ErrChatNotFound) {
+				return nil, http.StatusBadRequest, err
 			}
+
+			return nil, http.StatusInternalServerError, err
+		}
+
+		// the stored chat is the source of truth, stale clients must reload it
+		if !IsHistoryPrefix(chat.Messages, raw.Messages) {
+			return nil, http.StatusConflict, ErrChatConflict
+		}
+
+		raw.stored = len(chat.Messages)
+	}
+
+	debug("starting generation")
+
+	job, err := StartChatJob(GetUsername(r), GetSession(r), func(ctx context.Context, job *ChatJob) {
+		RunChat(ctx, job, raw, request, user)
+	})
+	if err != nil {
+		if errors.Is(err, ErrJobLimit) {
+			return nil, http.StatusTooManyRequests, err
 		}
-	}()
+
+		return nil, http.StatusInternalServerError, err
+	}
+
+	return job, http.StatusOK, nil
+}
+
+// RunChat runs the full completion and tool loop of a chat request, writing
+// all chunks to response.
+func RunChat(ctx context.Context, response ChunkWriter, raw *ChatRequest, request *openingrouter.ChatCompletionRequest, user *EnvUser) {
+	debug("handling request")
+
+	var turn []ChatMessage
+
+	// store everything the user has seen, however the generation ends
+	if user != nil {
+		defer func() {
+			raw.StoreTurn(user.Username, turn)
+		}()
+	}
 
 	for iteration := range raw.Iterations {
+		if ctx.Err() != nil {
+			debug("generation cancelled")
+
+			response.WriteChunk(NewChunk(ChunkCancelled, nil))
+
+			return
+		}
+
 		debug("iteration %d of %d", iteration+1, raw.Iterations)
 
 		response.WriteChunk(NewChunk(ChunkStart, StartChunk{
@@ -584,123 +713,251 @@ func HandleChat(w http.ResponseWriter, r *http.Request) {
 
 		dump("chat.json", request)
 
-		tool, message, err := RunCompletion(ctx, response, request, raw.proxy)
+		tools, message, images, err := RunCompletion(ctx, response, request, raw.backend, raw.proxy)
 		if err != nil {
+			// keep the partial output
+			if strings.TrimSpace(message) != "" || len(images) > 0 {
+				turn = append(turn, ChatMessage{
+					Role:   "assistant",
+					Text:   CleanChunk(message),
+					Images: images,
+				})
+			}
+
+			if ctx.Err() != nil {
+				debug("generation cancelled")
+
+				response.WriteChunk(NewChunk(ChunkCancelled, nil))
+
+				return
+			}
+
 			response.WriteChunk(NewChunk(ChunkError, err))
 
 			return
 		}
 
-		if tool == nil {
+		if len(tools) == 0 {
 			debug("no tool call, done")
 
+			turn = append(turn, ChatMessage{
+				Role:   "assistant",
+				Text:   CleanChunk(message),
+				Images: images,
+			})
+
 			return
 		}
 
-		debug("got %q tool call", tool.Name)
+		debug("got %d tool call(s)", len(tools))
 
 		if len(request.Tools) == 0 {
-			response.WriteChunk(NewChunk(ChunkError, fmt.Errorf("got %q tool call", tool.Name)))
+			response.WriteChunk(NewChunk(ChunkError, fmt.Errorf("got %q tool call", tools[0].Name)))
 
 			continue
 		}
 
-		if raw.Tools.Offline {
-			tool.Result = "error: tool unavailable: network is offline"
-		} else {
+		raw.RunToolCalls(ctx, response, tools, iteration)
 
-			switch tool.Name {
-			case "search_web":
-				arguments, err := ParseAndUpdateArgs[SearchWebArguments](tool)
-				if err != nil {
-					response.WriteChunk(NewChunk(ChunkError, err))
+		debug("finished tool calls")
 
-					return
-				}
+		if hasToolMessage {
+			request.Messages = request.Messages[:len(request.Messages)-1]
+		}
 
-				response.WriteChunk(NewChunk(ChunkTool, tool))
+		request.Messages = append(request.Messages, AssistantToolCalls(message, tools))
 
-				err = HandleSearchWebTool(ctx, tool, arguments)
-				if err != nil {
-					response.WriteChunk(NewChunk(ChunkError, err))
+		for i, tool := range tools {
+			request.Messages = append(request.Messages, tool.AsToolMessage())
 
-					return
-				}
-			case "fetch_contents":
-				arguments, err := ParseAndUpdateArgs[FetchContentsArguments](tool)
-				if err != nil {
-					response.WriteChunk(NewChunk(ChunkError, err))
+			// the frontend stores one tool call per message
+			var text string
 
-					return
-				}
+			if i == 0 {
+				text = CleanChunk(message)
+			}
 
-				response.WriteChunk(NewChunk(ChunkTool, tool))
+			turn = append(turn, ChatMessage{
+				Role: "assistant",
+				Text: text,
+				Tool: tool,
+			})
+		}
 
-				err = HandleFetchContentsTool(ctx, tool, arguments)
-				if err != nil {
-					response.WriteChunk(NewChunk(ChunkError, err))
+		// the tool calls are kept, but no further iteration starts
+		if ctx.Err() != nil {
+			debug("generation cancelled")
 
-					return
-				}
-			case "github_repository":
-				arguments, err := ParseAndUpdateArgs[GitHubRepositoryArguments](tool)
-				if err != nil {
-					response.WriteChunk(NewChunk(ChunkError, err))
+			response.WriteChunk(NewChunk(ChunkCancelled, nil))
 
-					return
-				}
+			return
+		}
 
-				response.WriteChunk(NewChunk(ChunkTool, tool))
+		response.WriteChunk(NewChunk(ChunkEnd, nil))
+	}
+}
 
-				err = HandleGitHubRepositoryTool(ctx, tool, arguments)
-				if err != nil {
-					response.WriteChunk(NewChunk(ChunkError, err))
+// RunToolCalls executes all tool calls of a turn concurrently. Chunks are still
+// written one tool at a time, since the frontend shows one tool call per message.
+func (r *ChatRequest) RunToolCalls(ctx context.Context, response ChunkWriter, tools []*ChatToolCall, iteration int64) {
+	runners := make([]func() error, len(tools))
 
-					return
-				}
-			default:
-				tool.Invalid = true
-				tool.Result = "error: invalid tool call"
+	for i, call := range tools {
+		runners[i] = r.PrepareToolCall(ctx, call)
+	}
+
+	var (
+		pending   = make([]*ChatToolCall, len(tools))
+		results   = make([]chan error, len(tools))
+		approvals = make([]chan bool, len(tools))
+	)
+
+	for i, tool := range tools {
+		// snapshot before the tool starts modifying it
+		if runners[i] != nil {
+			snapshot := *tool
+			pending[i] = &snapshot
+
+			// the call only starts once it was approved
+			if RequiresApproval(tool.Name) {
+				approvals[i] = make(chan bool, 1)
 			}
 		}
 
+		results[i] = make(chan error, 1)
+
+		go func() {
+			if runners[i] == nil {
+				results[i] <- nil
+
+				return
+			}
+
+			if approvals[i] != nil && !<-approvals[i] {
+				results[i] <- nil
+
+				return
+			}
+
+			results[i] <- RecoverError(tool.Name, runners[i])
+		}()
+	}
+
+	for i, tool := range tools {
+		if i > 0 {
+			response.WriteChunk(NewChunk(ChunkStart, StartChunk{
+				Iteration: iteration + 1,
+				Total:     r.Iterations,
+			}))
+		}
+
+		if pending[i] != nil {
+			response.WriteChunk(NewChunk(ChunkTool, *pending[i]))
+		}
+
+		if approvals[i] != nil {
+			approvals[i] <- r.RequestApproval(ctx, response, tool)
+		}
+
+		// a failed call only fails itself, not the whole turn
+		err := <-results[i]
+		if err != nil {
+			tool.Result = fmt.Sprintf("error: %v", err)
+		}
+
+		r.budget.Apply(tool)
+
 		tool.Done = true
 
-		debug("finished tool call")
+		response.WriteChunk(NewChunk(ChunkTool, *tool))
+	}
+}
 
-		response.WriteChunk(NewChunk(ChunkTool, tool))
+// PrepareToolCall looks up the registered tool for a call and returns the
+// function executing it, or nil if the call was already resolved.
+func (r *ChatRequest) PrepareToolCall(ctx context.Context, call *ChatToolCall) func() error {
+	tool := r.GetTool(call.Name)
+	if tool == nil {
+		call.Invalid = true
+		call.Result = "error: invalid tool call"
 
-		if hasToolMessage {
-			request.Messages = request.Messages[:len(request.Messages)-1]
+		return nil
+	}
+
+	if r.Tools.Offline {
+		call.Result = "error: tool unavailable: network is offline"
+
+		return nil
+	}
+
+	return func() error {
+		key := ToolCacheKey(call.Name, call.Args)
+
+		if toolCache.Lookup(key, call) {
+			debug("using cached %s result", call.Name)
+
+			return nil
 		}
 
-		request.Messages = append(request.Messages,
-			tool.AsAssistantToolCall(message),
-			tool.AsToolMessage(),
-		)
+		err := tool.Execute(ctx, call)
+		if err != nil {
+			return err
+		}
 
-		response.WriteChunk(NewChunk(ChunkEnd, nil))
+		toolCache.Store(key, call)
+
+		return nil
+	}
+}
+
+// Same reports whether two messages are the same message of a history, the
+// client doesn't send back every detail of tool calls.
+func (m *ChatMessage) Same(other *ChatMessage) bool {
+	if m.Role != other.Role || m.Text != other.Text || (m.Tool == nil) != (other.Tool == nil) {
+		return false
+	}
+
+	return m.Tool == nil || m.Tool.ID == other.Tool.ID
+}
+
+// StoreTurn appends the new messages of the request followed by the generated
+// assistant turn to the stored chat the request was made for.
+func (r *ChatRequest) StoreTurn(username string, turn []ChatMessage) {
+	if r.Chat == "" {
+		return
+	}
+
+	added := r.Messages[min(r.stored, len(r.Messages)):]
+
+	messages := make([]ChatMessage, 0, len(added)+len(turn))
+
+	messages = append(messages, added...)
+	messages = append(messages, turn...)
+
+	err := chats.AppendMessages(username, r.Chat, messages)
+	if err != nil {
+		log.Warnf("Unable to store chat %q: %v\n", r.Chat, err)
 	}
 }
 
-func RunCompletion(ctx context.Context, response *Stream, request *openingrouter.ChatCompletionRequest, proxy *EnvProxy) (*ChatToolCall, string, error) {
+func RunCompletion(ctx context.Context, response ChunkWriter, request *openingrouter.ChatCompletionRequest, backend *EnvBackend, proxy *EnvProxy) ([]*ChatToolCall, string, []string, error) {
 	started := time.Now()
 
 	var (
 		id             string
-		open           int
-		close          int
 		completing     bool
 		reasoning      bool
 		hasContent     bool
-		tool           *ChatToolCall
+		tools          []*ChatToolCall
+		indices        = make(map[int]*ChatToolCall)
+		encrypted      []CompletionReasoning
 		statistics     *Statistics
 		finish         openingrouter.ChatFinishReason
 		native         string
 		ttftMs         int64
 		ttfoMs         int64
 		reasoningStart int64
-		outputImages   int
+		outputImages   []string
 	)
 
 	markToken := func(output bool) {
@@ -715,9 +972,9 @@ func RunCompletion(ctx context.Context, response *Stream, request *openingrouter
 		}
 	}
 
-	stream, err := OpenRouterStartStream(ctx, *request, proxy)
+	stream, err := backend.StartStream(ctx, request, proxy)
 	if err != nil {
-		return nil, "", err
+		return nil, "", nil, err
 	}
 
 	defer stream.Close()
@@ -726,79 +983,83 @@ func RunCompletion(ctx context.Context, response *Stream, request *openingrouter
 	defer pool.Put(buf)
 
 	for {
-		chunk, err := stream.Recv()
+		delta, err := stream.Recv()
 		if err != nil {
 			if errors.Is(err, io.EOF) {
 				break
 			}
 
-			return nil, "", err
-		}
+			if ctx.Err() != nil {
+				// the generation was cancelled, report what was billed so far
+				if statistics == nil && id != "" {
+					statistics = ResolveGenerationStatistics(id, backend, proxy)
+				}
 
-		if id == "" {
-			id = chunk.ID
+				if statistics != nil {
+					response.WriteChunk(NewChunk(ChunkUsage, *statistics))
+				}
 
-			response.WriteChunk(NewChunk(ChunkID, id))
-		}
+				return nil, buf.String(), outputImages, ctx.Err()
+			}
 
-		if chunk.Usage != nil {
-			provider := streamProvider(chunk.OpenRouterMetadata)
+			return nil, buf.String(), outputImages, err
+		}
 
-			debug("usage chunk: model=%q provider=%q prompt=%d completion=%d cost=%v", chunk.Model, provider, chunk.Usage.PromptTokens, chunk.Usage.CompletionTokens, chunk.Usage.Cost)
+		if id == "" && delta.ID != "" {
+			id = delta.ID
 
-			statistics = CreateStatistics(chunk.Model, provider, chunk.Usage)
+			debug("generation id: %s", id)
 		}
 
-		if len(chunk.Choices) == 0 {
-			continue
+		if delta.Usage != nil {
+			statistics = delta.Usage
 		}
 
-		choice := chunk.Choices[0]
-		delta := choice.Delta
+		if delta.Finish != "" {
+			finish = delta.Finish
+		}
 
-		if choice.FinishReason != "" {
-			finish = choice.FinishReason
+		if delta.Native != "" {
+			native = delta.Native
 		}
 
 		calls := delta.ToolCalls
 
-		if len(calls) > 0 {
-			call := calls[0]
+		// reasoning arriving with a call belongs to it, unless its id says otherwise
+		for _, reasoning := range delta.Encrypted {
+			index := -1
 
-			if open > 0 && open == close {
-				continue
+			if len(calls) > 0 {
+				index = calls[0].Index
 			}
 
-			if tool == nil {
-				tool = &ChatToolCall{}
-			}
+			encrypted = append(encrypted, CompletionReasoning{
+				Index:     index,
+				Reasoning: reasoning,
+			})
+		}
 
-			if call.ID != "" && !strings.HasSuffix(tool.ID, call.ID) {
-				tool.ID += call.ID
-			}
+		if len(calls) > 0 {
+			for _, call := range calls {
+				tool := indices[call.Index]
+				if tool == nil {
+					tool = &ChatToolCall{}
 
-			if call.Function != nil {
-				if call.Function.Name != "" && !strings.HasSuffix(tool.Name, call.Function.Name) {
-					tool.Name += call.Function.Name
+					indices[call.Index] = tool
+					tools = append(tools, tool)
 				}
 
-				open += strings.Count(call.Function.Arguments, "{")
-				close += strings.Count(call.Function.Arguments, "}")
-
-				tool.Args += call.Function.Arguments
-			}
-
-			if len(delta.ReasoningDetails) != 0 && tool.Reasoning == nil {
-				for _, details := range delta.ReasoningDetails {
-					if details.Type != openingrouter.ChatReasoningDetailTypeEncrypted {
-						continue
-					}
+				// ids and names arrive whole, some backends repeat them
+				// with every delta, so they replace instead of append
+				if call.ID != "" && (tool.ID == "" || len(call.ID) >= len(tool.ID)) {
+					tool.ID = call.ID
+				}
 
-					tool.Reasoning = &ChatToolReasoning{
-						Format:    string(details.Format),
-						Encrypted: details.Data,
-					}
+				if call.Name != "" && (tool.Name == "" || len(call.Name) >= len(tool.Name)) {
+					tool.Name = call.Name
 				}
+
+				tool.Args += call.Args
 			}
 
 			markToken(true)
@@ -827,12 +1088,12 @@ func RunCompletion(ctx context.Context, response *Stream, request *openingrouter
 		} else if delta.Reasoning != "" {
 			reasoningText := delta.Reasoning
 
-			if !reasoning && len(delta.ReasoningDetails) != 0 {
+			if !reasoning && delta.ReasoningType != "" {
 				reasoningText = strings.TrimLeft(reasoningText, " \t\n\r")
 
 				reasoning = true
 
-				response.WriteChunk(NewChunk(ChunkReasoningType, delta.ReasoningDetails[0].Type))
+				response.WriteChunk(NewChunk(ChunkReasoningType, delta.ReasoningType))
 			}
 
 			response.WriteChunk(NewChunk(ChunkReasoning, reasoningText))
@@ -844,13 +1105,9 @@ func RunCompletion(ctx context.Context, response *Stream, request *openingrouter
 			markToken(false)
 		} else if len(delta.Images) > 0 {
 			for _, image := range delta.Images {
-				if image.ImageURL.URL == "" {
-					continue
-				}
+				response.WriteChunk(NewChunk(ChunkImage, image))
 
-				response.WriteChunk(NewChunk(ChunkImage, image.ImageURL.URL))
-
-				outputImages++
+				outputImages = append(outputImages, image)
 
 				markToken(true)
 
@@ -861,19 +1118,97 @@ func RunCompletion(ctx context.Context, response *Stream, request *openingrouter
 
 	badStop := GetBadStopReason(finish, native)
 	if badStop != "" {
-		response.WriteChunk(NewChunk(ChunkError, fmt.Errorf("stopped due to: %s", badStop)))
+		response.WriteChunk(NewChunk(ChunkError, &CompletionNotice{
+			Finish:  finish,
+			Native:  native,
+			Message: fmt.Sprintf("stopped due to: %s", badStop),
+		}))
 	}
 
 	noContent := buf.Len() == 0 && finish == "" && !hasContent
 	if noContent {
-		response.WriteChunk(NewChunk(ChunkError, errors.New("no content returned")))
+		response.WriteChunk(NewChunk(ChunkError, &CompletionNotice{
+			Message: "no content returned",
+		}))
 	}
 
 	if statistics != nil {
 		response.WriteChunk(NewChunk(ChunkUsage, *statistics))
 	}
 
-	return tool, buf.String(), nil
+	for i, tool := range tools {
+		if tool.ID == "" {
+			tool.ID = fmt.Sprintf("call_%d", i)
+		}
+	}
+
+	AttachToolReasoning(tools, indices, encrypted)
+
+	return tools, buf.String(), outputImages, nil
+}
+
+// AttachToolReasoning stores the encrypted reasoning of a completion with the
+// call it belongs to, matched by id or by the index of the call it arrived
+// with. Reasoning of the whole turn is stored with the first call.
+func AttachToolReasoning(tools []*ChatToolCall, indices map[int]*ChatToolCall, encrypted []CompletionReasoning) {
+	if len(tools) == 0 {
+		return
+	}
+
+	for _, entry := range encrypted {
+		var tool *ChatToolCall
+
+		if entry.Reasoning.ID != "" {
+			for _, call := range tools {
+				if call.ID == entry.Reasoning.ID {
+					tool = call
+
+					break
+				}
+			}
+		}
+
+		if tool == nil && entry.Index >= 0 {
+			tool = indices[entry.Index]
+		}
+
+		if tool == nil {
+			tool = tools[0]
+		}
+
+		tool.Reasoning = append(tool.Reasoning, entry.Reasoning)
+	}
+}
+
+// ResolveGenerationStatistics looks up the billed usage of a generation that was
+// aborted before its usage chunk arrived.
+func ResolveGenerationStatistics(id string, backend *EnvBackend, proxy *EnvProxy) *Statistics {
+	if !backend.IsOpenRouter() {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	// generation stats take a moment to become available
+	for attempt := range 5 {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(time.Duration(attempt+1) * 500 * time.Millisecond):
+		}
+
+		generation, err := OpenRouterGetGeneration(ctx, id, backend, proxy)
+		if err != nil {
+			debug("generation stats unavailable (%d): %v", attempt+1, err)
+
+			continue
+		}
+
+		return generation.Statistics()
+	}
+
+	return nil
 }
 
 func GetBadStopReason(finish openingrouter.ChatFinishReason, native string) string {
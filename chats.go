@@ -0,0 +1,470 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type StoredChat struct {
+	ID       string        `json:"id"`
+	Title    string        `json:"title"`
+	Created  int64         `json:"created"`
+	Updated  int64         `json:"updated"`
+	Messages []ChatMessage `json:"messages"`
+}
+
+type StoredChatSummary struct {
+	ID       string `json:"id"`
+	Title    string `json:"title"`
+	Created  int64  `json:"created"`
+	Updated  int64  `json:"updated"`
+	Messages int    `json:"messages"`
+}
+
+type StoredChatRequest struct {
+	Title    *string        `json:"title"`
+	Messages *[]ChatMessage `json:"messages"`
+}
+
+type ChatStore struct {
+	mx  sync.RWMutex
+	dir string
+}
+
+var (
+	ErrChatNotFound = errors.New("chat not found")
+	ErrChatConflict = errors.New("chat history differs from the stored chat")
+)
+
+func LoadChatStore() (*ChatStore, error) {
+	err := os.MkdirAll(path.Chats, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ChatStore{
+		dir: path.Chats,
+	}, nil
+}
+
+func (c *StoredChat) Summary() StoredChatSummary {
+	return StoredChatSummary{
+		ID:       c.ID,
+		Title:    c.Title,
+		Created:  c.Created,
+		Updated:  c.Updated,
+		Messages: len(c.Messages),
+	}
+}
+
+func (r *StoredChatRequest) Validate() error {
+	if r.Title != nil && len(*r.Title) > 512 {
+		return errors.New("title too long (max 512 characters)")
+	}
+
+	if r.Messages == nil {
+		return nil
+	}
+
+	for i, message := range *r.Messages {
+		switch message.Role {
+		case "system", "user", "assistant":
+		default:
+			return fmt.Errorf("message %d has invalid role %q", i, message.Role)
+		}
+	}
+
+	return nil
+}
+
+func (s *ChatStore) List(username string) ([]StoredChatSummary, error) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	entries, err := os.ReadDir(s.userDir(username))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return make([]StoredChatSummary, 0), nil
+		}
+
+		return nil, err
+	}
+
+	list := make([]StoredChatSummary, 0, len(entries))
+
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || entry.IsDir() || !IsChatID(id) {
+			continue
+		}
+
+		chat, err := s.readLocked(username, id)
+		if err != nil {
+			log.Warnf("Unable to read chat %q: %v\n", id, err)
+
+			continue
+		}
+
+		list = append(list, chat.Summary())
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Updated > list[j].Updated
+	})
+
+	return list, nil
+}
+
+func (s *ChatStore) Get(username, id string) (*StoredChat, error) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	return s.readLocked(username, id)
+}
+
+func (s *ChatStore) Create(username string, request *StoredChatRequest) (*StoredChat, error) {
+	id, err := CreateSecret(8)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Unix()
+
+	chat := &StoredChat{
+		ID:       id,
+		Title:    Nullable(request.Title, ""),
+		Created:  now,
+		Updated:  now,
+		Messages: Nullable(request.Messages, nil),
+	}
+
+	if chat.Messages == nil {
+		chat.Messages = make([]ChatMessage, 0)
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	err = s.writeLocked(username, chat)
+	if err != nil {
+		return nil, err
+	}
+
+	return chat, nil
+}
+
+func (s *ChatStore) Update(username, id string, request *StoredChatRequest) (*StoredChat, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	chat, err := s.readLocked(username, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if request.Title != nil {
+		chat.Title = *request.Title
+	}
+
+	if request.Messages != nil {
+		chat.Messages = *request.Messages
+	}
+
+	chat.Updated = time.Now().Unix()
+
+	err = s.writeLocked(username, chat)
+	if err != nil {
+		return nil, err
+	}
+
+	return chat, nil
+}
+
+// AppendMessages appends messages to the history of a stored chat, used by
+// HandleChat to persist the conversation once a generation finished.
+func (s *ChatStore) AppendMessages(username, id string, messages []ChatMessage) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	chat, err := s.readLocked(username, id)
+	if err != nil {
+		return err
+	}
+
+	chat.Messages = append(chat.Messages, messages...)
+	chat.Updated = time.Now().Unix()
+
+	return s.writeLocked(username, chat)
+}
+
+// IsHistoryPrefix reports whether the stored history is the start of the
+// history sent with a request.
+func IsHistoryPrefix(stored, messages []ChatMessage) bool {
+	if len(stored) > len(messages) {
+		return false
+	}
+
+	for i, message := range stored {
+		if !message.Same(&messages[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (s *ChatStore) Exists(username, id string) bool {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	if !IsChatID(id) {
+		return false
+	}
+
+	_, err := os.Stat(s.chatPath(username, id))
+
+	return err == nil
+}
+
+func (s *ChatStore) Delete(username, id string) error {
+	if !IsChatID(id) {
+		return ErrChatNotFound
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	err := os.Remove(s.chatPath(username, id))
+	if err != nil && os.IsNotExist(err) {
+		return ErrChatNotFound
+	}
+
+	return err
+}
+
+func (s *ChatStore) readLocked(username, id string) (*StoredChat, error) {
+	if !IsChatID(id) {
+		return nil, ErrChatNotFound
+	}
+
+	file, err := os.OpenFile(s.chatPath(username, id), os.O_RDONLY, 0)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrChatNotFound
+		}
+
+		return nil, err
+	}
+
+	defer file.Close()
+
+	var chat StoredChat
+
+	err = json.NewDecoder(file).Decode(&chat)
+	if err != nil {
+		return nil, err
+	}
+
+	if chat.Messages == nil {
+		chat.Messages = make([]ChatMessage, 0)
+	}
+
+	return &chat, nil
+}
+
+func (s *ChatStore) writeLocked(username string, chat *StoredChat) error {
+	dir := s.userDir(username)
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(chat)
+	if err != nil {
+		return err
+	}
+
+	target := s.chatPath(username, chat.ID)
+	temp := target + ".tmp"
+
+	err = os.WriteFile(temp, data, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(temp, target)
+}
+
+func (s *ChatStore) userDir(username string) string {
+	sum := sha256.Sum256([]byte(username))
+
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:16]))
+}
+
+func (s *ChatStore) chatPath(username, id string) string {
+	return filepath.Join(s.userDir(username), id+".json")
+}
+
+func IsChatID(id string) bool {
+	if len(id) != 16 {
+		return false
+	}
+
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+func HandleListChats(w http.ResponseWriter, r *http.Request) {
+	user := GetAuthenticatedUser(r)
+	if user == nil {
+		w.WriteHeader(http.StatusUnauthorized)
+
+		return
+	}
+
+	list, err := chats.List(user.Username)
+	if err != nil {
+		RespondJson(w, http.StatusInternalServerError, map[string]any{
+			"error": err.Error(),
+		})
+
+		return
+	}
+
+	RespondJson(w, http.StatusOK, list)
+}
+
+func HandleGetChat(w http.ResponseWriter, r *http.Request) {
+	user := GetAuthenticatedUser(r)
+	if user == nil {
+		w.WriteHeader(http.StatusUnauthorized)
+
+		return
+	}
+
+	chat, err := chats.Get(user.Username, chi.URLParam(r, "id"))
+	if err != nil {
+		RespondChatError(w, err)
+
+		return
+	}
+
+	RespondJson(w, http.StatusOK, chat)
+}
+
+func HandleCreateChat(w http.ResponseWriter, r *http.Request) {
+	user := GetAuthenticatedUser(r)
+	if user == nil {
+		w.WriteHeader(http.StatusUnauthorized)
+
+		return
+	}
+
+	request, err := ReadStoredChatRequest(r)
+	if err != nil {
+		RespondJson(w, http.StatusBadRequest, map[string]any{
+			"error": err.Error(),
+		})
+
+		return
+	}
+
+	chat, err := chats.Create(user.Username, request)
+	if err != nil {
+		RespondChatError(w, err)
+
+		return
+	}
+
+	RespondJson(w, http.StatusCreated, chat)
+}
+
+func HandleUpdateChat(w http.ResponseWriter, r *http.Request) {
+	user := GetAuthenticatedUser(r)
+	if user == nil {
+		w.WriteHeader(http.StatusUnauthorized)
+
+		return
+	}
+
+	request, err := ReadStoredChatRequest(r)
+	if err != nil {
+		RespondJson(w, http.StatusBadRequest, map[string]any{
+			"error": err.Error(),
+		})
+
+		return
+	}
+
+	chat, err := chats.Update(user.Username, chi.URLParam(r, "id"), request)
+	if err != nil {
+		RespondChatError(w, err)
+
+		return
+	}
+
+	RespondJson(w, http.StatusOK, chat)
+}
+
+func HandleDeleteChat(w http.ResponseWriter, r *http.Request) {
+	user := GetAuthenticatedUser(r)
+	if user == nil {
+		w.WriteHeader(http.StatusUnauthorized)
+
+		return
+	}
+
+	err := chats.Delete(user.Username, chi.URLParam(r, "id"))
+	if err != nil {
+		RespondChatError(w, err)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func ReadStoredChatRequest(r *http.Request) (*StoredChatRequest, error) {
+	var request StoredChatRequest
+
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		return nil, err
+	}
+
+	err = request.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return &request, nil
+}
+
+func RespondChatError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrChatNotFound) {
+		RespondJson(w, http.StatusNotFound, map[string]any{
+			"error": err.Error(),
+		})
+
+		return
+	}
+
+	log.Warnln(err)
+
+	RespondJson(w, http.StatusInternalServerError, map[string]any{
+		"error": err.Error(),
+	})
+}
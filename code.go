@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/coalaura/openingrouter"
+)
+
+type RunCodeArguments struct {
+	Language string `json:"language"`
+	Code     string `json:"code"`
+}
+
+type RunCodeResult struct {
+	ExitCode int
+	Stdout   *LimitedBuffer
+	Stderr   *LimitedBuffer
+	TimedOut bool
+}
+
+// LimitedBuffer keeps the first limit bytes written to it and discards the rest.
+type LimitedBuffer struct {
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+//go:embed internal/tools/run_code.txt
+var RunCodePrompt string
+
+func init() {
+	strict := true
+
+	MustRegisterTool(&FunctionTool[RunCodeArguments]{
+		Function: openingrouter.ChatFunction{
+			Name:        "run_code",
+			Description: "Execute a Python, JavaScript (Node.js) or Go snippet in a sandbox without network access and return its stdout, stderr and exit code. Use this for calculations and data processing instead of computing by hand.",
+			Parameters: map[string]any{
+				"type":     "object",
+				"required": []string{"language", "code"},
+				"properties": map[string]any{
+					"language": map[string]any{
+						"type":        "string",
+						"enum":        []string{"python", "javascript", "go"},
+						"description": "The language of the snippet.",
+					},
+					"code": map[string]any{
+						"type":        "string",
+						"description": "The complete program to run. Go code must be a main package with a main function.",
+					},
+				},
+				"additionalProperties": false,
+			},
+			Strict: &strict,
+		},
+		Snippet: RunCodePrompt,
+		Enable: func(_ *ChatRequest, _ *Model) bool {
+			return env.Tools.Code.Enabled && env.Tools.Code.available
+		},
+		Handler: HandleRunCodeTool,
+	})
+}
+
+func HandleRunCodeTool(ctx context.Context, tool *ChatToolCall, arguments *RunCodeArguments) error {
+	if strings.TrimSpace(arguments.Code) == "" {
+		return errors.New("no code")
+	}
+
+	result, err := RunCode(ctx, arguments)
+	if err != nil {
+		tool.Result = fmt.Sprintf("error: %v", err)
+
+		return nil
+	}
+
+	tool.Result = result.String()
+
+	return nil
+}
+
+func RunCode(ctx context.Context, arguments *RunCodeArguments) (*RunCodeResult, error) {
+	cfg := env.Tools.Code
+
+	dir, err := os.MkdirTemp("", "whiskr-code-")
+	if err != nil {
+		return nil, err
+	}
+
+	defer os.RemoveAll(dir)
+
+	environ := []string{
+		"PATH=" + os.Getenv("PATH"),
+		"HOME=" + SandboxWorkDir,
+		"TMPDIR=" + SandboxWorkDir,
+		"LANG=C.UTF-8",
+	}
+
+	var (
+		file    string
+		command string
+		args    []string
+	)
+
+	switch arguments.Language {
+	case "python":
+		file = "main.py"
+		command = cfg.Python
+		args = []string{"main.py"}
+
+		environ = append(environ, "PYTHONDONTWRITEBYTECODE=1")
+	case "javascript":
+		file = "main.js"
+		command = cfg.JavaScript
+		args = []string{"main.js"}
+	case "go":
+		file = "main.go"
+		command = cfg.Go
+		args = []string{"run", "main.go"}
+
+		// a shared build cache could be poisoned by earlier runs
+		environ = append(environ,
+			"GOCACHE="+filepath.Join(SandboxWorkDir, ".cache"),
+			"GOPATH="+filepath.Join(SandboxWorkDir, ".go"),
+			"GOPROXY=off",
+			"GOTOOLCHAIN=local",
+			"GOFLAGS=-mod=mod",
+		)
+	default:
+		return nil, fmt.Errorf("unsupported language %q", arguments.Language)
+	}
+
+	err = os.WriteFile(filepath.Join(dir, file), []byte(arguments.Code), 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(cfg.Timeout)*time.Second)
+	defer cancel()
+
+	cmd, err := NewSandboxCommand(ctx, &cfg, dir, command, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &RunCodeResult{
+		Stdout: NewLimitedBuffer(int(cfg.MaxOutput)),
+		Stderr: NewLimitedBuffer(int(cfg.MaxOutput)),
+	}
+
+	cmd.Env = environ
+	cmd.Stdout = result.Stdout
+	cmd.Stderr = result.Stderr
+
+	debug("running %s snippet (%d bytes)", arguments.Language, len(arguments.Code))
+
+	err = cmd.Run()
+
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		result.TimedOut = true
+	} else if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	if cmd.ProcessState == nil {
+		return nil, err
+	}
+
+	result.ExitCode = cmd.ProcessState.ExitCode()
+
+	return result, nil
+}
+
+func (r *RunCodeResult) String() string {
+	var buf strings.Builder
+
+	if r.TimedOut {
+		fmt.Fprintf(&buf, "execution timed out after %ds\n", env.Tools.Code.Timeout)
+	}
+
+	fmt.Fprintf(&buf, "exit code: %d\n\n", r.ExitCode)
+
+	buf.WriteString("stdout:\n")
+	buf.WriteString(r.Stdout.String())
+
+	buf.WriteString("\n\nstderr:\n")
+	buf.WriteString(r.Stderr.String())
+
+	return buf.String()
+}
+
+func NewLimitedBuffer(limit int) *LimitedBuffer {
+	return &LimitedBuffer{
+		limit: limit,
+	}
+}
+
+func (b *LimitedBuffer) Write(p []byte) (int, error) {
+	remaining := b.limit - b.buf.Len()
+
+	if len(p) > remaining {
+		b.buf.Write(p[:max(remaining, 0)])
+
+		b.truncated = true
+	} else {
+		b.buf.Write(p)
+	}
+
+	return len(p), nil
+}
+
+func (b *LimitedBuffer) String() string {
+	if b.buf.Len() == 0 {
+		return "(empty)"
+	}
+
+	str := strings.ToValidUTF8(b.buf.String(), "")
+
+	if b.truncated {
+		str += fmt.Sprintf("\n(truncated to %d bytes)", b.limit)
+	}
+
+	return str
+}
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/coalaura/openingrouter"
+)
+
+// reasoning type reported by backends streaming plain text thoughts
+const ReasoningTypeText = "reasoning.text"
+
+const maxImageSize = 20 * 1024 * 1024
+
+// CompletionDelta is a backend independent update of a streamed completion.
+type CompletionDelta struct {
+	ID    string
+	Usage *Statistics
+
+	Content       string
+	Reasoning     string
+	ReasoningType string
+	Encrypted     []ChatToolReasoning
+	ToolCalls     []CompletionToolCall
+	Images        []string
+
+	Finish openingrouter.ChatFinishReason
+	Native string
+}
+
+// CompletionToolCall is a (partial) tool call, parts with the same index are
+// concatenated.
+type CompletionToolCall struct {
+	Index int
+	ID    string
+	Name  string
+	Args  string
+}
+
+// CompletionReasoning is encrypted reasoning and the index of the tool call it
+// arrived with, -1 if it arrived on its own.
+type CompletionReasoning struct {
+	Index     int
+	Reasoning ChatToolReasoning
+}
+
+// CompletionNotice is a non-fatal error of a completion, like a model stopping
+// at the token limit. The generation continues after it.
+type CompletionNotice struct {
+	Finish  openingrouter.ChatFinishReason
+	Native  string
+	Message string
+}
+
+type CompletionStream interface {
+	Recv() (*CompletionDelta, error)
+	Close() error
+}
+
+// SSEReader reads server-sent events from a response body.
+type SSEReader struct {
+	rd *bufio.Reader
+}
+
+// CompatibleStream adapts the stream of an openrouter or openai-compatible api.
+type CompatibleStream struct {
+	stream openingrouter.OpenrouterStream[openingrouter.ChatStreamChunk]
+}
+
+// StartStream starts a streamed completion on the backend.
+func (b *EnvBackend) StartStream(ctx context.Context, request *openingrouter.ChatCompletionRequest, proxy *EnvProxy) (CompletionStream, error) {
+	switch b.Type {
+	case APIOllama:
+		return OllamaStartStream(ctx, request, b, proxy)
+	case APIAnthropic:
+		return AnthropicStartStream(ctx, request, b, proxy)
+	case APIGemini:
+		return GeminiStartStream(ctx, request, b, proxy)
+	case APIResponses:
+		return ResponsesStartStream(ctx, request, b, proxy)
+	}
+
+	stream, err := OpenRouterStartStream(ctx, *request, b, proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CompatibleStream{
+		stream: stream,
+	}, nil
+}
+
+// Endpoint returns the url of a path of the backend's api.
+func (b *EnvBackend) Endpoint(path string) string {
+	return strings.TrimRight(b.BaseURL, "/") + path
+}
+
+// BackendRequest sends a json request to the api of a backend and decodes the
+// response into result. If result is nil, the response body is left open.
+func BackendRequest(ctx context.Context, backend *EnvBackend, proxy *EnvProxy, method, path string, body, result any) (*http.Response, error) {
+	var reader io.Reader
+
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, backend.Endpoint(path), reader)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	backend.Authorize(req)
+
+	resp, err := NewHttpClient(backend.ResolveProxy(proxy)).Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+
+		return nil, fmt.Errorf("%s api error (%d): %s", backend.Type, resp.StatusCode, backendError(data))
+	}
+
+	if result == nil {
+		return resp, nil
+	}
+
+	defer resp.Body.Close()
+
+	return resp, json.NewDecoder(resp.Body).Decode(result)
+}
+
+// Authorize sets the authentication headers the backend's api expects.
+func (b *EnvBackend) Authorize(req *http.Request) {
+	if b.Token == "" {
+		return
+	}
+
+	switch b.Type {
+	case APIAnthropic:
+		req.Header.Set("X-Api-Key", b.Token)
+		req.Header.Set("Anthropic-Version", anthropicVersion)
+	case APIGemini:
+		req.Header.Set("X-Goog-Api-Key", b.Token)
+	default:
+		req.Header.Set("Authorization", "Bearer "+b.Token)
+	}
+}
+
+// backendError extracts the message of an error response, which is either
+// {"error": "..."} or {"error": {"message": "..."}}.
+func backendError(data []byte) string {
+	var failure struct {
+		Error json.RawMessage `json:"error"`
+	}
+
+	if json.Unmarshal(data, &failure) == nil && len(failure.Error) > 0 {
+		var message string
+
+		if json.Unmarshal(failure.Error, &message) == nil && message != "" {
+			return message
+		}
+
+		var nested struct {
+			Message string `json:"message"`
+		}
+
+		if json.Unmarshal(failure.Error, &nested) == nil && nested.Message != "" {
+			return nested.Message
+		}
+	}
+
+	return strings.TrimSpace(string(data))
+}
+
+// Complete runs a completion to its end and returns its text and cost.
+func (b *EnvBackend) Complete(ctx context.Context, request *openingrouter.ChatCompletionRequest, proxy *EnvProxy) (string, float64, error) {
+	switch b.Type {
+	case APIOpenRouter, APIOpenAI:
+		response, err := OpenRouterRun(ctx, *request, b, proxy)
+		if err != nil {
+			return "", 0, err
+		}
+
+		var cost float64
+
+		if response.Usage != nil {
+			cost = Nullable(response.Usage.Cost, 0)
+
+			if response.Usage.CostDetails != nil {
+				cost += Nullable(response.Usage.CostDetails.UpstreamInferenceCost, 0)
+			}
+		}
+
+		if len(response.Choices) == 0 {
+			return "", cost, errors.New("no choices")
+		}
+
+		return response.Choices[0].Message.Content.String(), cost, nil
+	}
+
+	stream, err := b.StartStream(ctx, request, proxy)
+	if err != nil {
+		return "", 0, err
+	}
+
+	defer stream.Close()
+
+	var (
+		text strings.Builder
+		cost float64
+	)
+
+	for {
+		delta, err := stream.Recv()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+
+			return "", cost, err
+		}
+
+		if delta.Usage != nil {
+			cost = delta.Usage.Cost
+		}
+
+		text.WriteString(delta.Content)
+	}
+
+	return text.String(), cost, nil
+}
+
+// RequestFunctions returns the function definitions of the request's tools.
+func RequestFunctions(request *openingrouter.ChatCompletionRequest) []openingrouter.ChatFunction {
+	functions := make([]openingrouter.ChatFunction, 0, len(request.Tools))
+
+	for _, tool := range request.Tools {
+		if definition, ok := tool.(openingrouter.ChatFunctionTool); ok {
+			functions = append(functions, definition.Function)
+		}
+	}
+
+	return functions
+}
+
+// LoadImage returns the mime type and contents of an image referenced by a
+// data url or downloads it, for backends that only accept inline images.
+// Downloads go through the fetch client, so links can't reach local addresses.
+func LoadImage(ctx context.Context, link string) (string, []byte, error) {
+	if strings.HasPrefix(link, "data:") {
+		return DecodeDataURL(link)
+	}
+
+	parsed, err := url.Parse(link)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return "", nil, fmt.Errorf("unsupported image url scheme %q", parsed.Scheme)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", parsed.String(), nil)
+	if err != nil {
+		return "", nil, err
+	}
+
+	resp, err := fetchClient.Do(req)
+	if err != nil {
+		return "", nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", nil, fmt.Errorf("unable to load image (%d): %s", resp.StatusCode, link)
+	}
+
+	typ, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if !strings.HasPrefix(typ, "image/") {
+		return "", nil, fmt.Errorf("not an image (%q): %s", typ, link)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxImageSize+1))
+	if err != nil {
+		return "", nil, err
+	}
+
+	if len(data) > maxImageSize {
+		return "", nil, fmt.Errorf("image too large: %s", link)
+	}
+
+	return typ, data, nil
+}
+
+func (n *CompletionNotice) Error() string {
+	return n.Message
+}
+
+func (s *CompatibleStream) Recv() (*CompletionDelta, error) {
+	chunk, err := s.stream.Recv()
+	if err != nil {
+		return nil, err
+	}
+
+	delta := &CompletionDelta{
+		ID: chunk.ID,
+	}
+
+	if chunk.Usage != nil {
+		provider := streamProvider(chunk.OpenRouterMetadata)
+
+		debug("usage chunk: model=%q provider=%q prompt=%d completion=%d cost=%v", chunk.Model, provider, chunk.Usage.PromptTokens, chunk.Usage.CompletionTokens, chunk.Usage.Cost)
+
+		delta.Usage = CreateStatistics(chunk.Model, provider, chunk.Usage)
+	}
+
+	if len(chunk.Choices) == 0 {
+		return delta, nil
+	}
+
+	choice := chunk.Choices[0]
+
+	delta.Finish = choice.FinishReason
+	delta.Content = choice.Delta.Content
+	delta.Reasoning = choice.Delta.Reasoning
+
+	for _, call := range choice.Delta.ToolCalls {
+		part := CompletionToolCall{
+			Index: call.Index,
+			ID:    call.ID,
+		}
+
+		if call.Function != nil {
+			part.Name = call.Function.Name
+			part.Args = call.Function.Arguments
+		}
+
+		delta.ToolCalls = append(delta.ToolCalls, part)
+	}
+
+	for i, details := range choice.Delta.ReasoningDetails {
+		if i == 0 {
+			delta.ReasoningType = string(details.Type)
+		}
+
+		if details.Type == openingrouter.ChatReasoningDetailTypeEncrypted {
+			delta.Encrypted = append(delta.Encrypted, ChatToolReasoning{
+				ID:        details.ID,
+				Format:    string(details.Format),
+				Encrypted: details.Data,
+			})
+		}
+	}
+
+	for _, image := range choice.Delta.Images {
+		if image.ImageURL.URL != "" {
+			delta.Images = append(delta.Images, image.ImageURL.URL)
+		}
+	}
+
+	return delta, nil
+}
+
+func (s *CompatibleStream) Close() error {
+	return s.stream.Close()
+}
+
+func NewSSEReader(r io.Reader) *SSEReader {
+	return &SSEReader{
+		rd: bufio.NewReader(r),
+	}
+}
+
+// Next returns the name and data of the next event carrying data.
+func (r *SSEReader) Next() (string, []byte, error) {
+	var (
+		event string
+		data  []byte
+	)
+
+	for {
+		line, err := r.rd.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return "", nil, err
+		}
+
+		eof := err != nil
+
+		line = bytes.TrimRight(line, "\r\n")
+
+		if len(line) == 0 {
+			if len(data) > 0 {
+				return event, data, nil
+			}
+
+			if eof {
+				return "", nil, io.EOF
+			}
+
+			event = ""
+
+			continue
+		}
+
+		field, value, _ := bytes.Cut(line, []byte(":"))
+		value = bytes.TrimPrefix(value, []byte(" "))
+
+		switch string(field) {
+		case "event":
+			event = string(value)
+		case "data":
+			if len(data) > 0 {
+				data = append(data, '\n')
+			}
+
+			data = append(data, value...)
+		}
+
+		if eof {
+			if len(data) > 0 {
+				return event, data, nil
+			}
+
+			return "", nil, io.EOF
+		}
+	}
+}
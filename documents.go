@@ -0,0 +1,584 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/coalaura/openingrouter"
+)
+
+const (
+	DocumentMaxFileSize = 2 * 1024 * 1024
+	DocumentEmbedBatch  = 64
+
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+type SearchDocumentsArguments struct {
+	Query      string `json:"query"`
+	MaxResults int    `json:"max_results,omitempty"`
+}
+
+type DocumentChunk struct {
+	File  string
+	Start int
+	End   int
+	Text  string
+
+	terms  map[string]int
+	length int
+	vector []float32
+}
+
+type DocumentFile struct {
+	ModTime time.Time
+	Size    int64
+	Chunks  []*DocumentChunk
+}
+
+type DocumentIndex struct {
+	mx sync.RWMutex
+
+	dir   string
+	files map[string]*DocumentFile
+
+	chunks    []*DocumentChunk
+	frequency map[string]int
+	average   float64
+}
+
+type DocumentResult struct {
+	Chunk *DocumentChunk
+	Score float64
+}
+
+//go:embed internal/tools/search_documents.txt
+var SearchDocumentsPrompt string
+
+var documents *DocumentIndex
+
+func init() {
+	MustRegisterTool(&FunctionTool[SearchDocumentsArguments]{
+		Function: openingrouter.ChatFunction{
+			Name:        "search_documents",
+			Description: "Search the internal document knowledge base and return the best matching passages with file and line references.",
+			Parameters: map[string]any{
+				"type":     "object",
+				"required": []string{"query"},
+				"properties": map[string]any{
+					"query": map[string]any{
+						"type":        "string",
+						"description": "Keywords describing the information you are looking for.",
+					},
+					"max_results": map[string]any{
+						"type":        "integer",
+						"description": "Number of passages to return (1-10). Default is 5.",
+						"minimum":     1,
+						"maximum":     10,
+					},
+				},
+				"additionalProperties": false,
+			},
+		},
+		Snippet: SearchDocumentsPrompt,
+		Enable: func(_ *ChatRequest, _ *Model) bool {
+			return documents != nil
+		},
+		Handler: HandleSearchDocumentsTool,
+	})
+}
+
+// LoadDocumentIndex indexes the configured document directory and keeps
+// polling it for changes.
+func LoadDocumentIndex() error {
+	dir := env.Tools.Documents.Directory
+
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(filepath.Dir(path.Config), dir)
+	}
+
+	stat, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+
+	if !stat.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+
+	// embeddings are requested from an openai-compatible endpoint
+	if model := env.Tools.Documents.Embeddings; model != "" {
+		if backend, _ := SplitModelSlug(model); !backend.HasEmbeddings() {
+			log.Warnf("Backend %q has no openai-compatible embeddings endpoint, document embeddings disabled\n", backend.Name)
+
+			env.Tools.Documents.Embeddings = ""
+		}
+	}
+
+	index := &DocumentIndex{
+		dir:   dir,
+		files: make(map[string]*DocumentFile),
+	}
+
+	err = index.Update()
+	if err != nil {
+		return err
+	}
+
+	files, chunks := index.Size()
+
+	log.Printf("Indexed %d document(s) (%d passages)\n", files, chunks)
+
+	documents = index
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(env.Tools.Documents.Interval) * time.Second)
+
+		for range ticker.C {
+			err := index.Update()
+			if err != nil {
+				log.Warnf("Unable to update document index: %v\n", err)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Update re-indexes changed files and drops deleted ones.
+func (d *DocumentIndex) Update() error {
+	d.mx.RLock()
+
+	known := make(map[string]*DocumentFile, len(d.files))
+
+	for name, file := range d.files {
+		known[name] = file
+	}
+
+	d.mx.RUnlock()
+
+	var (
+		changed bool
+		found   = make(map[string]*DocumentFile, len(known))
+		pending []*DocumentChunk
+	)
+
+	err := filepath.WalkDir(d.dir, func(full string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if entry.IsDir() {
+			if full != d.dir && strings.HasPrefix(entry.Name(), ".") {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if !slices.Contains(env.Tools.Documents.Extensions, strings.ToLower(filepath.Ext(full))) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil || info.Size() > DocumentMaxFileSize {
+			return nil
+		}
+
+		name, err := filepath.Rel(d.dir, full)
+		if err != nil {
+			return nil
+		}
+
+		name = filepath.ToSlash(name)
+
+		if file, ok := known[name]; ok && file.Size == info.Size() && file.ModTime.Equal(info.ModTime()) {
+			found[name] = file
+
+			return nil
+		}
+
+		chunks, err := ChunkDocument(full, name)
+		if err != nil {
+			log.Warnf("Unable to index %s: %v\n", name, err)
+
+			return nil
+		}
+
+		found[name] = &DocumentFile{
+			ModTime: info.ModTime(),
+			Size:    info.Size(),
+			Chunks:  chunks,
+		}
+
+		changed = true
+
+		debug("indexed document %s (%d passages)", name, len(chunks))
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(found) != len(known) {
+		changed = true
+	}
+
+	// passages whose embedding failed earlier are retried with the new ones
+	if env.Tools.Documents.Embeddings != "" {
+		for _, file := range found {
+			for _, chunk := range file.Chunks {
+				if chunk.vector == nil {
+					pending = append(pending, chunk)
+				}
+			}
+		}
+
+		if len(pending) > 0 {
+			err = d.Embed(pending)
+			if err != nil {
+				log.Warnf("Unable to create document embeddings: %v\n", err)
+			}
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+
+	var (
+		chunks    []*DocumentChunk
+		frequency = make(map[string]int)
+		total     int
+	)
+
+	for _, file := range found {
+		for _, chunk := range file.Chunks {
+			chunks = append(chunks, chunk)
+
+			for term := range chunk.terms {
+				frequency[term]++
+			}
+
+			total += chunk.length
+		}
+	}
+
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	d.files = found
+	d.chunks = chunks
+	d.frequency = frequency
+	d.average = 0
+
+	if len(chunks) > 0 {
+		d.average = float64(total) / float64(len(chunks))
+	}
+
+	return nil
+}
+
+// Stats reports the index size, nil if no documents are indexed.
+func (d *DocumentIndex) Stats() map[string]any {
+	if d == nil {
+		return nil
+	}
+
+	files, chunks := d.Size()
+
+	return map[string]any{
+		"files":    files,
+		"passages": chunks,
+	}
+}
+
+func (d *DocumentIndex) Size() (int, int) {
+	d.mx.RLock()
+	defer d.mx.RUnlock()
+
+	return len(d.files), len(d.chunks)
+}
+
+// Search ranks all passages by bm25 and, if embeddings are available, fuses
+// the result with the vector similarity ranking.
+func (d *DocumentIndex) Search(ctx context.Context, query string, limit int) ([]DocumentResult, error) {
+	terms := TokenizeDocument(query)
+	if len(terms) == 0 {
+		return nil, errors.New("empty query")
+	}
+
+	var vector []float32
+
+	if env.Tools.Documents.Embeddings != "" {
+		embeddings, err := OpenRouterEmbeddings(ctx, env.Tools.Documents.Embeddings, []string{query})
+		if err != nil {
+			log.Warnf("Unable to embed document query: %v\n", err)
+		} else {
+			vector = embeddings[0]
+		}
+	}
+
+	d.mx.RLock()
+	defer d.mx.RUnlock()
+
+	total := float64(len(d.chunks))
+
+	lexical := make([]DocumentResult, 0, len(d.chunks))
+
+	for _, chunk := range d.chunks {
+		var score float64
+
+		for _, term := range terms {
+			count := chunk.terms[term]
+			if count == 0 {
+				continue
+			}
+
+			frequency := float64(d.frequency[term])
+			idf := math.Log(1 + (total-frequency+0.5)/(frequency+0.5))
+			tf := float64(count)
+
+			score += idf * (tf * (bm25K1 + 1)) / (tf + bm25K1*(1-bm25B+bm25B*float64(chunk.length)/d.average))
+		}
+
+		if score > 0 {
+			lexical = append(lexical, DocumentResult{
+				Chunk: chunk,
+				Score: score,
+			})
+		}
+	}
+
+	sortDocumentResults(lexical)
+
+	if vector == nil {
+		return lexical[:min(limit, len(lexical))], nil
+	}
+
+	semantic := make([]DocumentResult, 0, len(d.chunks))
+
+	for _, chunk := range d.chunks {
+		if chunk.vector == nil {
+			continue
+		}
+
+		semantic = append(semantic, DocumentResult{
+			Chunk: chunk,
+			Score: cosineSimilarity(vector, chunk.vector),
+		})
+	}
+
+	sortDocumentResults(semantic)
+
+	// reciprocal rank fusion of both rankings
+	fused := make(map[*DocumentChunk]float64)
+
+	for rank, result := range lexical {
+		fused[result.Chunk] += 1 / float64(60+rank)
+	}
+
+	for rank, result := range semantic[:min(len(semantic), max(len(lexical), limit*4))] {
+		fused[result.Chunk] += 1 / float64(60+rank)
+	}
+
+	results := make([]DocumentResult, 0, len(fused))
+
+	for chunk, score := range fused {
+		results = append(results, DocumentResult{
+			Chunk: chunk,
+			Score: score,
+		})
+	}
+
+	sortDocumentResults(results)
+
+	return results[:min(limit, len(results))], nil
+}
+
+func HandleSearchDocumentsTool(ctx context.Context, tool *ChatToolCall, arguments *SearchDocumentsArguments) error {
+	if strings.TrimSpace(arguments.Query) == "" {
+		return errors.New("no query")
+	}
+
+	limit := arguments.MaxResults
+	if limit <= 0 {
+		limit = 5
+	}
+
+	results, err := documents.Search(ctx, arguments.Query, min(limit, 10))
+	if err != nil {
+		tool.Result = fmt.Sprintf("error: %v", err)
+
+		return nil
+	}
+
+	if len(results) == 0 {
+		tool.Result = "error: no matching documents"
+
+		return nil
+	}
+
+	var buf strings.Builder
+
+	for i, result := range results {
+		if i > 0 {
+			buf.WriteString("\n\n")
+		}
+
+		fmt.Fprintf(&buf, "## %s (lines %d-%d)\n\n", result.Chunk.File, result.Chunk.Start, result.Chunk.End)
+
+		buf.WriteString(result.Chunk.Text)
+	}
+
+	tool.Result = buf.String()
+
+	return nil
+}
+
+// ChunkDocument splits a file into overlapping passages of chunk-size lines.
+func ChunkDocument(full, name string) ([]*DocumentChunk, error) {
+	data, err := os.ReadFile(full)
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.IndexByte(data, 0) != -1 {
+		return nil, errors.New("binary file")
+	}
+
+	lines := strings.Split(strings.ToValidUTF8(string(data), ""), "\n")
+
+	var (
+		size    = int(env.Tools.Documents.ChunkSize)
+		step    = max(size-size/5, 1)
+		chunks  []*DocumentChunk
+		current int
+	)
+
+	for current < len(lines) {
+		end := min(current+size, len(lines))
+
+		text := strings.TrimSpace(strings.Join(lines[current:end], "\n"))
+
+		if text != "" {
+			terms := TokenizeDocument(name + "\n" + text)
+
+			chunk := &DocumentChunk{
+				File:   name,
+				Start:  current + 1,
+				End:    end,
+				Text:   text,
+				terms:  make(map[string]int, len(terms)),
+				length: len(terms),
+			}
+
+			for _, term := range terms {
+				chunk.terms[term]++
+			}
+
+			chunks = append(chunks, chunk)
+		}
+
+		if end == len(lines) {
+			break
+		}
+
+		current += step
+	}
+
+	return chunks, nil
+}
+
+// Embed creates the embeddings of the given passages. Passages of failed
+// batches are left without a vector.
+func (d *DocumentIndex) Embed(chunks []*DocumentChunk) error {
+	for start := 0; start < len(chunks); start += DocumentEmbedBatch {
+		batch := chunks[start:min(start+DocumentEmbedBatch, len(chunks))]
+
+		inputs := make([]string, len(batch))
+
+		for i, chunk := range batch {
+			inputs[i] = chunk.File + "\n\n" + chunk.Text
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+
+		embeddings, err := OpenRouterEmbeddings(ctx, env.Tools.Documents.Embeddings, inputs)
+
+		cancel()
+
+		if err != nil {
+			return err
+		}
+
+		d.mx.Lock()
+
+		for i, chunk := range batch {
+			chunk.vector = embeddings[i]
+		}
+
+		d.mx.Unlock()
+	}
+
+	return nil
+}
+
+func TokenizeDocument(text string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	terms := fields[:0]
+
+	for _, field := range fields {
+		if len(field) < 2 {
+			continue
+		}
+
+		terms = append(terms, field)
+	}
+
+	return terms
+}
+
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+
+	var dot, na, nb float64
+
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+
+	if na == 0 || nb == 0 {
+		return 0
+	}
+
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
+
+func sortDocumentResults(results []DocumentResult) {
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+}
@@ -8,10 +8,13 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/goccy/go-yaml"
 	"golang.org/x/crypto/bcrypt"
@@ -21,6 +24,10 @@ import (
 const (
 	APIOpenRouter = "openrouter"
 	APIOpenAI     = "openai"
+	APIOllama     = "ollama"
+	APIAnthropic  = "anthropic"
+	APIGemini     = "gemini"
+	APIResponses  = "openai-responses"
 )
 
 // gost:preserve-layout
@@ -29,6 +36,8 @@ type EnvTokens struct {
 	OpenRouter string `yaml:"openrouter"`
 	OpenAI     string `yaml:"openai"`
 	Tavily     string `yaml:"tavily"`
+	Brave      string `yaml:"brave"`
+	Kagi       string `yaml:"kagi"`
 	GitHub     string `yaml:"github"`
 }
 
@@ -42,12 +51,34 @@ type EnvSettings struct {
 	CleanContent    bool  `yaml:"cleanup"`
 	Timeout         int64 `yaml:"timeout"`
 	RefreshInterval int64 `yaml:"refresh-interval"`
+	JobRetention    int64 `yaml:"job-retention"`
+	MaxGenerations  int64 `yaml:"max-generations"`
+	Iterations      int64 `yaml:"iterations"`
+	MaxImages       int   `yaml:"max-images"`
 }
 
 // gost:preserve-layout
 type EnvLLM struct {
-	API     string `yaml:"api"`
+	backends []*EnvBackend
+
+	API      string        `yaml:"api"`
+	BaseURL  string        `yaml:"base-url"`
+	Backends []*EnvBackend `yaml:"backends"`
+}
+
+// gost:preserve-layout
+type EnvBackend struct {
+	proxy   *EnvProxy
+	primary bool
+
+	Name    string `yaml:"name"`
+	Type    string `yaml:"type"`
 	BaseURL string `yaml:"base-url"`
+	Token   string `yaml:"token"`
+	Proxy   string `yaml:"proxy"`
+
+	KeepAlive string `yaml:"keep-alive"`
+	NumCtx    int    `yaml:"num-ctx"`
 }
 
 // gost:preserve-layout
@@ -61,6 +92,103 @@ type EnvModels struct {
 	filters *Filters
 }
 
+// gost:preserve-layout
+type EnvMCPServer struct {
+	Name    string            `yaml:"name"`
+	Command string            `yaml:"command"`
+	Args    []string          `yaml:"args"`
+	Env     map[string]string `yaml:"env"`
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers"`
+	Timeout int64             `yaml:"timeout"`
+}
+
+// gost:preserve-layout
+type EnvForge struct {
+	Name  string `yaml:"name"`
+	Type  string `yaml:"type"`
+	URL   string `yaml:"url"`
+	Token string `yaml:"token"`
+}
+
+// gost:preserve-layout
+type EnvWebhookTool struct {
+	Name        string            `yaml:"name"`
+	Description string            `yaml:"description"`
+	Parameters  map[string]any    `yaml:"parameters"`
+	URL         string            `yaml:"url"`
+	Method      string            `yaml:"method"`
+	Headers     map[string]string `yaml:"headers"`
+	Timeout     int64             `yaml:"timeout"`
+	MaxSize     int64             `yaml:"max-size"`
+}
+
+// gost:preserve-layout
+type EnvCode struct {
+	Enabled    bool     `yaml:"enabled"`
+	Timeout    int64    `yaml:"timeout"`
+	Memory     int64    `yaml:"memory"`
+	Processes  int64    `yaml:"processes"`
+	MaxOutput  int64    `yaml:"max-output"`
+	Python     string   `yaml:"python"`
+	JavaScript string   `yaml:"javascript"`
+	Go         string   `yaml:"go"`
+	Mounts     []string `yaml:"mounts"`
+
+	// whether the sandbox works, the configured value is never changed
+	available bool
+}
+
+// gost:preserve-layout
+type EnvCache struct {
+	Enabled bool             `yaml:"enabled"`
+	TTL     map[string]int64 `yaml:"ttl"`
+}
+
+// gost:preserve-layout
+type EnvBudget struct {
+	Tool    int64            `yaml:"tool"`
+	Request int64            `yaml:"request"`
+	Tools   map[string]int64 `yaml:"tools"`
+}
+
+// gost:preserve-layout
+type EnvApproval struct {
+	Tools   []string `yaml:"tools"`
+	Timeout int64    `yaml:"timeout"`
+}
+
+// gost:preserve-layout
+type EnvDocuments struct {
+	Directory  string   `yaml:"directory"`
+	Extensions []string `yaml:"extensions"`
+	ChunkSize  int64    `yaml:"chunk-size"`
+	Interval   int64    `yaml:"interval"`
+	Embeddings string   `yaml:"embeddings"`
+}
+
+// gost:preserve-layout
+type EnvSearch struct {
+	provider SearchProvider
+
+	Provider string `yaml:"provider"`
+	SearXNG  string `yaml:"searxng"`
+}
+
+// gost:preserve-layout
+type EnvTools struct {
+	Disabled  []string         `yaml:"disabled"`
+	Cache     EnvCache         `yaml:"cache"`
+	Budget    EnvBudget        `yaml:"budget"`
+	Approval  EnvApproval      `yaml:"approval"`
+	Search    EnvSearch        `yaml:"search"`
+	Code      EnvCode          `yaml:"code"`
+	Documents EnvDocuments     `yaml:"documents"`
+	Forges    []EnvForge       `yaml:"forges"`
+	MCP       []EnvMCPServer   `yaml:"mcp"`
+	Webhooks  []EnvWebhookTool `yaml:"webhooks"`
+}
+
 // gost:preserve-layout
 type EnvUI struct {
 	ReducedMotion bool `yaml:"reduced-motion"`
@@ -68,13 +196,16 @@ type EnvUI struct {
 
 // gost:preserve-layout
 type EnvUser struct {
-	Username string `yaml:"username"`
-	Password string `yaml:"password"`
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+	Admin    bool     `yaml:"admin"`
+	Keys     []string `yaml:"api-keys"`
 }
 
 // gost:preserve-layout
 type EnvAuthentication struct {
 	lookup map[string]*EnvUser
+	keys   map[string]*EnvUser
 
 	Enabled bool       `yaml:"enabled"`
 	Users   []*EnvUser `yaml:"users"`
@@ -101,6 +232,7 @@ type Environment struct {
 	Settings       EnvSettings       `yaml:"settings"`
 	LLM            EnvLLM            `yaml:"llm"`
 	Models         EnvModels         `yaml:"models"`
+	Tools          EnvTools          `yaml:"tools"`
 	UI             EnvUI             `yaml:"ui"`
 	Authentication EnvAuthentication `yaml:"authentication"`
 }
@@ -114,6 +246,10 @@ func DefaultEnv() *Environment {
 			CleanContent:    true,
 			Timeout:         1200,
 			RefreshInterval: 30,
+			JobRetention:    10,
+			MaxGenerations:  4,
+			Iterations:      3,
+			MaxImages:       8,
 		},
 		LLM: EnvLLM{
 			API: APIOpenRouter,
@@ -122,6 +258,15 @@ func DefaultEnv() *Environment {
 			ImageGeneration: true,
 			TextToSpeech:    true,
 		},
+		Tools: EnvTools{
+			Cache: EnvCache{
+				Enabled: true,
+			},
+			Budget: EnvBudget{
+				Tool:    8000,
+				Request: 32000,
+			},
+		},
 	}
 }
 
@@ -176,11 +321,6 @@ func (e *Environment) Addr() string {
 	return fmt.Sprintf(":%d", e.Server.Port)
 }
 
-// IsOpenAI reports whether the configured llm api is an openai-compatible endpoint.
-func (e *Environment) IsOpenAI() bool {
-	return e.LLM.API == APIOpenAI
-}
-
 func (e *Environment) Init() error {
 	var store bool
 
@@ -227,31 +367,42 @@ func (e *Environment) Init() error {
 		e.LLM.BaseURL = "https://openrouter.ai/api/v1/"
 	}
 
-	if e.LLM.API != APIOpenRouter && e.LLM.API != APIOpenAI {
-		return fmt.Errorf("invalid llm.api %q (must be %q or %q)", e.LLM.API, APIOpenRouter, APIOpenAI)
-	}
+	if len(e.LLM.Backends) == 0 {
+		if e.LLM.API != APIOpenRouter && e.LLM.API != APIOpenAI {
+			return fmt.Errorf("invalid llm.api %q (must be %q or %q)", e.LLM.API, APIOpenRouter, APIOpenAI)
+		}
 
-	// check the api token for the selected llm api
-	switch e.LLM.API {
-	case APIOpenAI:
-		if e.Tokens.OpenAI == "" {
-			return errors.New("missing tokens.openai")
+		// check the api token for the selected llm api
+		switch e.LLM.API {
+		case APIOpenAI:
+			if e.Tokens.OpenAI == "" {
+				return errors.New("missing tokens.openai")
+			}
+		default:
+			if e.Tokens.OpenRouter == "" {
+				return errors.New("missing tokens.openrouter")
+			}
 		}
-	default:
-		if e.Tokens.OpenRouter == "" {
-			return errors.New("missing tokens.openrouter")
+
+		if e.LLM.API == APIOpenAI {
+			log.Warnf("Using OpenAI compatible endpoint: %s\n", e.LLM.BaseURL)
 		}
 	}
 
-	if e.LLM.API == APIOpenAI {
-		log.Warnf("Using OpenAI compatible endpoint: %s\n", e.LLM.BaseURL)
+	// select the web search provider
+	provider, err := NewSearchProvider(&e.Tokens, &e.Tools.Search)
+	if err != nil {
+		return err
 	}
 
-	// check if tavily token is set
-	if e.Tokens.Tavily == "" {
-		log.Warnln("Missing token.tavily, web search unavailable")
+	if provider == nil {
+		log.Warnln("No search provider configured, web search unavailable")
+	} else {
+		log.Printf("Using %s for web search\n", provider.Name())
 	}
 
+	e.Tools.Search.provider = provider
+
 	// check if github token is set
 	if e.Tokens.GitHub == "" {
 		log.Warnln("Missing token.github, limited api requests")
@@ -289,6 +440,25 @@ func (e *Environment) Init() error {
 		e.Settings.RefreshInterval = 30
 	}
 
+	// default generation retention
+	if e.Settings.JobRetention <= 0 {
+		e.Settings.JobRetention = 10
+	}
+
+	// default concurrent generations
+	if e.Settings.MaxGenerations <= 0 {
+		e.Settings.MaxGenerations = 4
+	}
+
+	// default api limits
+	if e.Settings.Iterations <= 0 {
+		e.Settings.Iterations = 3
+	}
+
+	if e.Settings.MaxImages <= 0 {
+		e.Settings.MaxImages = 8
+	}
+
 	// make it harder to disable auth accidentally
 	if !e.Authentication.Enabled && len(e.Authentication.Users) > 0 {
 		return errors.New("authentication disabled but users defined")
@@ -321,8 +491,278 @@ func (e *Environment) Init() error {
 		proxy.transport = NewProxyTransport(proxy.Host, proxy.Token)
 	}
 
+	// validate llm backends, without any the llm api becomes the only one
+	if len(e.LLM.Backends) == 0 {
+		token := e.Tokens.OpenRouter
+
+		if e.LLM.API == APIOpenAI {
+			token = e.Tokens.OpenAI
+		}
+
+		e.LLM.backends = []*EnvBackend{
+			{
+				primary: true,
+				Name:    e.LLM.API,
+				Type:    e.LLM.API,
+				BaseURL: e.LLM.BaseURL,
+				Token:   token,
+			},
+		}
+	} else {
+		backendNames := make(map[string]struct{}, len(e.LLM.Backends))
+
+		for i, backend := range e.LLM.Backends {
+			if backend.Name == "" {
+				return fmt.Errorf("backend %d missing name", i)
+			}
+
+			if !backendNameRgx.MatchString(backend.Name) {
+				return fmt.Errorf("invalid backend name %q (only lowercase letters, digits, - and _)", backend.Name)
+			}
+
+			if _, ok := backendNames[backend.Name]; ok {
+				return fmt.Errorf("duplicate backend name %q", backend.Name)
+			}
+
+			backendNames[backend.Name] = struct{}{}
+
+			base, ok := backendBaseURLs[backend.Type]
+			if !ok {
+				return fmt.Errorf("backend %q has invalid type %q", backend.Name, backend.Type)
+			}
+
+			if backend.BaseURL == "" {
+				backend.BaseURL = base
+			}
+
+			// fall back to the shared tokens
+			if backend.Token == "" {
+				switch backend.Type {
+				case APIOpenRouter:
+					backend.Token = e.Tokens.OpenRouter
+				case APIOpenAI, APIResponses:
+					backend.Token = e.Tokens.OpenAI
+				}
+			}
+
+			if backend.Token == "" && (backend.Type == APIOpenRouter || backend.Type == APIAnthropic || backend.Type == APIGemini) {
+				return fmt.Errorf("backend %q missing token", backend.Name)
+			}
+
+			if backend.KeepAlive != "" {
+				if _, err := strconv.Atoi(backend.KeepAlive); err != nil {
+					if _, err := time.ParseDuration(backend.KeepAlive); err != nil {
+						return fmt.Errorf("backend %q has invalid keep-alive %q", backend.Name, backend.KeepAlive)
+					}
+				}
+			}
+
+			if backend.NumCtx < 0 {
+				return fmt.Errorf("backend %q has invalid num-ctx %d", backend.Name, backend.NumCtx)
+			}
+
+			if backend.Proxy != "" {
+				for i := range e.Proxies {
+					if e.Proxies[i].Name == backend.Proxy {
+						backend.proxy = &e.Proxies[i]
+					}
+				}
+
+				if backend.proxy == nil {
+					return fmt.Errorf("backend %q uses unknown proxy %q", backend.Name, backend.Proxy)
+				}
+			}
+
+			backend.primary = i == 0
+		}
+
+		e.LLM.backends = e.LLM.Backends
+	}
+
+	// code execution defaults
+	if e.Tools.Code.Timeout <= 0 {
+		e.Tools.Code.Timeout = 30
+	}
+
+	if e.Tools.Code.Memory <= 0 {
+		e.Tools.Code.Memory = 512
+	}
+
+	if e.Tools.Code.Processes <= 0 {
+		e.Tools.Code.Processes = 256
+	}
+
+	if e.Tools.Code.MaxOutput <= 0 {
+		e.Tools.Code.MaxOutput = 16 * 1024
+	}
+
+	if e.Tools.Code.Python == "" {
+		e.Tools.Code.Python = "python3"
+	}
+
+	if e.Tools.Code.JavaScript == "" {
+		e.Tools.Code.JavaScript = "node"
+	}
+
+	if e.Tools.Code.Go == "" {
+		e.Tools.Code.Go = "go"
+	}
+
+	if e.Tools.Code.Enabled {
+		err = CheckSandbox(&e.Tools.Code)
+		if err != nil {
+			log.Warnf("Code execution unavailable: %v\n", err)
+		} else {
+			e.Tools.Code.available = true
+		}
+	}
+
+	// tool cache defaults, explicitly configured ttls (including 0) are kept
+	if e.Tools.Cache.TTL == nil {
+		e.Tools.Cache.TTL = make(map[string]int64)
+	}
+
+	for name, ttl := range DefaultToolCacheTTL {
+		if _, ok := e.Tools.Cache.TTL[name]; !ok {
+			e.Tools.Cache.TTL[name] = ttl
+		}
+	}
+
+	// tool budgets, 0 disables a budget
+	e.Tools.Budget.Tool = max(e.Tools.Budget.Tool, 0)
+	e.Tools.Budget.Request = max(e.Tools.Budget.Request, 0)
+
+	for name, budget := range e.Tools.Budget.Tools {
+		if budget < 0 {
+			return fmt.Errorf("invalid budget %d for tool %q", budget, name)
+		}
+	}
+
+	if e.Tools.Approval.Timeout <= 0 {
+		e.Tools.Approval.Timeout = 300
+	}
+
+	// document index defaults
+	if len(e.Tools.Documents.Extensions) == 0 {
+		e.Tools.Documents.Extensions = []string{".md", ".markdown", ".txt", ".rst", ".adoc", ".org", ".html", ".csv"}
+	}
+
+	if e.Tools.Documents.ChunkSize <= 0 {
+		e.Tools.Documents.ChunkSize = 40
+	}
+
+	if e.Tools.Documents.Interval <= 0 {
+		e.Tools.Documents.Interval = 30
+	}
+
+	// validate mcp servers
+	mcpNames := make(map[string]struct{}, len(e.Tools.MCP))
+
+	for i := range e.Tools.MCP {
+		server := &e.Tools.MCP[i]
+
+		if server.Name == "" {
+			return errors.New("mcp server missing name")
+		}
+
+		if !IsToolName(server.Name) {
+			return fmt.Errorf("invalid mcp server name %q (allowed: a-z, A-Z, 0-9, _ and -)", server.Name)
+		}
+
+		if (server.Command == "") == (server.URL == "") {
+			return fmt.Errorf("mcp server %q needs either command or url", server.Name)
+		}
+
+		if _, ok := mcpNames[server.Name]; ok {
+			return fmt.Errorf("duplicate mcp server name %q", server.Name)
+		}
+
+		mcpNames[server.Name] = struct{}{}
+
+		// default mcp timeout
+		if server.Timeout <= 0 {
+			server.Timeout = 60
+		}
+	}
+
+	// validate forges
+	forgeNames := make(map[string]struct{}, len(e.Tools.Forges))
+
+	for i := range e.Tools.Forges {
+		forge := &e.Tools.Forges[i]
+
+		forge.Type = strings.ToLower(forge.Type)
+
+		switch forge.Type {
+		case ForgeGitLab, ForgeGitea, ForgeForgejo:
+		default:
+			return fmt.Errorf("forge %q has invalid type %q (allowed: gitlab, gitea, forgejo)", forge.Name, forge.Type)
+		}
+
+		forge.URL = strings.TrimRight(forge.URL, "/")
+
+		parsed, err := url.Parse(forge.URL)
+		if err != nil || parsed.Host == "" {
+			return fmt.Errorf("forge %q has invalid url %q", forge.Name, forge.URL)
+		}
+
+		if forge.Name == "" {
+			forge.Name = parsed.Host
+		}
+
+		if _, ok := forgeNames[forge.Name]; ok {
+			return fmt.Errorf("duplicate forge name %q", forge.Name)
+		}
+
+		forgeNames[forge.Name] = struct{}{}
+	}
+
+	// validate webhook tools
+	for i := range e.Tools.Webhooks {
+		webhook := &e.Tools.Webhooks[i]
+
+		if !IsToolName(webhook.Name) {
+			return fmt.Errorf("invalid webhook tool name %q (allowed: a-z, A-Z, 0-9, _ and -)", webhook.Name)
+		}
+
+		if webhook.URL == "" {
+			return fmt.Errorf("webhook tool %q missing url", webhook.Name)
+		}
+
+		webhook.Method = strings.ToUpper(webhook.Method)
+
+		switch webhook.Method {
+		case "":
+			webhook.Method = http.MethodPost
+		case http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch:
+		default:
+			return fmt.Errorf("webhook tool %q has invalid method %q", webhook.Name, webhook.Method)
+		}
+
+		if webhook.Parameters == nil {
+			webhook.Parameters = map[string]any{
+				"type":       "object",
+				"properties": map[string]any{},
+			}
+		}
+
+		// yaml integers have to match the float64 of decoded arguments
+		NormalizeSchemaNumbers(webhook.Parameters)
+
+		// default webhook timeout
+		if webhook.Timeout <= 0 {
+			webhook.Timeout = 30
+		}
+
+		// default webhook response size
+		if webhook.MaxSize <= 0 {
+			webhook.MaxSize = 64 * 1024
+		}
+	}
+
 	// create user lookup map
 	e.Authentication.lookup = make(map[string]*EnvUser)
+	e.Authentication.keys = make(map[string]*EnvUser)
 
 	for _, user := range e.Authentication.Users {
 		if strings.HasPrefix(user.Password, "text=") {
@@ -339,6 +779,28 @@ func (e *Environment) Init() error {
 		}
 
 		e.Authentication.lookup[user.Username] = user
+
+		for i, key := range user.Keys {
+			if strings.HasPrefix(key, "text=") {
+				log.Warnf("User %q has plaintext api key, generating hash\n", user.Username)
+
+				if len(key[5:]) < 16 {
+					return fmt.Errorf("api key %d of user %q is too short (min 16 characters)", i, user.Username)
+				}
+
+				key = HashAPIKey(key[5:])
+
+				user.Keys[i] = key
+
+				store = true
+			}
+
+			if _, ok := e.Authentication.keys[key]; ok {
+				return fmt.Errorf("api key %d of user %q is not unique", i, user.Username)
+			}
+
+			e.Authentication.keys[key] = user
+		}
 	}
 
 	if store {
@@ -364,6 +826,7 @@ func (e *Environment) Store() error {
 			"$.proxies":        {yaml.HeadComment("")},
 			"$.llm":            {yaml.HeadComment("")},
 			"$.models":         {yaml.HeadComment("")},
+			"$.tools":          {yaml.HeadComment("")},
 			"$.ui":             {yaml.HeadComment("")},
 			"$.authentication": {yaml.HeadComment("")},
 
@@ -371,16 +834,23 @@ func (e *Environment) Store() error {
 			"$.tokens.openrouter": {yaml.HeadComment(" openrouter.ai api token (used when llm.api is openrouter)")},
 			"$.tokens.openai":     {yaml.HeadComment(" openai-compatible api token (used when llm.api is openai)")},
 			"$.tokens.tavily":     {yaml.HeadComment(" tavily search api token (optional; used by search tools)")},
+			"$.tokens.brave":      {yaml.HeadComment(" brave search api token (optional; used by search tools)")},
+			"$.tokens.kagi":       {yaml.HeadComment(" kagi search api token (optional; used by search tools)")},
 			"$.tokens.github":     {yaml.HeadComment(" github api token (optional; used by search tools)")},
 
 			"$.server.port": {yaml.HeadComment(" port to serve whiskr on (required; default 3443)")},
 
 			"$.settings.cleanup":          {yaml.HeadComment(" normalize unicode in assistant output (optional; default: true)")},
 			"$.settings.timeout":          {yaml.HeadComment(" the http timeout to use for completion requests in seconds (optional; default: 1200s)")},
-			"$.settings.refresh-interval": {yaml.HeadComment(" the interval in which the model list is refreshed in minutes (optional; default: 30m)")},
+			"$.settings.refresh-interval": {yaml.HeadComment(" the interval in which the model list is refreshed in minutes, failed refreshes are retried sooner (optional; default: 30m)")},
+			"$.settings.job-retention":    {yaml.HeadComment(" how long finished generations are kept for reattaching in minutes (optional; default: 10m)")},
+			"$.settings.max-generations":  {yaml.HeadComment(" maximum generations running at once per user, shared by all clients without authentication (optional; default: 4)")},
+			"$.settings.iterations":       {yaml.HeadComment(" maximum tool iterations of /v1 api requests that don't set iterations (optional; default: 3)")},
+			"$.settings.max-images":       {yaml.HeadComment(" maximum images sent to the model with /v1 api requests (optional; default: 8)")},
 
 			"$.llm.api":      {yaml.HeadComment(" llm api type: openrouter (default) or openai (openai-compatible endpoint)")},
 			"$.llm.base-url": {yaml.HeadComment(" override the api base url (optional; defaults to https://openrouter.ai/api/v1 or https://api.openai.com/v1)")},
+			"$.llm.backends": {yaml.HeadComment(" several llm apis used side by side, replacing api and base-url (optional; each entry needs a name and type (openrouter, openai, openai-responses, ollama, anthropic or gemini); optional: base-url, token (defaults to tokens.openrouter or tokens.openai, required for anthropic and gemini), proxy, keep-alive and num-ctx (ollama only); models of all but the first backend are prefixed with \"name:\")")},
 
 			"$.models.title-model":      {yaml.HeadComment(" model used to generate titles (needs to have structured output support; set to \"-\" to disable title; default: google/gemini-2.5-flash-lite)")},
 			"$.models.image-generation": {yaml.HeadComment(" allow image generation (optional; default: true)")},
@@ -388,10 +858,38 @@ func (e *Environment) Store() error {
 			"$.models.transformation":   {yaml.HeadComment(" what transformation method to use for too long contexts (optional; default: middle-out)")},
 			"$.models.filters":          {yaml.HeadComment(" boolean expression to filter available models (optional; fields: `price`, `slug`, `name`, `tags`, `created`; operators: `<`, `>`, `==`, `!=`, `~` (contains), `^` (starts-with), `$` (ends-with); Logic: `&&`, `||`, `!`, `( )`)")},
 
+			"$.tools.disabled":             {yaml.HeadComment(" names of tools that are never offered to models (optional; e.g. [\"github_repository\"])")},
+			"$.tools.cache.enabled":        {yaml.HeadComment(" cache tool results on disk to save api credits and rate limits (optional; default: true)")},
+			"$.tools.cache.ttl":            {yaml.HeadComment(" how long results of a tool are cached in minutes, 0 disables caching for it (optional; default: search_web: 360, fetch_contents: 1440, github_repository: 60, github_contents: 60, github_search_code: 60, github_issue: 15, forge_repository: 60, forge_contents: 60)")},
+			"$.tools.budget.tool":          {yaml.HeadComment(" maximum tokens of a single tool result, larger results are trimmed (optional; default: 8000; 0 disables)")},
+			"$.tools.budget.request":       {yaml.HeadComment(" maximum tokens of all tool results of a request, capped to half of the model context (optional; default: 32000; 0 disables)")},
+			"$.tools.budget.tools":         {yaml.HeadComment(" per-tool overrides of the single result budget (optional; e.g. fetch_contents: 12000)")},
+			"$.tools.approval.tools":       {yaml.HeadComment(" names of tools whose calls have to be approved, denied or edited by the user before they run (optional; e.g. [\"run_code\"])")},
+			"$.tools.approval.timeout":     {yaml.HeadComment(" how long to wait for an approval in seconds before the call fails (optional; default: 300s)")},
+			"$.tools.search.provider":      {yaml.HeadComment(" web search provider: tavily, searxng, brave or kagi (optional; default: the first one configured)")},
+			"$.tools.search.searxng":       {yaml.HeadComment(" base url of a searxng instance with the json format enabled (optional; e.g. http://localhost:8888)")},
+			"$.tools.forges":               {yaml.HeadComment(" self-hosted or non-github forges for the forge_repository and forge_contents tools (optional; each entry needs a type (gitlab, gitea or forgejo) and a url (e.g. https://gitlab.com); optional: name (default: the host), token)")},
+			"$.tools.mcp":                  {yaml.HeadComment(" model context protocol servers whose tools are offered to models (optional; each entry needs a name and either a command (stdio) or a url (streamable http); optional: args, env, headers, timeout in seconds (default: 60s))")},
+			"$.tools.code.enabled":         {yaml.HeadComment(" offer the run_code tool, executing python, javascript and go snippets in a local sandbox without network (optional; default: false; linux only)")},
+			"$.tools.code.timeout":         {yaml.HeadComment(" wall-clock limit per execution in seconds, also used as cpu time limit (optional; default: 30s)")},
+			"$.tools.code.memory":          {yaml.HeadComment(" memory limit per execution in megabytes, also the size of the scratch directory (optional; default: 512)")},
+			"$.tools.code.processes":       {yaml.HeadComment(" maximum number of processes and threads per execution (optional; default: 256)")},
+			"$.tools.code.max-output":      {yaml.HeadComment(" maximum bytes of stdout and stderr returned to the model (optional; default: 16384)")},
+			"$.tools.code.python":          {yaml.HeadComment(" python interpreter to use (optional; default: python3)")},
+			"$.tools.code.javascript":      {yaml.HeadComment(" javascript runtime to use (optional; default: node)")},
+			"$.tools.code.go":              {yaml.HeadComment(" go toolchain to use (optional; default: go)")},
+			"$.tools.code.mounts":          {yaml.HeadComment(" additional host paths mounted read-only into the sandbox, e.g. a shared library directory (optional)")},
+			"$.tools.documents.directory":  {yaml.HeadComment(" directory of documents indexed for the search_documents tool; relative to config.yml (optional; disabled if empty)")},
+			"$.tools.documents.extensions": {yaml.HeadComment(" file extensions to index (optional; default: .md, .markdown, .txt, .rst, .adoc, .org, .html, .csv)")},
+			"$.tools.documents.chunk-size": {yaml.HeadComment(" number of lines per indexed passage (optional; default: 40)")},
+			"$.tools.documents.interval":   {yaml.HeadComment(" how often the directory is checked for changes in seconds (optional; default: 30s)")},
+			"$.tools.documents.embeddings": {yaml.HeadComment(" embedding model used through an openai-compatible backend to improve ranking, prefix it with the backend name for non-primary backends (optional; e.g. openai/text-embedding-3-small or ollama:nomic-embed-text; bm25 only if empty)")},
+			"$.tools.webhooks":             {yaml.HeadComment(" custom tools calling an http endpoint with the tool arguments (optional; each entry needs a name, description, parameters (json schema) and url; optional: method (default: POST), headers, timeout in seconds (default: 30s), max-size of the response in bytes (default: 65536))")},
+
 			"$.ui.reduced-motion": {yaml.HeadComment(" disables things like the floating stars in the background (optional; default: false)")},
 
 			"$.authentication.enabled": {yaml.HeadComment(" require login with username and password")},
-			"$.authentication.users":   {yaml.HeadComment(" list of users with bcrypt password hashes")},
+			"$.authentication.users":   {yaml.HeadComment(" list of users with bcrypt password hashes (admin users may purge the tool cache; api-keys are hashed keys for the /v1 api, prefix a new key with text= to have it hashed)")},
 		}
 	)
 
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+type FetchRequest struct {
+	URL string `json:"url"`
+}
+
+type FetchedPage struct {
+	URL     string
+	Title   string
+	Content string
+}
+
+const (
+	FetchMaxSize = 5 * 1024 * 1024
+	FetchTimeout = 30 * time.Second
+)
+
+// windows-1252 characters of the bytes 0x80 - 0x9f, the other bytes are
+// the same as in latin-1
+var windows1252 = [32]rune{
+	0x20ac, 0x0081, 0x201a, 0x0192, 0x201e, 0x2026, 0x2020, 0x2021, 0x02c6, 0x2030, 0x0160, 0x2039, 0x0152, 0x008d, 0x017d, 0x008f,
+	0x0090, 0x2018, 0x2019, 0x201c, 0x201d, 0x2022, 0x2013, 0x2014, 0x02dc, 0x2122, 0x0161, 0x203a, 0x0153, 0x009d, 0x017e, 0x0178,
+}
+
+var fetchClient = &http.Client{
+	Timeout: FetchTimeout,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 10 * time.Second,
+			Control: denyPrivateAddress,
+		}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 20 * time.Second,
+		MaxIdleConns:          16,
+		IdleConnTimeout:       60 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 5 {
+			return errors.New("too many redirects")
+		}
+
+		if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+			return fmt.Errorf("unsupported redirect scheme %q", req.URL.Scheme)
+		}
+
+		return nil
+	},
+}
+
+// denyPrivateAddress prevents fetching internal services (the model decides
+// which urls are fetched, so it must not be able to reach the local network).
+func denyPrivateAddress(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("invalid address %q", host)
+	}
+
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() || ip.IsMulticast() {
+		return fmt.Errorf("refusing to fetch local address %s", ip)
+	}
+
+	return nil
+}
+
+func HandleFetch(w http.ResponseWriter, r *http.Request) {
+	debug("parsing fetch")
+
+	var request FetchRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		RespondJson(w, http.StatusBadRequest, map[string]any{
+			"error": err.Error(),
+		})
+
+		return
+	}
+
+	debug("fetching %q", request.URL)
+
+	page, err := FetchURL(r.Context(), request.URL)
+	if err != nil {
+		RespondJson(w, http.StatusBadRequest, map[string]any{
+			"error": err.Error(),
+		})
+
+		return
+	}
+
+	RespondJson(w, http.StatusOK, page.File())
+}
+
+// FetchContentsNative fetches all urls in parallel using the built-in fetcher.
+func FetchContentsNative(ctx context.Context, arguments *FetchContentsArguments) *TavilyResults {
+	var (
+		wg      sync.WaitGroup
+		results = make([]TavilyResult, len(arguments.URLs))
+	)
+
+	for i, link := range arguments.URLs {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			results[i].URL = link
+
+			var page *FetchedPage
+
+			err := RecoverError("fetch", func() (err error) {
+				page, err = FetchURL(ctx, link)
+
+				return err
+			})
+
+			if err != nil {
+				results[i].Text = fmt.Sprintf("error: %v", err)
+
+				return
+			}
+
+			results[i].URL = page.URL
+			results[i].Title = page.Title
+			results[i].Text = page.Content
+		}()
+	}
+
+	wg.Wait()
+
+	return &TavilyResults{
+		Results: results,
+	}
+}
+
+// FetchURL downloads a page and converts it to readable text. Html is reduced
+// to its main content as markdown, json is pretty printed and text is
+// extracted from pdfs.
+func FetchURL(ctx context.Context, link string) (*FetchedPage, error) {
+	parsed, err := url.Parse(strings.TrimSpace(link))
+	if err != nil {
+		return nil, err
+	}
+
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported url scheme %q", parsed.Scheme)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, parsed.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("User-Agent", fmt.Sprintf("Mozilla/5.0 (compatible; whiskr/%s)", Version))
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/json,application/pdf,text/plain;q=0.9,*/*;q=0.5")
+
+	resp, err := fetchClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("server returned status %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, FetchMaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	truncated := len(data) > FetchMaxSize
+
+	if truncated {
+		data = data[:FetchMaxSize]
+	}
+
+	page := &FetchedPage{
+		URL: resp.Request.URL.String(),
+	}
+
+	contentType, params, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+
+	switch {
+	case contentType == "application/pdf" || bytes.HasPrefix(data, []byte("%PDF")):
+		text, err := ExtractPDFText(data)
+		if err != nil {
+			return nil, err
+		}
+
+		page.Content = text
+	case contentType == "text/html" || contentType == "application/xhtml+xml" || (contentType == "" && looksLikeHTML(data)):
+		source, err := decodeCharset(data, params["charset"])
+		if err != nil {
+			return nil, err
+		}
+
+		title, markdown := HTMLToMarkdown(source, resp.Request.URL)
+
+		page.Title = title
+		page.Content = markdown
+	case contentType == "application/json" || strings.HasSuffix(contentType, "+json"):
+		var pretty bytes.Buffer
+
+		if json.Indent(&pretty, data, "", "  ") == nil {
+			page.Content = "```json\n" + pretty.String() + "\n```"
+		} else {
+			page.Content = string(data)
+		}
+	case strings.HasPrefix(contentType, "text/") || contentType == "" || strings.HasSuffix(contentType, "+xml") || contentType == "application/xml" || contentType == "application/javascript":
+		if !utf8.Valid(data) && params["charset"] == "" && bytes.IndexByte(data, 0) != -1 {
+			return nil, errors.New("binary content is not supported")
+		}
+
+		page.Content, err = decodeCharset(data, params["charset"])
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported content type %q", contentType)
+	}
+
+	page.Content = strings.TrimSpace(strings.ToValidUTF8(page.Content, ""))
+
+	if page.Content == "" {
+		return nil, errors.New("page has no readable content")
+	}
+
+	if truncated {
+		page.Content += fmt.Sprintf("\n\n(content truncated, page exceeds %d bytes)", FetchMaxSize)
+	}
+
+	return page, nil
+}
+
+// File converts the page into a text file which can be attached to a message.
+func (p *FetchedPage) File() ChatTextFile {
+	parsed, _ := url.Parse(p.URL)
+
+	name := strings.NewReplacer("/", "_", ":", "_").Replace(strings.Trim(parsed.Host+parsed.Path, "/"))
+
+	switch ext := filepath.Ext(name); ext {
+	case "", ".html", ".htm", ".php", ".asp", ".aspx", ".pdf":
+		name = strings.TrimSuffix(name, ext) + ".md"
+	}
+
+	content := p.Content
+
+	if p.Title != "" {
+		content = fmt.Sprintf("# %s\n\nSource: %s\n\n%s", p.Title, p.URL, content)
+	} else {
+		content = fmt.Sprintf("Source: %s\n\n%s", p.URL, content)
+	}
+
+	return ChatTextFile{
+		Name:    name,
+		Content: content,
+	}
+}
+
+func looksLikeHTML(data []byte) bool {
+	head := bytes.ToLower(bytes.TrimSpace(data[:min(len(data), 512)]))
+
+	return bytes.HasPrefix(head, []byte("<!doctype html")) || bytes.HasPrefix(head, []byte("<html")) || bytes.Contains(head, []byte("<head"))
+}
+
+// decodeCharset converts a document to utf-8. Only utf-8, utf-16 and latin-1
+// (as windows-1252, like browsers do) are supported, other charsets are an
+// error instead of garbled text.
+func decodeCharset(data []byte, charset string) (string, error) {
+	charset = strings.ToLower(strings.TrimSpace(charset))
+
+	if charset == "" && !utf8.Valid(data) {
+		if match := htmlCharsetRgx.FindSubmatch(data[:min(len(data), 2048)]); match != nil {
+			charset = strings.ToLower(string(match[1]))
+		}
+	}
+
+	switch charset {
+	case "":
+		// unlabeled documents that aren't utf-8 are usually windows-1252
+		if !utf8.Valid(data) {
+			return decodeWindows1252(data), nil
+		}
+
+		return string(data), nil
+	case "utf-8", "utf8", "us-ascii", "ascii":
+		return string(data), nil
+	case "iso-8859-1", "latin1", "l1", "windows-1252", "cp1252":
+		return decodeWindows1252(data), nil
+	case "utf-16", "utf-16le", "utf-16be":
+		return decodeUTF16(data, charset != "utf-16le"), nil
+	}
+
+	// ascii only documents are the same in most other charsets
+	if isASCII(data) {
+		return string(data), nil
+	}
+
+	return "", fmt.Errorf("unsupported charset %q", charset)
+}
+
+func decodeWindows1252(data []byte) string {
+	runes := make([]rune, len(data))
+
+	for i, b := range data {
+		if b >= 0x80 && b < 0xa0 {
+			runes[i] = windows1252[b-0x80]
+		} else {
+			runes[i] = rune(b)
+		}
+	}
+
+	return string(runes)
+}
+
+// decodeUTF16 decodes utf-16 text, a byte order mark overrides bigEndian.
+func decodeUTF16(data []byte, bigEndian bool) string {
+	if len(data) >= 2 {
+		switch {
+		case data[0] == 0xfe && data[1] == 0xff:
+			bigEndian = true
+			data = data[2:]
+		case data[0] == 0xff && data[1] == 0xfe:
+			bigEndian = false
+			data = data[2:]
+		}
+	}
+
+	units := make([]uint16, 0, len(data)/2)
+
+	for i := 0; i+1 < len(data); i += 2 {
+		if bigEndian {
+			units = append(units, uint16(data[i])<<8|uint16(data[i+1]))
+		} else {
+			units = append(units, uint16(data[i+1])<<8|uint16(data[i]))
+		}
+	}
+
+	return string(utf16.Decode(units))
+}
+
+func isASCII(data []byte) bool {
+	for _, b := range data {
+		if b >= 0x80 {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestDecodeCharset(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		charset string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "utf-8",
+			data: []byte("caf\xc3\xa9"),
+			want: "café",
+		},
+		{
+			name:    "latin-1",
+			data:    []byte("caf\xe9"),
+			charset: "ISO-8859-1",
+			want:    "café",
+		},
+		{
+			name:    "windows-1252",
+			data:    []byte("\x93quoted\x94 \x80"),
+			charset: "windows-1252",
+			want:    "“quoted” €",
+		},
+		{
+			name: "unlabeled latin-1",
+			data: []byte("caf\xe9"),
+			want: "café",
+		},
+		{
+			name: "meta charset",
+			data: []byte(`<meta charset="windows-1252"><p>caf` + "\xe9"),
+			want: `<meta charset="windows-1252"><p>café`,
+		},
+		{
+			name:    "utf-16 with byte order mark",
+			data:    []byte("\xff\xfec\x00a\x00f\x00\xe9\x00"),
+			charset: "utf-16",
+			want:    "café",
+		},
+		{
+			name:    "utf-16be",
+			data:    []byte("\x00c\x00a\x00f\x00\xe9"),
+			charset: "utf-16be",
+			want:    "café",
+		},
+		{
+			name:    "ascii in another charset",
+			data:    []byte("plain text"),
+			charset: "shift_jis",
+			want:    "plain text",
+		},
+		{
+			name:    "unsupported charset",
+			data:    []byte("\x82\xb1\x82\xf1\x82\xc9\x82\xbf\x82\xcd"),
+			charset: "shift_jis",
+			wantErr: true,
+		},
+		{
+			name:    "unsupported meta charset",
+			data:    []byte(`<meta charset="koi8-r"><p>` + "\xf0\xd2\xc9\xd7\xc5\xd4"),
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			text, err := decodeCharset(test.data, test.charset)
+
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %q", text)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if text != test.want {
+				t.Errorf("got %q, want %q", text, test.want)
+			}
+		})
+	}
+}
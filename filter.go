@@ -20,6 +20,7 @@ type FilterModel struct {
 	Price   float64  `expr:"price"`
 	Tags    []string `expr:"tags"`
 	Created int64    `expr:"created"`
+	Backend string   `expr:"backend"`
 }
 
 func (f *Filters) Match(md *Model) (bool, error) {
@@ -29,6 +30,7 @@ func (f *Filters) Match(md *Model) (bool, error) {
 		Price:   max(md.Pricing.Input, md.Pricing.Output),
 		Tags:    md.Tags,
 		Created: md.Created,
+		Backend: md.GetBackend().Name,
 	})
 
 	if err != nil {
@@ -0,0 +1,597 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/coalaura/openingrouter"
+)
+
+// Forge types
+const (
+	ForgeGitLab  = "gitlab"
+	ForgeGitea   = "gitea"
+	ForgeForgejo = "forgejo"
+)
+
+type ForgeRepositoryArguments struct {
+	Forge string `json:"forge"`
+	Owner string `json:"owner"`
+	Repo  string `json:"repo"`
+}
+
+type ForgeContentsArguments struct {
+	Forge string `json:"forge"`
+
+	GitHubContentsArguments
+}
+
+// Forge is a GitLab or Gitea/Forgejo instance. Responses are converted to
+// the GitHub types, so the output matches the GitHub tools.
+type Forge struct {
+	*EnvForge
+}
+
+type GitLabProject struct {
+	Name          string `json:"name"`
+	WebURL        string `json:"web_url"`
+	Description   string `json:"description"`
+	Stars         int    `json:"star_count"`
+	Forks         int    `json:"forks_count"`
+	Visibility    string `json:"visibility"`
+	DefaultBranch string `json:"default_branch"`
+	ReadmeURL     string `json:"readme_url"`
+}
+
+type GitLabTreeItem struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+	Type string `json:"type"`
+}
+
+type GitLabFile struct {
+	FileName string `json:"file_name"`
+	FilePath string `json:"file_path"`
+	Size     int64  `json:"size"`
+	Encoding string `json:"encoding"`
+	Content  string `json:"content"`
+}
+
+type GiteaRepo struct {
+	Name          string `json:"name"`
+	HtmlURL       string `json:"html_url"`
+	Description   string `json:"description"`
+	Stars         int    `json:"stars_count"`
+	Forks         int    `json:"forks_count"`
+	Private       bool   `json:"private"`
+	DefaultBranch string `json:"default_branch"`
+}
+
+var (
+	//go:embed internal/tools/forge_repository.txt
+	ForgeRepositoryPrompt string
+
+	//go:embed internal/tools/forge_contents.txt
+	ForgeContentsPrompt string
+)
+
+// LoadForgeTools registers the forge tools if any forges are configured.
+func LoadForgeTools() error {
+	if len(env.Tools.Forges) == 0 {
+		return nil
+	}
+
+	names := make([]string, len(env.Tools.Forges))
+	available := make([]string, len(env.Tools.Forges))
+
+	for i, forge := range env.Tools.Forges {
+		names[i] = forge.Name
+		available[i] = fmt.Sprintf("`%s` (%s, %s)", forge.Name, forge.Type, forge.URL)
+	}
+
+	note := "\n- Available forges: " + strings.Join(available, ", ")
+
+	forgeProperty := map[string]any{
+		"type":        "string",
+		"enum":        names,
+		"description": "Name of the forge hosting the repository.",
+	}
+
+	ownerProperty := map[string]any{
+		"type":        "string",
+		"description": "Repository owner, user or group (GitLab groups may be nested, e.g. 'group/subgroup').",
+	}
+
+	repoProperty := map[string]any{
+		"type":        "string",
+		"description": "Repository name.",
+	}
+
+	err := RegisterTool(&FunctionTool[ForgeRepositoryArguments]{
+		Function: openingrouter.ChatFunction{
+			Name:        "forge_repository",
+			Description: "Fetch repository metadata, file structure and README from a configured GitLab, Gitea or Forgejo instance.",
+			Parameters: map[string]any{
+				"type":     "object",
+				"required": []string{"forge", "owner", "repo"},
+				"properties": map[string]any{
+					"forge": forgeProperty,
+					"owner": ownerProperty,
+					"repo":  repoProperty,
+				},
+				"additionalProperties": false,
+			},
+		},
+		Snippet: strings.TrimSpace(ForgeRepositoryPrompt) + note,
+		Handler: HandleForgeRepositoryTool,
+	})
+	if err != nil {
+		return err
+	}
+
+	return RegisterTool(&FunctionTool[ForgeContentsArguments]{
+		Function: openingrouter.ChatFunction{
+			Name:        "forge_contents",
+			Description: "Read a file or list a directory of a repository on a configured GitLab, Gitea or Forgejo instance at a specific branch, tag or commit.",
+			Parameters: map[string]any{
+				"type":     "object",
+				"required": []string{"forge", "owner", "repo", "path"},
+				"properties": map[string]any{
+					"forge": forgeProperty,
+					"owner": ownerProperty,
+					"repo":  repoProperty,
+					"path": map[string]any{
+						"type":        "string",
+						"description": "Path of the file or directory relative to the repository root. Use an empty string for the root directory.",
+					},
+					"ref": map[string]any{
+						"type":        "string",
+						"description": "Branch, tag or commit sha. Defaults to the default branch.",
+					},
+					"start_line": map[string]any{
+						"type":        "integer",
+						"description": "First line of the file to return (1-based).",
+						"minimum":     1,
+					},
+					"end_line": map[string]any{
+						"type":        "integer",
+						"description": "Last line of the file to return (inclusive).",
+						"minimum":     1,
+					},
+				},
+				"additionalProperties": false,
+			},
+		},
+		Snippet: strings.TrimSpace(ForgeContentsPrompt) + note,
+		Handler: HandleForgeContentsTool,
+	})
+}
+
+func GetForge(name string) *Forge {
+	for i := range env.Tools.Forges {
+		if env.Tools.Forges[i].Name == name {
+			return &Forge{
+				EnvForge: &env.Tools.Forges[i],
+			}
+		}
+	}
+
+	return nil
+}
+
+func HandleForgeRepositoryTool(ctx context.Context, tool *ChatToolCall, arguments *ForgeRepositoryArguments) error {
+	forge := GetForge(arguments.Forge)
+	if forge == nil {
+		tool.Result = fmt.Sprintf("error: unknown forge %q", arguments.Forge)
+
+		return nil
+	}
+
+	result, err := forge.Overview(ctx, arguments.Owner, arguments.Repo)
+	if err != nil {
+		tool.Result = fmt.Sprintf("error: %v", err)
+
+		return nil
+	}
+
+	tool.Result = result
+
+	return nil
+}
+
+func HandleForgeContentsTool(ctx context.Context, tool *ChatToolCall, arguments *ForgeContentsArguments) error {
+	forge := GetForge(arguments.Forge)
+	if forge == nil {
+		tool.Result = fmt.Sprintf("error: unknown forge %q", arguments.Forge)
+
+		return nil
+	}
+
+	result, err := forge.Contents(ctx, &arguments.GitHubContentsArguments)
+	if err != nil {
+		tool.Result = fmt.Sprintf("error: %v", err)
+
+		return nil
+	}
+
+	tool.Result = result
+
+	return nil
+}
+
+func (f *Forge) Overview(ctx context.Context, owner, repo string) (string, error) {
+	if f.Type == ForgeGitLab {
+		return f.gitlabOverview(ctx, owner, repo)
+	}
+
+	return f.giteaOverview(ctx, owner, repo)
+}
+
+func (f *Forge) Contents(ctx context.Context, arguments *GitHubContentsArguments) (string, error) {
+	if f.Type == ForgeGitLab {
+		return f.gitlabContents(ctx, arguments)
+	}
+
+	return f.giteaContents(ctx, arguments)
+}
+
+func (f *Forge) getJson(ctx context.Context, path string, out any) error {
+	base := "/api/v1"
+
+	if f.Type == ForgeGitLab {
+		base = "/api/v4"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.URL+base+path, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	if f.Token != "" {
+		if f.Type == ForgeGitLab {
+			req.Header.Set("PRIVATE-TOKEN", f.Token)
+		} else {
+			req.Header.Set("Authorization", "token "+f.Token)
+		}
+	}
+
+	body, status, err := DoConditionalRequest(req)
+	if err != nil {
+		return err
+	}
+
+	if status >= 400 {
+		var response struct {
+			Message string `json:"message"`
+			Error   string `json:"error"`
+		}
+
+		json.Unmarshal(body, &response)
+
+		message := response.Message + response.Error
+		if message == "" {
+			message = http.StatusText(status)
+		}
+
+		return fmt.Errorf("%s api error (%d): %s", f.Type, status, message)
+	}
+
+	return json.Unmarshal(body, out)
+}
+
+func (f *Forge) gitlabProjectPath(owner, repo string) string {
+	return "/projects/" + url.PathEscape(strings.Trim(owner, "/")+"/"+repo)
+}
+
+func (f *Forge) gitlabOverview(ctx context.Context, owner, repo string) (string, error) {
+	project := f.gitlabProjectPath(owner, repo)
+
+	var info GitLabProject
+
+	err := f.getJson(ctx, project, &info)
+	if err != nil {
+		return "", err
+	}
+
+	repository := &GitHubRepo{
+		Name:          info.Name,
+		HtmlURL:       info.WebURL,
+		Description:   info.Description,
+		Stargazers:    info.Stars,
+		Forks:         info.Forks,
+		Visibility:    info.Visibility,
+		DefaultBranch: info.DefaultBranch,
+	}
+
+	if repository.Description == "" {
+		repository.Description = "(none)"
+	}
+
+	var (
+		wg sync.WaitGroup
+
+		readmeMarkdown string
+		files          []string
+		treeTruncated  bool
+	)
+
+	// fetch readme
+	wg.Go(func() {
+		// the readme url points to the web ui, e.g. .../-/blob/main/README.md
+		_, file, ok := strings.Cut(info.ReadmeURL, "/-/blob/"+info.DefaultBranch+"/")
+		if !ok {
+			readmeMarkdown = "*No README found.*"
+
+			return
+		}
+
+		var readme GitLabFile
+
+		err := f.getJson(ctx, fmt.Sprintf("%s/repository/files/%s?ref=%s", project, url.PathEscape(file), url.QueryEscape(info.DefaultBranch)), &readme)
+		if err != nil {
+			log.Warnf("failed to get repository readme: %v\n", err)
+
+			readmeMarkdown = fmt.Sprintf("*Failed to load README: %v*", err)
+
+			return
+		}
+
+		markdown, err := readme.AsContent().Text(ctx)
+		if err != nil {
+			readmeMarkdown = fmt.Sprintf("*Failed to load README: %v*", err)
+
+			return
+		}
+
+		readmeMarkdown = markdown
+	})
+
+	// fetch contents, the tree is paginated with at most 100 entries per page
+	wg.Go(func() {
+		var tree []GitHubTreeItem
+
+		for page := 1; page <= 10; page++ {
+			var items []GitLabTreeItem
+
+			err := f.getJson(ctx, fmt.Sprintf("%s/repository/tree?recursive=true&per_page=100&page=%d&ref=%s", project, page, url.QueryEscape(info.DefaultBranch)), &items)
+			if err != nil {
+				log.Warnf("failed to get repository contents: %v\n", err)
+
+				break
+			}
+
+			for _, item := range items {
+				tree = append(tree, GitHubTreeItem{
+					Path: item.Path,
+					Type: item.Type,
+				})
+			}
+
+			if len(items) < 100 {
+				break
+			}
+
+			if page == 10 {
+				treeTruncated = true
+			}
+		}
+
+		files, treeTruncated = RepoTreeEntries(tree, treeTruncated, func(item GitHubTreeItem) string {
+			if item.Type == "tree" {
+				return fmt.Sprintf("%s/-/tree/%s/%s", info.WebURL, info.DefaultBranch, item.Path)
+			}
+
+			return fmt.Sprintf("%s/-/raw/%s/%s", info.WebURL, info.DefaultBranch, item.Path)
+		})
+	})
+
+	wg.Wait()
+
+	return FormatRepoOverview(repository, files, treeTruncated, readmeMarkdown), nil
+}
+
+func (f *Forge) gitlabContents(ctx context.Context, arguments *GitHubContentsArguments) (string, error) {
+	project := f.gitlabProjectPath(arguments.Owner, arguments.Repo)
+	target := strings.Trim(arguments.Path, "/")
+
+	ref := arguments.Ref
+	if ref == "" {
+		ref = "HEAD"
+	}
+
+	// the tree of a file path is empty, so try listing it as a directory first
+	var items []GitLabTreeItem
+
+	err := f.getJson(ctx, fmt.Sprintf("%s/repository/tree?per_page=100&path=%s&ref=%s", project, url.QueryEscape(target), url.QueryEscape(ref)), &items)
+	if err == nil && len(items) > 0 {
+		entries := make([]GitHubContent, len(items))
+
+		for i, item := range items {
+			entries[i] = GitHubContent{
+				Name: item.Name,
+				Path: item.Path,
+				Type: "file",
+			}
+
+			if item.Type == "tree" {
+				entries[i].Type = "dir"
+			}
+		}
+
+		return FormatGitHubDirectory(arguments, displayRef(arguments.Ref), entries), nil
+	}
+
+	if target == "" {
+		if err != nil {
+			return "", err
+		}
+
+		return "", errors.New("repository is empty")
+	}
+
+	var file GitLabFile
+
+	err = f.getJson(ctx, fmt.Sprintf("%s/repository/files/%s?ref=%s", project, url.PathEscape(target), url.QueryEscape(ref)), &file)
+	if err != nil {
+		return "", err
+	}
+
+	content := file.AsContent()
+
+	text, err := content.Text(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	var info GitLabProject
+
+	if f.getJson(ctx, project, &info) == nil {
+		content.HtmlURL = fmt.Sprintf("%s/-/blob/%s/%s", info.WebURL, ref, file.FilePath)
+	}
+
+	return FormatGitHubFile(arguments, displayRef(arguments.Ref), content, text), nil
+}
+
+func (f *GitLabFile) AsContent() *GitHubContent {
+	return &GitHubContent{
+		Type:     "file",
+		Name:     f.FileName,
+		Path:     f.FilePath,
+		Size:     f.Size,
+		Encoding: f.Encoding,
+		Content:  f.Content,
+	}
+}
+
+func (f *Forge) giteaRepoPath(owner, repo string) string {
+	return fmt.Sprintf("/repos/%s/%s", url.PathEscape(owner), url.PathEscape(repo))
+}
+
+func (f *Forge) giteaOverview(ctx context.Context, owner, repo string) (string, error) {
+	base := f.giteaRepoPath(owner, repo)
+
+	var info GiteaRepo
+
+	err := f.getJson(ctx, base, &info)
+	if err != nil {
+		return "", err
+	}
+
+	repository := &GitHubRepo{
+		Name:          info.Name,
+		HtmlURL:       info.HtmlURL,
+		Description:   info.Description,
+		Stargazers:    info.Stars,
+		Forks:         info.Forks,
+		Visibility:    "public",
+		DefaultBranch: info.DefaultBranch,
+	}
+
+	if info.Private {
+		repository.Visibility = "private"
+	}
+
+	if repository.Description == "" {
+		repository.Description = "(none)"
+	}
+
+	var (
+		wg sync.WaitGroup
+
+		readmeMarkdown string
+		files          []string
+		treeTruncated  bool
+	)
+
+	// fetch readme, gitea has no readme endpoint so look for it in the root directory
+	wg.Go(func() {
+		var root []GitHubContent
+
+		err := f.getJson(ctx, fmt.Sprintf("%s/contents?ref=%s", base, url.QueryEscape(info.DefaultBranch)), &root)
+		if err != nil {
+			log.Warnf("failed to get repository readme: %v\n", err)
+
+			readmeMarkdown = fmt.Sprintf("*Failed to load README: %v*", err)
+
+			return
+		}
+
+		for _, entry := range root {
+			if entry.Type != "file" || !strings.HasPrefix(strings.ToLower(entry.Name), "readme") {
+				continue
+			}
+
+			var readme GitHubContent
+
+			err := f.getJson(ctx, fmt.Sprintf("%s/contents/%s?ref=%s", base, escapeGitHubPath(entry.Path), url.QueryEscape(info.DefaultBranch)), &readme)
+			if err != nil {
+				readmeMarkdown = fmt.Sprintf("*Failed to load README: %v*", err)
+
+				return
+			}
+
+			markdown, err := readme.Text(ctx)
+			if err != nil {
+				readmeMarkdown = fmt.Sprintf("*Failed to load README: %v*", err)
+
+				return
+			}
+
+			readmeMarkdown = markdown
+
+			return
+		}
+
+		readmeMarkdown = "*No README found.*"
+	})
+
+	// fetch contents, the tree api matches github's
+	wg.Go(func() {
+		var tree GitHubTreeResponse
+
+		err := f.getJson(ctx, fmt.Sprintf("%s/git/trees/%s?recursive=true&per_page=1000", base, url.PathEscape(info.DefaultBranch)), &tree)
+		if err != nil {
+			log.Warnf("failed to get repository contents: %v\n", err)
+
+			return
+		}
+
+		files, treeTruncated = RepoTreeEntries(tree.Tree, tree.Truncated, func(item GitHubTreeItem) string {
+			if item.Type == "tree" {
+				return fmt.Sprintf("%s/src/branch/%s/%s", info.HtmlURL, info.DefaultBranch, item.Path)
+			}
+
+			return fmt.Sprintf("%s/raw/branch/%s/%s", info.HtmlURL, info.DefaultBranch, item.Path)
+		})
+	})
+
+	wg.Wait()
+
+	return FormatRepoOverview(repository, files, treeTruncated, readmeMarkdown), nil
+}
+
+func (f *Forge) giteaContents(ctx context.Context, arguments *GitHubContentsArguments) (string, error) {
+	apiPath := fmt.Sprintf("%s/contents/%s", f.giteaRepoPath(arguments.Owner, arguments.Repo), escapeGitHubPath(strings.Trim(arguments.Path, "/")))
+
+	if arguments.Ref != "" {
+		apiPath += "?ref=" + url.QueryEscape(arguments.Ref)
+	}
+
+	var raw json.RawMessage
+
+	err := f.getJson(ctx, apiPath, &raw)
+	if err != nil {
+		return "", err
+	}
+
+	return FormatRepoContents(ctx, arguments, raw)
+}
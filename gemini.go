@@ -0,0 +1,585 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/coalaura/openingrouter"
+)
+
+const (
+	// format of the thought signatures stored with tool calls
+	geminiReasoningFormat = "gemini-thought-signature"
+)
+
+var geminiThinkingBudgets = map[string]int{
+	"minimal": 512,
+	"low":     2048,
+	"medium":  8192,
+	"high":    16384,
+	"xhigh":   24576,
+}
+
+type GeminiModels struct {
+	Models        []GeminiModel `json:"models"`
+	NextPageToken string        `json:"nextPageToken"`
+}
+
+type GeminiModel struct {
+	Name                       string   `json:"name"`
+	DisplayName                string   `json:"displayName"`
+	Description                string   `json:"description"`
+	InputTokenLimit            int      `json:"inputTokenLimit"`
+	OutputTokenLimit           int      `json:"outputTokenLimit"`
+	SupportedGenerationMethods []string `json:"supportedGenerationMethods"`
+	Thinking                   bool     `json:"thinking"`
+}
+
+type GeminiRequest struct {
+	SystemInstruction *GeminiContent         `json:"systemInstruction,omitempty"`
+	Contents          []GeminiContent        `json:"contents"`
+	Tools             []GeminiTool           `json:"tools,omitempty"`
+	GenerationConfig  GeminiGenerationConfig `json:"generationConfig"`
+}
+
+type GeminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []GeminiPart `json:"parts"`
+}
+
+type GeminiPart struct {
+	Text             string                  `json:"text,omitempty"`
+	Thought          bool                    `json:"thought,omitempty"`
+	ThoughtSignature string                  `json:"thoughtSignature,omitempty"`
+	InlineData       *GeminiInlineData       `json:"inlineData,omitempty"`
+	FunctionCall     *GeminiFunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *GeminiFunctionResponse `json:"functionResponse,omitempty"`
+}
+
+type GeminiInlineData struct {
+	MimeType string `json:"mimeType"`
+	Data     string `json:"data"`
+}
+
+type GeminiFunctionCall struct {
+	ID   string          `json:"id,omitempty"`
+	Name string          `json:"name"`
+	Args json.RawMessage `json:"args,omitempty"`
+}
+
+type GeminiFunctionResponse struct {
+	ID       string         `json:"id,omitempty"`
+	Name     string         `json:"name"`
+	Response map[string]any `json:"response"`
+}
+
+type GeminiTool struct {
+	FunctionDeclarations []GeminiFunctionDeclaration `json:"functionDeclarations"`
+}
+
+type GeminiFunctionDeclaration struct {
+	Name                 string `json:"name"`
+	Description          string `json:"description,omitempty"`
+	ParametersJSONSchema any    `json:"parametersJsonSchema,omitempty"`
+}
+
+type GeminiGenerationConfig struct {
+	Temperature        *float64              `json:"temperature,omitempty"`
+	MaxOutputTokens    *int                  `json:"maxOutputTokens,omitempty"`
+	ResponseMimeType   string                `json:"responseMimeType,omitempty"`
+	ResponseJSONSchema any                   `json:"responseJsonSchema,omitempty"`
+	ResponseModalities []string              `json:"responseModalities,omitempty"`
+	ImageConfig        map[string]any        `json:"imageConfig,omitempty"`
+	ThinkingConfig     *GeminiThinkingConfig `json:"thinkingConfig,omitempty"`
+}
+
+type GeminiThinkingConfig struct {
+	ThinkingBudget  *int `json:"thinkingBudget,omitempty"`
+	IncludeThoughts bool `json:"includeThoughts,omitempty"`
+}
+
+type GeminiResponse struct {
+	Candidates     []GeminiCandidate     `json:"candidates"`
+	PromptFeedback *GeminiPromptFeedback `json:"promptFeedback"`
+	UsageMetadata  *GeminiUsage          `json:"usageMetadata"`
+	ModelVersion   string                `json:"modelVersion"`
+	ResponseID     string                `json:"responseId"`
+}
+
+type GeminiCandidate struct {
+	Content      GeminiContent `json:"content"`
+	FinishReason string        `json:"finishReason"`
+}
+
+type GeminiPromptFeedback struct {
+	BlockReason string `json:"blockReason"`
+}
+
+type GeminiUsage struct {
+	PromptTokenCount        int `json:"promptTokenCount"`
+	CandidatesTokenCount    int `json:"candidatesTokenCount"`
+	ThoughtsTokenCount      int `json:"thoughtsTokenCount"`
+	CachedContentTokenCount int `json:"cachedContentTokenCount"`
+}
+
+// GeminiStream maps the responses of streamGenerateContent to completion
+// deltas, one per part.
+type GeminiStream struct {
+	body io.ReadCloser
+	sse  *SSEReader
+
+	pending []*CompletionDelta
+	calls   int
+}
+
+// LoadGeminiModels returns the models of a gemini backend that can generate
+// content.
+func LoadGeminiModels(ctx context.Context, backend *EnvBackend) ([]*Model, []*Model, error) {
+	var (
+		models []*Model
+		token  string
+	)
+
+	for {
+		query := url.Values{}
+
+		query.Set("pageSize", "1000")
+
+		if token != "" {
+			query.Set("pageToken", token)
+		}
+
+		var page GeminiModels
+
+		_, err := BackendRequest(ctx, backend, nil, "GET", "/models?"+query.Encode(), nil, &page)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, model := range page.Models {
+			if !slices.Contains(model.SupportedGenerationMethods, "generateContent") {
+				continue
+			}
+
+			slug := strings.TrimPrefix(model.Name, "models/")
+
+			// speech models only output audio
+			if strings.Contains(slug, "-tts") {
+				continue
+			}
+
+			m := &Model{
+				ID:          GetModelShortID(slug),
+				Slug:        slug,
+				Name:        model.DisplayName,
+				Description: model.Description,
+				Author:      "google",
+
+				Context: ModelContext{
+					Total:      model.InputTokenLimit,
+					Completion: model.OutputTokenLimit,
+				},
+			}
+
+			SetGeminiTags(model, m)
+
+			models = append(models, m)
+		}
+
+		if page.NextPageToken == "" {
+			break
+		}
+
+		token = page.NextPageToken
+	}
+
+	sort.Slice(models, func(i, j int) bool {
+		return models[i].Slug < models[j].Slug
+	})
+
+	return models, nil, nil
+}
+
+// SetGeminiTags derives the capabilities of a model, the api only reports
+// whether it thinks.
+func SetGeminiTags(model GeminiModel, m *Model) {
+	slug := strings.TrimPrefix(model.Name, "models/")
+
+	m.Text = true
+	m.Vision = true
+	m.JSON = true
+
+	m.Tags = append(m.Tags, "vision", "json")
+
+	if model.Thinking {
+		m.Reasoning = true
+
+		m.Tags = append(m.Tags, "reasoning")
+	}
+
+	if strings.Contains(slug, "image") {
+		m.Images = true
+
+		m.Tags = append(m.Tags, "image_gen")
+	} else if strings.HasPrefix(slug, "gemini-") {
+		// gemma and the image models can not call functions
+		m.Tools = true
+
+		m.Tags = append(m.Tags, "tools")
+	}
+
+	sort.Strings(m.Tags)
+}
+
+func NewGeminiRequest(ctx context.Context, request *openingrouter.ChatCompletionRequest) (*GeminiRequest, error) {
+	gemini := &GeminiRequest{}
+
+	add := func(role string, parts []GeminiPart) {
+		if len(parts) == 0 {
+			return
+		}
+
+		if last := len(gemini.Contents) - 1; last >= 0 && gemini.Contents[last].Role == role {
+			gemini.Contents[last].Parts = append(gemini.Contents[last].Parts, parts...)
+
+			return
+		}
+
+		gemini.Contents = append(gemini.Contents, GeminiContent{
+			Role:  role,
+			Parts: parts,
+		})
+	}
+
+	// function responses reference the call by name
+	names := make(map[string]string)
+
+	for _, message := range request.Messages {
+		switch message.Role {
+		case openingrouter.ChatRoleSystem:
+			text := message.Content.String()
+			if text == "" {
+				continue
+			}
+
+			// only leading system messages are system instructions
+			if len(gemini.Contents) == 0 {
+				if gemini.SystemInstruction == nil {
+					gemini.SystemInstruction = &GeminiContent{}
+				}
+
+				gemini.SystemInstruction.Parts = append(gemini.SystemInstruction.Parts, GeminiPart{
+					Text: text,
+				})
+			} else {
+				add("user", []GeminiPart{{
+					Text: text,
+				}})
+			}
+		case openingrouter.ChatRoleUser:
+			parts, err := geminiContent(ctx, message.Content)
+			if err != nil {
+				return nil, err
+			}
+
+			add("user", parts)
+		case openingrouter.ChatRoleAssistant:
+			var parts []GeminiPart
+
+			if text := message.Content.String(); strings.TrimSpace(text) != "" {
+				parts = append(parts, GeminiPart{
+					Text: text,
+				})
+			}
+
+			signatures := make(map[string]string)
+
+			for _, details := range message.ReasoningDetails {
+				if details.Type == openingrouter.ChatReasoningDetailTypeEncrypted && string(details.Format) == geminiReasoningFormat {
+					signatures[details.ID] = details.Data
+				}
+			}
+
+			for _, call := range message.ToolCalls {
+				args := json.RawMessage(call.Function.Arguments)
+
+				if !json.Valid(args) {
+					args = json.RawMessage("{}")
+				}
+
+				parts = append(parts, GeminiPart{
+					ThoughtSignature: signatures[call.ID],
+					FunctionCall: &GeminiFunctionCall{
+						Name: call.Function.Name,
+						Args: args,
+					},
+				})
+
+				names[call.ID] = call.Function.Name
+			}
+
+			add("model", parts)
+		case openingrouter.ChatRoleTool:
+			add("user", []GeminiPart{{
+				FunctionResponse: &GeminiFunctionResponse{
+					Name: names[message.ToolCallID],
+					Response: map[string]any{
+						"content": message.Content.String(),
+					},
+				},
+			}})
+		}
+	}
+
+	if functions := RequestFunctions(request); len(functions) > 0 {
+		tool := GeminiTool{}
+
+		for _, function := range functions {
+			tool.FunctionDeclarations = append(tool.FunctionDeclarations, GeminiFunctionDeclaration{
+				Name:                 function.Name,
+				Description:          function.Description,
+				ParametersJSONSchema: function.Parameters,
+			})
+		}
+
+		gemini.Tools = []GeminiTool{tool}
+	}
+
+	config := &gemini.GenerationConfig
+
+	config.Temperature = request.Temperature
+	config.MaxOutputTokens = request.MaxTokens
+
+	if format := request.ResponseFormat; format != nil {
+		config.ResponseMimeType = "application/json"
+
+		if format.JSONSchema != nil {
+			config.ResponseJSONSchema = format.JSONSchema.Schema
+		}
+	}
+
+	if slices.Contains(request.Modalities, openingrouter.OutputModalityImage) {
+		config.ResponseModalities = []string{"TEXT", "IMAGE"}
+
+		config.ImageConfig = make(map[string]any)
+
+		if aspect, ok := request.ImageConfig["aspect_ratio"]; ok {
+			config.ImageConfig["aspectRatio"] = aspect
+		}
+
+		if size, ok := request.ImageConfig["image_size"]; ok {
+			config.ImageConfig["imageSize"] = size
+		}
+	}
+
+	if request.Reasoning != nil {
+		config.ThinkingConfig = &GeminiThinkingConfig{
+			IncludeThoughts: true,
+		}
+
+		effort := string(request.Reasoning.Effort)
+
+		if budget, ok := geminiThinkingBudgets[effort]; ok {
+			config.ThinkingConfig.ThinkingBudget = &budget
+		} else if effort == "none" {
+			// pro models can not stop thinking entirely
+			budget := 0
+
+			if strings.Contains(request.Model, "-pro") {
+				budget = 128
+			}
+
+			config.ThinkingConfig.ThinkingBudget = &budget
+			config.ThinkingConfig.IncludeThoughts = false
+		}
+	}
+
+	return gemini, nil
+}
+
+func geminiContent(ctx context.Context, content openingrouter.ChatContent) ([]GeminiPart, error) {
+	if len(content.Parts) == 0 {
+		if content.Text == "" {
+			return nil, nil
+		}
+
+		return []GeminiPart{{
+			Text: content.Text,
+		}}, nil
+	}
+
+	parts := make([]GeminiPart, 0, len(content.Parts))
+
+	for _, part := range content.Parts {
+		if part.ImageURL == nil {
+			if part.Text != "" {
+				parts = append(parts, GeminiPart{
+					Text: part.Text,
+				})
+			}
+
+			continue
+		}
+
+		mime, data, err := LoadImage(ctx, part.ImageURL.URL)
+		if err != nil {
+			return nil, err
+		}
+
+		parts = append(parts, GeminiPart{
+			InlineData: &GeminiInlineData{
+				MimeType: mime,
+				Data:     base64.StdEncoding.EncodeToString(data),
+			},
+		})
+	}
+
+	return parts, nil
+}
+
+func GeminiStartStream(ctx context.Context, request *openingrouter.ChatCompletionRequest, backend *EnvBackend, proxy *EnvProxy) (CompletionStream, error) {
+	gemini, err := NewGeminiRequest(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	path := fmt.Sprintf("/models/%s:streamGenerateContent?alt=sse", url.PathEscape(request.Model))
+
+	resp, err := BackendRequest(ctx, backend, proxy, "POST", path, gemini, nil)
+	if err != nil {
+		log.Warnln(err)
+
+		return nil, err
+	}
+
+	return &GeminiStream{
+		body: resp.Body,
+		sse:  NewSSEReader(resp.Body),
+	}, nil
+}
+
+func (s *GeminiStream) Recv() (*CompletionDelta, error) {
+	for len(s.pending) == 0 {
+		_, data, err := s.sse.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var response GeminiResponse
+
+		if err := json.Unmarshal(data, &response); err != nil {
+			return nil, err
+		}
+
+		s.pending = s.deltas(&response)
+	}
+
+	delta := s.pending[0]
+
+	s.pending = s.pending[1:]
+
+	return delta, nil
+}
+
+func (s *GeminiStream) deltas(response *GeminiResponse) []*CompletionDelta {
+	var deltas []*CompletionDelta
+
+	if len(response.Candidates) > 0 {
+		candidate := response.Candidates[0]
+
+		for _, part := range candidate.Content.Parts {
+			delta := &CompletionDelta{}
+
+			switch {
+			case part.FunctionCall != nil:
+				args := string(part.FunctionCall.Args)
+				if args == "" {
+					args = "{}"
+				}
+
+				delta.ToolCalls = []CompletionToolCall{{
+					Index: s.calls,
+					ID:    part.FunctionCall.ID,
+					Name:  part.FunctionCall.Name,
+					Args:  args,
+				}}
+
+				s.calls++
+
+				if part.ThoughtSignature != "" {
+					delta.Encrypted = []ChatToolReasoning{{
+						Format:    geminiReasoningFormat,
+						Encrypted: part.ThoughtSignature,
+					}}
+				}
+			case part.Thought:
+				delta.Reasoning = part.Text
+				delta.ReasoningType = ReasoningTypeText
+			case part.InlineData != nil:
+				if !strings.HasPrefix(part.InlineData.MimeType, "image/") {
+					continue
+				}
+
+				delta.Images = []string{fmt.Sprintf("data:%s;base64,%s", part.InlineData.MimeType, part.InlineData.Data)}
+			default:
+				delta.Content = part.Text
+			}
+
+			deltas = append(deltas, delta)
+		}
+
+		if candidate.FinishReason != "" {
+			deltas = append(deltas, &CompletionDelta{
+				Finish: geminiFinishReason(candidate.FinishReason),
+				Native: candidate.FinishReason,
+			})
+		}
+	} else if response.PromptFeedback != nil && response.PromptFeedback.BlockReason != "" {
+		deltas = append(deltas, &CompletionDelta{
+			Finish: openingrouter.ChatFinishReasonContentFilter,
+			Native: response.PromptFeedback.BlockReason,
+		})
+	}
+
+	// every response carries the usage so far
+	if usage := response.UsageMetadata; usage != nil {
+		debug("usage chunk: model=%q provider=%q prompt=%d completion=%d reasoning=%d", response.ModelVersion, APIGemini, usage.PromptTokenCount, usage.CandidatesTokenCount, usage.ThoughtsTokenCount)
+
+		deltas = append(deltas, &CompletionDelta{
+			Usage: &Statistics{
+				Provider:        APIGemini,
+				Model:           response.ModelVersion,
+				InputTokens:     usage.PromptTokenCount,
+				OutputTokens:    usage.CandidatesTokenCount + usage.ThoughtsTokenCount,
+				ReasoningTokens: usage.ThoughtsTokenCount,
+				CachedTokens:    usage.CachedContentTokenCount,
+			},
+		})
+	}
+
+	if len(deltas) > 0 && response.ResponseID != "" {
+		deltas[0].ID = response.ResponseID
+	}
+
+	return deltas
+}
+
+func (s *GeminiStream) Close() error {
+	return s.body.Close()
+}
+
+func geminiFinishReason(reason string) openingrouter.ChatFinishReason {
+	if reason == "MAX_TOKENS" {
+		return openingrouter.ChatFinishReasonLength
+	}
+
+	// the other reasons are resolved through their native name
+	return "stop"
+}
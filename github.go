@@ -1,15 +1,24 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	_ "embed"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"maps"
 	"net/http"
+	"net/url"
+	"path/filepath"
 	"sort"
 	"strings"
 	"sync"
+	"unicode/utf8"
+
+	"github.com/coalaura/openingrouter"
 )
 
 type GitHubRepo struct {
@@ -38,11 +47,223 @@ type GitHubReadme struct {
 	Encoding string `json:"encoding"`
 }
 
+type GitHubContentsArguments struct {
+	Owner     string `json:"owner"`
+	Repo      string `json:"repo"`
+	Path      string `json:"path"`
+	Ref       string `json:"ref,omitempty"`
+	StartLine int    `json:"start_line,omitempty"`
+	EndLine   int    `json:"end_line,omitempty"`
+}
+
+type GitHubSearchCodeArguments struct {
+	Owner      string `json:"owner"`
+	Repo       string `json:"repo"`
+	Query      string `json:"query"`
+	MaxResults int    `json:"max_results,omitempty"`
+}
+
+type GitHubIssueArguments struct {
+	Owner  string `json:"owner"`
+	Repo   string `json:"repo"`
+	Number int    `json:"number"`
+}
+
+type GitHubContent struct {
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	Path        string `json:"path"`
+	Size        int64  `json:"size"`
+	Encoding    string `json:"encoding"`
+	Content     string `json:"content"`
+	HtmlURL     string `json:"html_url"`
+	DownloadURL string `json:"download_url"`
+}
+
+type GitHubSearchCodeResponse struct {
+	TotalCount int `json:"total_count"`
+	Items      []struct {
+		Path        string `json:"path"`
+		HtmlURL     string `json:"html_url"`
+		TextMatches []struct {
+			Fragment string `json:"fragment"`
+		} `json:"text_matches"`
+	} `json:"items"`
+}
+
+type GitHubUser struct {
+	Login string `json:"login"`
+}
+
+type GitHubIssue struct {
+	Number    int        `json:"number"`
+	Title     string     `json:"title"`
+	State     string     `json:"state"`
+	Body      string     `json:"body"`
+	HtmlURL   string     `json:"html_url"`
+	User      GitHubUser `json:"user"`
+	CreatedAt string     `json:"created_at"`
+	Comments  int        `json:"comments"`
+	Labels    []struct {
+		Name string `json:"name"`
+	} `json:"labels"`
+	PullRequest *struct {
+		URL string `json:"url"`
+	} `json:"pull_request"`
+}
+
+type GitHubPullRequest struct {
+	Draft        bool   `json:"draft"`
+	Merged       bool   `json:"merged"`
+	Additions    int    `json:"additions"`
+	Deletions    int    `json:"deletions"`
+	ChangedFiles int    `json:"changed_files"`
+	Head         GitRef `json:"head"`
+	Base         GitRef `json:"base"`
+}
+
+type GitRef struct {
+	Ref string `json:"ref"`
+}
+
+type GitHubComment struct {
+	User      GitHubUser `json:"user"`
+	Body      string     `json:"body"`
+	CreatedAt string     `json:"created_at"`
+	Path      string     `json:"path"`
+	Line      int        `json:"line"`
+}
+
+const (
+	GitHubMaxFileSize    = 48 * 1024
+	GitHubMaxDiffSize    = 64 * 1024
+	GitHubMaxComments    = 30
+	GitHubMaxCommentSize = 4 * 1024
+	GitHubMaxEntries     = 500
+)
+
+var (
+	//go:embed internal/tools/github_contents.txt
+	GitHubContentsPrompt string
+
+	//go:embed internal/tools/github_search_code.txt
+	GitHubSearchCodePrompt string
+
+	//go:embed internal/tools/github_issue.txt
+	GitHubIssuePrompt string
+)
+
 var IgnoreGithubPaths = []string{
 	"node_modules/", "vendor/", ".git/", "dist/", "build/",
 	"bin/", "obj/", "out/", ".idea/", ".vscode/", "__pycache__/",
 }
 
+func init() {
+	ownerRepo := map[string]any{
+		"owner": map[string]any{
+			"type":        "string",
+			"description": "Repository owner (e.g., 'torvalds').",
+		},
+		"repo": map[string]any{
+			"type":        "string",
+			"description": "Repository name (e.g., 'linux').",
+		},
+	}
+
+	properties := func(extra map[string]any) map[string]any {
+		merged := maps.Clone(ownerRepo)
+
+		maps.Copy(merged, extra)
+
+		return merged
+	}
+
+	MustRegisterTool(&FunctionTool[GitHubContentsArguments]{
+		Function: openingrouter.ChatFunction{
+			Name:        "github_contents",
+			Description: "Read a file or list a directory of a GitHub repository at a specific branch, tag or commit.",
+			Parameters: map[string]any{
+				"type":     "object",
+				"required": []string{"owner", "repo", "path"},
+				"properties": properties(map[string]any{
+					"path": map[string]any{
+						"type":        "string",
+						"description": "Path of the file or directory relative to the repository root. Use an empty string for the root directory.",
+					},
+					"ref": map[string]any{
+						"type":        "string",
+						"description": "Branch, tag or commit sha. Defaults to the default branch.",
+					},
+					"start_line": map[string]any{
+						"type":        "integer",
+						"description": "First line of the file to return (1-based).",
+						"minimum":     1,
+					},
+					"end_line": map[string]any{
+						"type":        "integer",
+						"description": "Last line of the file to return (inclusive).",
+						"minimum":     1,
+					},
+				}),
+				"additionalProperties": false,
+			},
+		},
+		Snippet: GitHubContentsPrompt,
+		Handler: HandleGitHubContentsTool,
+	})
+
+	MustRegisterTool(&FunctionTool[GitHubSearchCodeArguments]{
+		Function: openingrouter.ChatFunction{
+			Name:        "github_search_code",
+			Description: "Search the code of a GitHub repository (default branch only) and return matching files with code fragments.",
+			Parameters: map[string]any{
+				"type":     "object",
+				"required": []string{"owner", "repo", "query"},
+				"properties": properties(map[string]any{
+					"query": map[string]any{
+						"type":        "string",
+						"description": "Search terms, optionally with qualifiers like 'language:go', 'path:src/' or 'extension:md'.",
+					},
+					"max_results": map[string]any{
+						"type":        "integer",
+						"description": "Number of files to return (1-20). Default is 10.",
+						"minimum":     1,
+						"maximum":     20,
+					},
+				}),
+				"additionalProperties": false,
+			},
+		},
+		Snippet: GitHubSearchCodePrompt,
+		Enable: func(_ *ChatRequest, _ *Model) bool {
+			// code search requires authentication
+			return env.Tokens.GitHub != ""
+		},
+		Handler: HandleGitHubSearchCodeTool,
+	})
+
+	MustRegisterTool(&FunctionTool[GitHubIssueArguments]{
+		Function: openingrouter.ChatFunction{
+			Name:        "github_issue",
+			Description: "Fetch a GitHub issue or pull request with its description and comments. Pull requests also include review comments and the diff.",
+			Parameters: map[string]any{
+				"type":     "object",
+				"required": []string{"owner", "repo", "number"},
+				"properties": properties(map[string]any{
+					"number": map[string]any{
+						"type":        "integer",
+						"description": "The issue or pull request number.",
+						"minimum":     1,
+					},
+				}),
+				"additionalProperties": false,
+			},
+		},
+		Snippet: GitHubIssuePrompt,
+		Handler: HandleGitHubIssueTool,
+	})
+}
+
 func (r *GitHubReadme) AsText() (string, error) {
 	if r.Encoding == "base64" {
 		content, err := base64.StdEncoding.DecodeString(r.Content)
@@ -73,76 +294,118 @@ func NewGitHubRequest(ctx context.Context, path string) (*http.Request, error) {
 	return req, nil
 }
 
-func GitHubRepositoryJson(ctx context.Context, owner, repo string) (*GitHubRepo, error) {
-	req, err := NewGitHubRequest(ctx, fmt.Sprintf("/repos/%s/%s", owner, repo))
+// GitHubGetJson performs a conditional GET request against the GitHub api,
+// revalidating cached responses with their ETag. Not modified responses do
+// not count against the rate limit.
+func GitHubGetJson(ctx context.Context, path string, out any) error {
+	return GitHubGetJsonAs(ctx, path, "", out)
+}
+
+// GitHubGetJsonAs is GitHubGetJson with a custom media type (e.g. for text
+// matches in search results).
+func GitHubGetJsonAs(ctx context.Context, path, accept string, out any) error {
+	req, err := NewGitHubRequest(ctx, path)
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	if accept != "" {
+		req.Header.Set("Accept", accept)
 	}
 
+	body, status, err := DoConditionalRequest(req)
+	if err != nil {
+		return err
+	}
+
+	if status >= 400 {
+		return GitHubError(status, body)
+	}
+
+	return json.Unmarshal(body, out)
+}
+
+// GitHubGetText requests a raw representation (e.g. a diff) of a resource,
+// reading at most limit bytes.
+func GitHubGetText(ctx context.Context, path, accept string, limit int) (string, bool, error) {
+	req, err := NewGitHubRequest(ctx, path)
+	if err != nil {
+		return "", false, err
+	}
+
+	req.Header.Set("Accept", accept)
+
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return nil, err
+		return "", false, err
 	}
 
 	defer resp.Body.Close()
 
-	var response GitHubRepo
-
-	err = json.NewDecoder(resp.Body).Decode(&response)
+	body, err := io.ReadAll(io.LimitReader(resp.Body, int64(limit)+1))
 	if err != nil {
-		return nil, err
+		return "", false, err
 	}
 
-	if response.Name == "" {
-		return nil, errors.New("error getting data")
+	if resp.StatusCode >= 400 {
+		return "", false, GitHubError(resp.StatusCode, body)
 	}
 
-	if response.Description == "" {
-		response.Description = "(none)"
+	truncated := len(body) > limit
+
+	if truncated {
+		body = body[:limit]
 	}
 
-	return &response, nil
+	return strings.ToValidUTF8(string(body), ""), truncated, nil
 }
 
-func GitHubRepositoryReadmeJson(ctx context.Context, owner, repo, branch string) (*GitHubReadme, error) {
-	req, err := NewGitHubRequest(ctx, fmt.Sprintf("/repos/%s/%s/readme?ref=%s", owner, repo, branch))
-	if err != nil {
-		return nil, err
+func GitHubError(status int, body []byte) error {
+	var response struct {
+		Message string `json:"message"`
 	}
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, err
+	if json.Unmarshal(body, &response) != nil || response.Message == "" {
+		response.Message = http.StatusText(status)
 	}
 
-	defer resp.Body.Close()
+	return fmt.Errorf("github api error (%d): %s", status, response.Message)
+}
 
-	var response GitHubReadme
+func GitHubRepositoryJson(ctx context.Context, owner, repo string) (*GitHubRepo, error) {
+	var response GitHubRepo
 
-	err = json.NewDecoder(resp.Body).Decode(&response)
+	err := GitHubGetJson(ctx, fmt.Sprintf("/repos/%s/%s", owner, repo), &response)
 	if err != nil {
 		return nil, err
 	}
 
+	if response.Name == "" {
+		return nil, errors.New("error getting data")
+	}
+
+	if response.Description == "" {
+		response.Description = "(none)"
+	}
+
 	return &response, nil
 }
 
-func GitHubRepositoryTreeJson(ctx context.Context, owner, repo, branch string) (*GitHubTreeResponse, error) {
-	req, err := NewGitHubRequest(ctx, fmt.Sprintf("/repos/%s/%s/git/trees/%s?recursive=1", owner, repo, branch))
-	if err != nil {
-		return nil, err
-	}
+func GitHubRepositoryReadmeJson(ctx context.Context, owner, repo, branch string) (*GitHubReadme, error) {
+	var response GitHubReadme
 
-	resp, err := http.DefaultClient.Do(req)
+	err := GitHubGetJson(ctx, fmt.Sprintf("/repos/%s/%s/readme?ref=%s", owner, repo, branch), &response)
 	if err != nil {
 		return nil, err
 	}
 
-	defer resp.Body.Close()
+	return &response, nil
+}
 
+func GitHubRepositoryTreeJson(ctx context.Context, owner, repo, branch string) (*GitHubTreeResponse, error) {
 	var response GitHubTreeResponse
 
-	err = json.NewDecoder(resp.Body).Decode(&response)
+	err := GitHubGetJson(ctx, fmt.Sprintf("/repos/%s/%s/git/trees/%s?recursive=1", owner, repo, branch), &response)
 	if err != nil {
 		return nil, err
 	}
@@ -196,51 +459,64 @@ func RepoOverview(ctx context.Context, arguments *GitHubRepositoryArguments) (st
 			return
 		}
 
-		var validItems []GitHubTreeItem
-
-		for _, item := range tree.Tree {
-			if !shouldIgnoreGithubFile(item.Path) {
-				validItems = append(validItems, item)
+		files, treeTruncated = RepoTreeEntries(tree.Tree, tree.Truncated, func(item GitHubTreeItem) string {
+			if item.Type == "tree" {
+				return fmt.Sprintf("https://github.com/%s/%s/tree/%s/%s", arguments.Owner, arguments.Repo, repository.DefaultBranch, item.Path)
 			}
-		}
 
-		sort.Slice(validItems, func(i, j int) bool {
-			depthI := strings.Count(validItems[i].Path, "/")
-			depthJ := strings.Count(validItems[j].Path, "/")
+			return fmt.Sprintf("https://raw.githubusercontent.com/%s/%s/refs/heads/%s/%s", arguments.Owner, arguments.Repo, repository.DefaultBranch, item.Path)
+		})
+	})
 
-			if depthI == depthJ {
-				return validItems[i].Path < validItems[j].Path
-			}
+	// wait and combine results
+	wg.Wait()
 
-			return depthI < depthJ
-		})
+	return FormatRepoOverview(repository, files, treeTruncated, readmeMarkdown), nil
+}
 
-		if len(validItems) > 256 {
-			validItems = validItems[:256]
+// RepoTreeEntries filters and sorts a repository tree (shallow entries first)
+// and formats up to 256 entries as markdown list items.
+func RepoTreeEntries(tree []GitHubTreeItem, truncated bool, link func(item GitHubTreeItem) string) ([]string, bool) {
+	var validItems []GitHubTreeItem
 
-			treeTruncated = true
-		} else if tree.Truncated {
-			treeTruncated = true
+	for _, item := range tree {
+		if !shouldIgnoreGithubFile(item.Path) {
+			validItems = append(validItems, item)
 		}
+	}
 
-		for _, item := range validItems {
-			if item.Type == "tree" {
-				files = append(files, fmt.Sprintf(
-					"- [D] [%s](https://github.com/%s/%s/tree/%s/%s)",
-					item.Path, arguments.Owner, arguments.Repo, repository.DefaultBranch, item.Path,
-				))
-			} else { // "blob"
-				files = append(files, fmt.Sprintf(
-					"- [F] [%s](https://raw.githubusercontent.com/%s/%s/refs/heads/%s/%s)",
-					item.Path, arguments.Owner, arguments.Repo, repository.DefaultBranch, item.Path,
-				))
-			}
+	sort.Slice(validItems, func(i, j int) bool {
+		depthI := strings.Count(validItems[i].Path, "/")
+		depthJ := strings.Count(validItems[j].Path, "/")
+
+		if depthI == depthJ {
+			return validItems[i].Path < validItems[j].Path
 		}
+
+		return depthI < depthJ
 	})
 
-	// wait and combine results
-	wg.Wait()
+	if len(validItems) > 256 {
+		validItems = validItems[:256]
+
+		truncated = true
+	}
+
+	files := make([]string, 0, len(validItems))
+
+	for _, item := range validItems {
+		if item.Type == "tree" {
+			files = append(files, fmt.Sprintf("- [D] [%s](%s)", item.Path, link(item)))
+		} else { // "blob"
+			files = append(files, fmt.Sprintf("- [F] [%s](%s)", item.Path, link(item)))
+		}
+	}
+
+	return files, truncated
+}
 
+// FormatRepoOverview renders the markdown overview shared by all forges.
+func FormatRepoOverview(repository *GitHubRepo, files []string, treeTruncated bool, readmeMarkdown string) string {
 	buf := GetFreeBuffer()
 	defer pool.Put(buf)
 
@@ -267,7 +543,7 @@ func RepoOverview(ctx context.Context, arguments *GitHubRepositoryArguments) (st
 	buf.WriteString("\n### README\n")
 	buf.WriteString(readmeMarkdown)
 
-	return buf.String(), nil
+	return buf.String()
 }
 
 func shouldIgnoreGithubFile(path string) bool {
@@ -279,3 +555,469 @@ func shouldIgnoreGithubFile(path string) bool {
 
 	return false
 }
+
+func HandleGitHubContentsTool(ctx context.Context, tool *ChatToolCall, arguments *GitHubContentsArguments) error {
+	result, err := RepoContents(ctx, arguments)
+	if err != nil {
+		tool.Result = fmt.Sprintf("error: %v", err)
+
+		return nil
+	}
+
+	tool.Result = result
+
+	return nil
+}
+
+func HandleGitHubSearchCodeTool(ctx context.Context, tool *ChatToolCall, arguments *GitHubSearchCodeArguments) error {
+	if strings.TrimSpace(arguments.Query) == "" {
+		return errors.New("no query")
+	}
+
+	result, err := RepoSearchCode(ctx, arguments)
+	if err != nil {
+		tool.Result = fmt.Sprintf("error: %v", err)
+
+		return nil
+	}
+
+	tool.Result = result
+
+	return nil
+}
+
+func HandleGitHubIssueTool(ctx context.Context, tool *ChatToolCall, arguments *GitHubIssueArguments) error {
+	if arguments.Number <= 0 {
+		return errors.New("invalid number")
+	}
+
+	result, err := RepoIssue(ctx, arguments)
+	if err != nil {
+		tool.Result = fmt.Sprintf("error: %v", err)
+
+		return nil
+	}
+
+	tool.Result = result
+
+	return nil
+}
+
+func RepoContents(ctx context.Context, arguments *GitHubContentsArguments) (string, error) {
+	target := strings.Trim(arguments.Path, "/")
+
+	apiPath := fmt.Sprintf("/repos/%s/%s/contents/%s", url.PathEscape(arguments.Owner), url.PathEscape(arguments.Repo), escapeGitHubPath(target))
+
+	if arguments.Ref != "" {
+		apiPath += "?ref=" + url.QueryEscape(arguments.Ref)
+	}
+
+	var raw json.RawMessage
+
+	err := GitHubGetJson(ctx, apiPath, &raw)
+	if err != nil {
+		return "", err
+	}
+
+	return FormatRepoContents(ctx, arguments, raw)
+}
+
+// FormatRepoContents formats a contents api response, which is either a file
+// or a list of directory entries.
+func FormatRepoContents(ctx context.Context, arguments *GitHubContentsArguments, raw json.RawMessage) (string, error) {
+	ref := displayRef(arguments.Ref)
+
+	// directories are returned as a list of entries
+	if bytes.HasPrefix(bytes.TrimSpace(raw), []byte("[")) {
+		var entries []GitHubContent
+
+		err := json.Unmarshal(raw, &entries)
+		if err != nil {
+			return "", err
+		}
+
+		return FormatGitHubDirectory(arguments, ref, entries), nil
+	}
+
+	var file GitHubContent
+
+	err := json.Unmarshal(raw, &file)
+	if err != nil {
+		return "", err
+	}
+
+	if file.Type != "file" {
+		return "", fmt.Errorf("%q is a %s, not a file or directory", file.Path, file.Type)
+	}
+
+	content, err := file.Text(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return FormatGitHubFile(arguments, ref, &file, content), nil
+}
+
+// Text returns the decoded file contents. Files larger than 1MB are not
+// included by the contents api and have to be downloaded separately.
+func (c *GitHubContent) Text(ctx context.Context) (string, error) {
+	if c.Encoding == "base64" {
+		content, err := base64.StdEncoding.DecodeString(c.Content)
+		if err != nil {
+			return "", err
+		}
+
+		return string(content), nil
+	}
+
+	if c.Content != "" || c.Size == 0 {
+		return c.Content, nil
+	}
+
+	if c.DownloadURL == "" {
+		return "", errors.New("file content unavailable")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.DownloadURL, nil)
+	if err != nil {
+		return "", err
+	}
+
+	// forges share this type, so only send the token to github itself
+	if env.Tokens.GitHub != "" && isGitHubHost(req.URL.Hostname()) {
+		req.Header.Set("Authorization", "Bearer "+env.Tokens.GitHub)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return "", fmt.Errorf("download failed with status %d", resp.StatusCode)
+	}
+
+	// line ranges need the start of the file, the rest is cut off anyway
+	content, err := io.ReadAll(io.LimitReader(resp.Body, 8*1024*1024))
+	if err != nil {
+		return "", err
+	}
+
+	return string(content), nil
+}
+
+func FormatGitHubFile(arguments *GitHubContentsArguments, ref string, file *GitHubContent, content string) string {
+	buf := GetFreeBuffer()
+	defer pool.Put(buf)
+
+	fmt.Fprintf(buf, "### %s (%s)\n", file.Path, ref)
+	fmt.Fprintf(buf, "- URL: %s\n", file.HtmlURL)
+	fmt.Fprintf(buf, "- Size: %d bytes\n", file.Size)
+
+	if strings.Contains(content, "\x00") || !utf8.ValidString(content) {
+		buf.WriteString("\n*Binary file, contents not shown.*\n")
+
+		return buf.String()
+	}
+
+	lines := strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+
+	start := max(arguments.StartLine, 1)
+	end := len(lines)
+
+	if arguments.EndLine > 0 {
+		end = min(arguments.EndLine, end)
+	}
+
+	if start > end {
+		fmt.Fprintf(buf, "\n*The file has %d lines, line %d is out of range.*\n", len(lines), start)
+
+		return buf.String()
+	}
+
+	var (
+		size int
+		last = start - 1
+	)
+
+	for _, line := range lines[start-1 : end] {
+		if size+len(line)+1 > GitHubMaxFileSize && last >= start {
+			break
+		}
+
+		size += len(line) + 1
+		last++
+	}
+
+	selected := strings.Join(lines[start-1:last], "\n")
+
+	// a single line (e.g. minified code) may still exceed the limit
+	if len(selected) > GitHubMaxFileSize {
+		selected = strings.ToValidUTF8(truncateText(selected, GitHubMaxFileSize), "")
+	}
+
+	fmt.Fprintf(buf, "- Lines: %d-%d of %d\n\n", start, last, len(lines))
+
+	fence := "```"
+
+	for strings.Contains(selected, fence) {
+		fence += "`"
+	}
+
+	lang := strings.TrimPrefix(filepath.Ext(file.Name), ".")
+
+	fmt.Fprintf(buf, "%s%s\n%s\n%s\n", fence, lang, selected, fence)
+
+	if last < end {
+		fmt.Fprintf(buf, "\n*... (truncated to save context, continue with start_line=%d) ...*\n", last+1)
+	}
+
+	return buf.String()
+}
+
+func FormatGitHubDirectory(arguments *GitHubContentsArguments, ref string, entries []GitHubContent) string {
+	sort.Slice(entries, func(i, j int) bool {
+		if (entries[i].Type == "dir") != (entries[j].Type == "dir") {
+			return entries[i].Type == "dir"
+		}
+
+		return entries[i].Name < entries[j].Name
+	})
+
+	buf := GetFreeBuffer()
+	defer pool.Put(buf)
+
+	fmt.Fprintf(buf, "### %s/%s/%s (%s)\n", arguments.Owner, arguments.Repo, strings.Trim(arguments.Path, "/"), ref)
+
+	if len(entries) == 0 {
+		buf.WriteString("*Empty directory.*\n")
+	}
+
+	for i, entry := range entries {
+		if i >= GitHubMaxEntries {
+			fmt.Fprintf(buf, "\n*... (%d more entries not shown) ...*\n", len(entries)-i)
+
+			break
+		}
+
+		switch entry.Type {
+		case "dir":
+			fmt.Fprintf(buf, "- [D] %s/\n", entry.Path)
+		case "file":
+			// gitlab trees do not include sizes
+			if entry.Size == 0 {
+				fmt.Fprintf(buf, "- [F] %s\n", entry.Path)
+			} else {
+				fmt.Fprintf(buf, "- [F] %s (%d bytes)\n", entry.Path, entry.Size)
+			}
+		default:
+			fmt.Fprintf(buf, "- [%s] %s\n", entry.Type, entry.Path)
+		}
+	}
+
+	return buf.String()
+}
+
+func RepoSearchCode(ctx context.Context, arguments *GitHubSearchCodeArguments) (string, error) {
+	limit := arguments.MaxResults
+	if limit <= 0 || limit > 20 {
+		limit = 10
+	}
+
+	query := fmt.Sprintf("%s repo:%s/%s", strings.TrimSpace(arguments.Query), arguments.Owner, arguments.Repo)
+
+	var response GitHubSearchCodeResponse
+
+	err := GitHubGetJsonAs(ctx, fmt.Sprintf("/search/code?per_page=%d&q=%s", limit, url.QueryEscape(query)), "application/vnd.github.text-match+json", &response)
+	if err != nil {
+		return "", err
+	}
+
+	buf := GetFreeBuffer()
+	defer pool.Put(buf)
+
+	fmt.Fprintf(buf, "### Code search in %s/%s: %q (%d matching files)\n", arguments.Owner, arguments.Repo, arguments.Query, response.TotalCount)
+
+	if len(response.Items) == 0 {
+		buf.WriteString("*No results.*\n")
+	}
+
+	for _, item := range response.Items {
+		fmt.Fprintf(buf, "\n#### [%s](%s)\n", item.Path, item.HtmlURL)
+
+		for _, match := range item.TextMatches {
+			fragment := strings.ToValidUTF8(truncateText(match.Fragment, 1024), "")
+
+			fmt.Fprintf(buf, "```\n%s\n```\n", strings.Trim(fragment, "\n"))
+		}
+	}
+
+	return buf.String(), nil
+}
+
+func RepoIssue(ctx context.Context, arguments *GitHubIssueArguments) (string, error) {
+	base := fmt.Sprintf("/repos/%s/%s", url.PathEscape(arguments.Owner), url.PathEscape(arguments.Repo))
+
+	var issue GitHubIssue
+
+	err := GitHubGetJson(ctx, fmt.Sprintf("%s/issues/%d", base, arguments.Number), &issue)
+	if err != nil {
+		return "", err
+	}
+
+	var (
+		wg sync.WaitGroup
+
+		comments       []GitHubComment
+		reviewComments []GitHubComment
+		pull           GitHubPullRequest
+		diff           string
+		diffTruncated  bool
+		diffErr        error
+	)
+
+	if issue.Comments > 0 {
+		wg.Go(func() {
+			err := GitHubGetJson(ctx, fmt.Sprintf("%s/issues/%d/comments?per_page=%d", base, arguments.Number, GitHubMaxComments), &comments)
+			if err != nil {
+				log.Warnf("failed to get issue comments: %v\n", err)
+			}
+		})
+	}
+
+	if issue.PullRequest != nil {
+		wg.Go(func() {
+			err := GitHubGetJson(ctx, fmt.Sprintf("%s/pulls/%d", base, arguments.Number), &pull)
+			if err != nil {
+				log.Warnf("failed to get pull request: %v\n", err)
+			}
+		})
+
+		wg.Go(func() {
+			err := GitHubGetJson(ctx, fmt.Sprintf("%s/pulls/%d/comments?per_page=%d", base, arguments.Number, GitHubMaxComments), &reviewComments)
+			if err != nil {
+				log.Warnf("failed to get review comments: %v\n", err)
+			}
+		})
+
+		wg.Go(func() {
+			diff, diffTruncated, diffErr = GitHubGetText(ctx, fmt.Sprintf("%s/pulls/%d", base, arguments.Number), "application/vnd.github.diff", GitHubMaxDiffSize)
+		})
+	}
+
+	wg.Wait()
+
+	buf := GetFreeBuffer()
+	defer pool.Put(buf)
+
+	kind := "issue"
+
+	if issue.PullRequest != nil {
+		kind = "pull request"
+	}
+
+	fmt.Fprintf(buf, "### #%d %s (%s %s)\n", issue.Number, issue.Title, issue.State, kind)
+	fmt.Fprintf(buf, "- URL: %s\n", issue.HtmlURL)
+	fmt.Fprintf(buf, "- Author: %s | Created: %s\n", issue.User.Login, issue.CreatedAt)
+
+	if len(issue.Labels) > 0 {
+		labels := make([]string, len(issue.Labels))
+
+		for i, label := range issue.Labels {
+			labels[i] = label.Name
+		}
+
+		fmt.Fprintf(buf, "- Labels: %s\n", strings.Join(labels, ", "))
+	}
+
+	if issue.PullRequest != nil && pull.Head.Ref != "" {
+		fmt.Fprintf(buf, "- Branch: %s -> %s | Merged: %t | Draft: %t\n", pull.Head.Ref, pull.Base.Ref, pull.Merged, pull.Draft)
+		fmt.Fprintf(buf, "- Changes: +%d -%d in %d files\n", pull.Additions, pull.Deletions, pull.ChangedFiles)
+	}
+
+	buf.WriteString("\n#### Description\n")
+
+	if strings.TrimSpace(issue.Body) == "" {
+		buf.WriteString("*No description.*\n")
+	} else {
+		buf.WriteString(strings.ToValidUTF8(truncateText(strings.TrimSpace(issue.Body), GitHubMaxCommentSize*2), ""))
+		buf.WriteString("\n")
+	}
+
+	if issue.Comments > 0 {
+		fmt.Fprintf(buf, "\n#### Comments (%d)\n", issue.Comments)
+
+		writeGitHubComments(buf, comments)
+
+		if issue.Comments > len(comments) {
+			fmt.Fprintf(buf, "\n*... (%d more comments not shown) ...*\n", issue.Comments-len(comments))
+		}
+	}
+
+	if len(reviewComments) > 0 {
+		buf.WriteString("\n#### Review comments\n")
+
+		writeGitHubComments(buf, reviewComments)
+	}
+
+	if issue.PullRequest != nil {
+		buf.WriteString("\n#### Diff\n")
+
+		switch {
+		case diffErr != nil:
+			fmt.Fprintf(buf, "*Failed to load diff: %v*\n", diffErr)
+		case strings.TrimSpace(diff) == "":
+			buf.WriteString("*Empty diff.*\n")
+		default:
+			fence := "```"
+
+			for strings.Contains(diff, fence) {
+				fence += "`"
+			}
+
+			fmt.Fprintf(buf, "%sdiff\n%s\n%s\n", fence, strings.TrimRight(diff, "\n"), fence)
+
+			if diffTruncated {
+				fmt.Fprintf(buf, "\n*... (diff truncated to %d bytes, use github_contents to read individual files) ...*\n", GitHubMaxDiffSize)
+			}
+		}
+	}
+
+	return buf.String(), nil
+}
+
+func writeGitHubComments(buf *bytes.Buffer, comments []GitHubComment) {
+	for _, comment := range comments {
+		body := strings.ToValidUTF8(truncateText(strings.TrimSpace(comment.Body), GitHubMaxCommentSize), "")
+
+		if comment.Path != "" {
+			fmt.Fprintf(buf, "\n**%s** on `%s:%d` (%s):\n%s\n", comment.User.Login, comment.Path, comment.Line, comment.CreatedAt, body)
+		} else {
+			fmt.Fprintf(buf, "\n**%s** (%s):\n%s\n", comment.User.Login, comment.CreatedAt, body)
+		}
+	}
+}
+
+func escapeGitHubPath(path string) string {
+	segments := strings.Split(path, "/")
+
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+
+	return strings.Join(segments, "/")
+}
+
+func isGitHubHost(host string) bool {
+	return host == "github.com" || strings.HasSuffix(host, ".github.com") || strings.HasSuffix(host, ".githubusercontent.com")
+}
+
+func displayRef(ref string) string {
+	if ref == "" {
+		return "default branch"
+	}
+
+	return ref
+}
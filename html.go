@@ -0,0 +1,762 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+type HTMLNode struct {
+	Tag      string
+	Text     string
+	Attrs    map[string]string
+	Parent   *HTMLNode
+	Children []*HTMLNode
+
+	depth int
+}
+
+// HTMLMaxDepth limits the nesting of elements, deeper elements become
+// siblings (so broken documents can't cause deep recursion).
+const HTMLMaxDepth = 256
+
+type markdownWriter struct {
+	buf   strings.Builder
+	base  *url.URL
+	lists []int
+	pre   int
+}
+
+var (
+	htmlVoidTags = map[string]bool{
+		"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true,
+		"input": true, "link": true, "meta": true, "param": true, "source": true, "track": true, "wbr": true,
+	}
+
+	htmlRawTags = map[string]bool{
+		"script": true, "style": true, "textarea": true, "title": true, "noscript": true, "xmp": true, "template": true,
+	}
+
+	// tags that are implicitly closed when the same (or a listed) tag opens
+	htmlAutoClose = map[string][]string{
+		"p":      {"p", "div", "ul", "ol", "table", "pre", "blockquote", "h1", "h2", "h3", "h4", "h5", "h6", "section", "article", "header", "footer", "nav", "form", "figure", "hr"},
+		"li":     {"li"},
+		"dt":     {"dt", "dd"},
+		"dd":     {"dt", "dd"},
+		"tr":     {"tr", "tbody", "thead", "tfoot"},
+		"td":     {"td", "th", "tr", "tbody", "thead", "tfoot"},
+		"th":     {"td", "th", "tr", "tbody", "thead", "tfoot"},
+		"option": {"option", "optgroup"},
+		"thead":  {"tbody", "tfoot"},
+		"tbody":  {"tbody", "tfoot"},
+	}
+
+	htmlDropTags = map[string]bool{
+		"script": true, "style": true, "noscript": true, "template": true, "nav": true, "header": true, "footer": true,
+		"aside": true, "form": true, "iframe": true, "svg": true, "canvas": true, "button": true, "select": true,
+		"input": true, "textarea": true, "dialog": true, "object": true, "embed": true, "link": true, "meta": true,
+	}
+
+	htmlBlockTags = map[string]bool{
+		"address": true, "article": true, "aside": true, "blockquote": true, "body": true, "dd": true, "details": true,
+		"div": true, "dl": true, "dt": true, "fieldset": true, "figcaption": true, "figure": true, "footer": true,
+		"form": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true, "header": true,
+		"hr": true, "li": true, "main": true, "nav": true, "ol": true, "p": true, "pre": true, "section": true,
+		"summary": true, "table": true, "tr": true, "ul": true,
+	}
+
+	htmlNegativeRgx = regexp.MustCompile(`(?i)(^|[\s_-])(nav|navbar|menu|footer|sidebar|comments?|share|sharing|social|ads?|advert|advertisement|banner|cookies?|consent|popup|modal|related|promo|newsletter|subscribe|breadcrumbs?|masthead|skip|hidden)([\s_-]|$)`)
+	htmlPositiveRgx = regexp.MustCompile(`(?i)(article|content|main|post|entry|body|text|story|prose|markdown|docs?)`)
+	htmlTagRgx      = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9:-]*`)
+	htmlAttrRgx     = regexp.MustCompile(`([^\s=/>"']+)(?:\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]+)))?`)
+	htmlSpaceRgx    = regexp.MustCompile(`\s+`)
+	htmlNewlinesRgx = regexp.MustCompile(`\n{3,}`)
+	htmlTrailingRgx = regexp.MustCompile(`(?m)[ \t]+$`)
+	htmlCharsetRgx  = regexp.MustCompile(`(?i)<meta[^>]+charset=["']?([a-z0-9_-]+)`)
+)
+
+// ParseHTML builds a simplified, forgiving DOM from an html document.
+func ParseHTML(src string) *HTMLNode {
+	root := &HTMLNode{
+		Tag: "#root",
+	}
+
+	current := root
+
+	appendText := func(text string) {
+		if text == "" {
+			return
+		}
+
+		current.Children = append(current.Children, &HTMLNode{
+			Text:   html.UnescapeString(text),
+			Parent: current,
+		})
+	}
+
+	for len(src) > 0 {
+		lt := strings.IndexByte(src, '<')
+		if lt == -1 {
+			appendText(src)
+
+			break
+		}
+
+		appendText(src[:lt])
+
+		src = src[lt:]
+
+		switch {
+		case strings.HasPrefix(src, "<!--"):
+			end := strings.Index(src, "-->")
+			if end == -1 {
+				return root
+			}
+
+			src = src[end+3:]
+
+			continue
+		case strings.HasPrefix(src, "<!") || strings.HasPrefix(src, "<?"):
+			end := strings.IndexByte(src, '>')
+			if end == -1 {
+				return root
+			}
+
+			src = src[end+1:]
+
+			continue
+		case strings.HasPrefix(src, "</"):
+			end := strings.IndexByte(src, '>')
+			if end == -1 {
+				return root
+			}
+
+			tag := strings.ToLower(strings.TrimSpace(src[2:end]))
+
+			src = src[end+1:]
+
+			// close the nearest matching element, ignore stray closing tags
+			for node := current; node != nil && node != root; node = node.Parent {
+				if node.Tag == tag {
+					current = node.Parent
+
+					break
+				}
+			}
+
+			continue
+		}
+
+		name := htmlTagRgx.FindString(src[1:])
+		if name == "" {
+			appendText("<")
+
+			src = src[1:]
+
+			continue
+		}
+
+		end := findTagEnd(src)
+		if end == -1 {
+			return root
+		}
+
+		tag := strings.ToLower(name)
+		inner := src[1+len(name) : end]
+
+		src = src[end+1:]
+
+		selfClosing := strings.HasSuffix(inner, "/")
+
+		node := &HTMLNode{
+			Tag:   tag,
+			Attrs: parseHTMLAttrs(inner),
+		}
+
+		// implicitly close elements like <p> and <li>
+		for {
+			closes, ok := htmlAutoClose[current.Tag]
+			if !ok || !containsTag(closes, tag) {
+				break
+			}
+
+			current = current.Parent
+		}
+
+		node.Parent = current
+		current.Children = append(current.Children, node)
+
+		if htmlVoidTags[tag] || selfClosing {
+			continue
+		}
+
+		node.depth = current.depth + 1
+
+		if htmlRawTags[tag] {
+			closing := strings.Index(strings.ToLower(src), "</"+tag)
+			if closing == -1 {
+				closing = len(src)
+			}
+
+			raw := src[:closing]
+
+			if raw != "" {
+				node.Children = append(node.Children, &HTMLNode{
+					Text:   html.UnescapeString(raw),
+					Parent: node,
+				})
+			}
+
+			src = src[closing:]
+
+			if gt := strings.IndexByte(src, '>'); gt != -1 {
+				src = src[gt+1:]
+			}
+
+			continue
+		}
+
+		if node.depth > HTMLMaxDepth {
+			continue
+		}
+
+		current = node
+	}
+
+	return root
+}
+
+// findTagEnd returns the index of the '>' closing a start tag, skipping quoted
+// attribute values.
+func findTagEnd(src string) int {
+	var quote byte
+
+	for i := 1; i < len(src); i++ {
+		b := src[i]
+
+		if quote != 0 {
+			if b == quote {
+				quote = 0
+			}
+
+			continue
+		}
+
+		switch b {
+		case '"', '\'':
+			quote = b
+		case '>':
+			return i
+		}
+	}
+
+	return -1
+}
+
+func parseHTMLAttrs(src string) map[string]string {
+	attrs := make(map[string]string)
+
+	for _, match := range htmlAttrRgx.FindAllStringSubmatch(src, -1) {
+		attrs[strings.ToLower(match[1])] = html.UnescapeString(match[2] + match[3] + match[4])
+	}
+
+	return attrs
+}
+
+func containsTag(list []string, tag string) bool {
+	for _, entry := range list {
+		if entry == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (n *HTMLNode) Attr(name string) string {
+	if n.Attrs == nil {
+		return ""
+	}
+
+	return n.Attrs[name]
+}
+
+func (n *HTMLNode) Find(tag string) *HTMLNode {
+	if n.Tag == tag {
+		return n
+	}
+
+	for _, child := range n.Children {
+		if found := child.Find(tag); found != nil {
+			return found
+		}
+	}
+
+	return nil
+}
+
+func (n *HTMLNode) TextContent() string {
+	if n.Tag == "" {
+		return n.Text
+	}
+
+	var buf strings.Builder
+
+	for _, child := range n.Children {
+		buf.WriteString(child.TextContent())
+	}
+
+	return buf.String()
+}
+
+// linkText returns the length of all text inside links.
+func (n *HTMLNode) linkText() int {
+	if n.Tag == "a" {
+		return len(strings.TrimSpace(n.TextContent()))
+	}
+
+	var total int
+
+	for _, child := range n.Children {
+		total += child.linkText()
+	}
+
+	return total
+}
+
+// isBoilerplate reports whether a node looks like navigation, ads or other
+// page chrome that is not part of the main content.
+func (n *HTMLNode) isBoilerplate() bool {
+	if htmlDropTags[n.Tag] {
+		return true
+	}
+
+	if n.Attr("hidden") != "" || n.Attr("aria-hidden") == "true" {
+		return true
+	}
+
+	switch n.Attr("role") {
+	case "navigation", "banner", "contentinfo", "complementary", "search", "dialog", "menu":
+		return true
+	}
+
+	if style := strings.ReplaceAll(n.Attr("style"), " ", ""); strings.Contains(style, "display:none") {
+		return true
+	}
+
+	marker := n.Attr("class") + " " + n.Attr("id")
+
+	if n.Tag == "body" || n.Tag == "main" || n.Tag == "article" {
+		return false
+	}
+
+	return htmlNegativeRgx.MatchString(marker) && !htmlPositiveRgx.MatchString(marker)
+}
+
+// prune removes all boilerplate nodes from the tree.
+func (n *HTMLNode) prune() {
+	children := n.Children[:0]
+
+	for _, child := range n.Children {
+		if child.Tag != "" && child.isBoilerplate() {
+			continue
+		}
+
+		child.prune()
+
+		children = append(children, child)
+	}
+
+	n.Children = children
+}
+
+// ExtractMainContent picks the node most likely holding the main content
+// of a page, similar to readability.
+func ExtractMainContent(root *HTMLNode) *HTMLNode {
+	body := root.Find("body")
+	if body == nil {
+		body = root
+	}
+
+	body.prune()
+
+	total := len(strings.TrimSpace(body.TextContent()))
+
+	for _, tag := range []string{"article", "main"} {
+		node := body.Find(tag)
+		if node != nil && len(strings.TrimSpace(node.TextContent())) > total/3 {
+			return node
+		}
+	}
+
+	var (
+		best      *HTMLNode
+		bestScore float64
+	)
+
+	var walk func(node *HTMLNode)
+
+	walk = func(node *HTMLNode) {
+		if node.Tag == "" {
+			return
+		}
+
+		var score float64
+
+		for _, child := range node.Children {
+			if child.Tag != "p" && child.Tag != "pre" && child.Tag != "blockquote" && child.Tag != "" {
+				continue
+			}
+
+			text := strings.TrimSpace(child.TextContent())
+			if len(text) < 25 {
+				continue
+			}
+
+			score += 1 + float64(strings.Count(text, ",")) + min(float64(len(text))/100, 3)
+		}
+
+		if score > 0 {
+			length := len(strings.TrimSpace(node.TextContent()))
+
+			if length > 0 {
+				score *= 1 - float64(node.linkText())/float64(length)
+			}
+
+			if htmlPositiveRgx.MatchString(node.Attr("class") + " " + node.Attr("id")) {
+				score *= 1.25
+			}
+		}
+
+		if score > bestScore {
+			best = node
+			bestScore = score
+		}
+
+		for _, child := range node.Children {
+			walk(child)
+		}
+	}
+
+	walk(body)
+
+	// paragraphs are usually split into sibling containers, so take the parent
+	// if it holds noticeably more text
+	if best != nil && best.Parent != nil && best.Parent != root {
+		own := len(strings.TrimSpace(best.TextContent()))
+		parent := len(strings.TrimSpace(best.Parent.TextContent()))
+
+		if parent < own*2 && best.Parent.linkText() < parent/3 {
+			best = best.Parent
+		}
+	}
+
+	if best == nil {
+		return body
+	}
+
+	return best
+}
+
+// HTMLToMarkdown extracts the title and main content of a page as markdown.
+func HTMLToMarkdown(src string, base *url.URL) (string, string) {
+	root := ParseHTML(src)
+
+	var title string
+
+	if node := root.Find("title"); node != nil {
+		title = strings.TrimSpace(htmlSpaceRgx.ReplaceAllString(node.TextContent(), " "))
+	}
+
+	content := ExtractMainContent(root)
+
+	writer := &markdownWriter{
+		base: base,
+	}
+
+	writer.node(content)
+
+	markdown := htmlTrailingRgx.ReplaceAllString(writer.buf.String(), "")
+	markdown = htmlNewlinesRgx.ReplaceAllString(markdown, "\n\n")
+
+	return title, strings.TrimSpace(markdown)
+}
+
+func (w *markdownWriter) write(text string) {
+	w.buf.WriteString(text)
+}
+
+func (w *markdownWriter) newline(count int) {
+	current := w.buf.String()
+
+	trailing := len(current) - len(strings.TrimRight(current, "\n"))
+
+	if len(current) == 0 {
+		return
+	}
+
+	for i := trailing; i < count; i++ {
+		w.buf.WriteByte('\n')
+	}
+}
+
+func (w *markdownWriter) children(node *HTMLNode) {
+	for _, child := range node.Children {
+		w.node(child)
+	}
+}
+
+func (w *markdownWriter) inline(node *HTMLNode) string {
+	sub := &markdownWriter{
+		base: w.base,
+		pre:  w.pre,
+	}
+
+	sub.children(node)
+
+	return strings.TrimSpace(htmlSpaceRgx.ReplaceAllString(sub.buf.String(), " "))
+}
+
+func (w *markdownWriter) resolve(link string) string {
+	link = strings.TrimSpace(link)
+
+	if w.base == nil || link == "" {
+		return link
+	}
+
+	parsed, err := w.base.Parse(link)
+	if err != nil {
+		return link
+	}
+
+	return parsed.String()
+}
+
+func (w *markdownWriter) node(node *HTMLNode) {
+	if node.Tag == "" {
+		if w.pre > 0 {
+			w.write(node.Text)
+
+			return
+		}
+
+		text := htmlSpaceRgx.ReplaceAllString(node.Text, " ")
+
+		current := w.buf.String()
+
+		if strings.HasSuffix(current, "\n") || strings.HasSuffix(current, " ") || current == "" {
+			text = strings.TrimLeft(text, " ")
+		}
+
+		w.write(text)
+
+		return
+	}
+
+	switch node.Tag {
+	case "title", "head":
+		return
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		text := w.inline(node)
+		if text == "" {
+			return
+		}
+
+		w.newline(2)
+		w.write(strings.Repeat("#", int(node.Tag[1]-'0')) + " " + text)
+		w.newline(2)
+	case "br":
+		w.newline(1)
+	case "hr":
+		w.newline(2)
+		w.write("---")
+		w.newline(2)
+	case "pre":
+		w.newline(2)
+
+		lang := ""
+
+		if code := node.Find("code"); code != nil {
+			for _, class := range strings.Fields(code.Attr("class")) {
+				if after, ok := strings.CutPrefix(class, "language-"); ok {
+					lang = after
+				}
+			}
+		}
+
+		w.pre++
+
+		code := strings.Trim(node.TextContent(), "\n")
+
+		w.pre--
+
+		w.write("```" + lang + "\n" + code + "\n```")
+		w.newline(2)
+	case "code", "kbd", "samp":
+		if w.pre > 0 {
+			w.children(node)
+
+			return
+		}
+
+		text := strings.TrimSpace(node.TextContent())
+		if text != "" {
+			w.write("`" + text + "`")
+		}
+	case "strong", "b":
+		text := w.inline(node)
+		if text != "" {
+			w.write("**" + text + "** ")
+		}
+	case "em", "i":
+		text := w.inline(node)
+		if text != "" {
+			w.write("*" + text + "* ")
+		}
+	case "a":
+		text := w.inline(node)
+		href := node.Attr("href")
+
+		if text == "" {
+			return
+		}
+
+		if href == "" || strings.HasPrefix(href, "#") || strings.HasPrefix(strings.ToLower(href), "javascript:") {
+			w.write(text + " ")
+
+			return
+		}
+
+		w.write(fmt.Sprintf("[%s](%s) ", text, w.resolve(href)))
+	case "img":
+		src := node.Attr("src")
+		alt := strings.TrimSpace(node.Attr("alt"))
+
+		if src == "" || strings.HasPrefix(src, "data:") {
+			return
+		}
+
+		w.write(fmt.Sprintf("![%s](%s) ", alt, w.resolve(src)))
+	case "ul", "ol":
+		start := 0
+
+		if node.Tag == "ol" {
+			start = 1
+		}
+
+		w.lists = append(w.lists, start)
+
+		w.newline(1)
+		w.children(node)
+
+		w.lists = w.lists[:len(w.lists)-1]
+
+		if len(w.lists) == 0 {
+			w.newline(2)
+		} else {
+			w.newline(1)
+		}
+	case "li":
+		w.newline(1)
+
+		depth := max(len(w.lists)-1, 0)
+		marker := "-"
+
+		if len(w.lists) > 0 && w.lists[len(w.lists)-1] > 0 {
+			marker = fmt.Sprintf("%d.", w.lists[len(w.lists)-1])
+
+			w.lists[len(w.lists)-1]++
+		}
+
+		w.write(strings.Repeat("  ", depth) + marker + " ")
+		w.children(node)
+	case "blockquote":
+		sub := &markdownWriter{
+			base: w.base,
+		}
+
+		sub.children(node)
+
+		text := strings.TrimSpace(htmlNewlinesRgx.ReplaceAllString(sub.buf.String(), "\n\n"))
+		if text == "" {
+			return
+		}
+
+		w.newline(2)
+		w.write("> " + strings.ReplaceAll(text, "\n", "\n> "))
+		w.newline(2)
+	case "table":
+		w.table(node)
+	default:
+		block := htmlBlockTags[node.Tag]
+
+		if block {
+			w.newline(2)
+		}
+
+		w.children(node)
+
+		if block {
+			w.newline(2)
+		}
+	}
+}
+
+func (w *markdownWriter) table(node *HTMLNode) {
+	var rows [][]string
+
+	var collect func(node *HTMLNode)
+
+	collect = func(node *HTMLNode) {
+		for _, child := range node.Children {
+			switch child.Tag {
+			case "tr":
+				var row []string
+
+				for _, cell := range child.Children {
+					if cell.Tag == "td" || cell.Tag == "th" {
+						row = append(row, strings.ReplaceAll(w.inline(cell), "|", "\\|"))
+					}
+				}
+
+				if len(row) > 0 {
+					rows = append(rows, row)
+				}
+			case "thead", "tbody", "tfoot":
+				collect(child)
+			}
+		}
+	}
+
+	collect(node)
+
+	if len(rows) == 0 {
+		return
+	}
+
+	var columns int
+
+	for _, row := range rows {
+		columns = max(columns, len(row))
+	}
+
+	w.newline(2)
+
+	for i, row := range rows {
+		for len(row) < columns {
+			row = append(row, "")
+		}
+
+		w.write("| " + strings.Join(row, " | ") + " |")
+		w.newline(1)
+
+		if i == 0 {
+			w.write(strings.Repeat("| --- ", columns) + "|")
+			w.newline(1)
+		}
+	}
+
+	w.newline(2)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestHTMLToMarkdownMalformed(t *testing.T) {
+	base, _ := url.Parse("https://example.com/page")
+
+	tests := []struct {
+		name    string
+		src     string
+		title   string
+		want    []string
+		exclude []string
+	}{
+		{
+			name:  "unclosed tags",
+			src:   "<html><head><title>Title</title><body><p>first<p>second<div><b>bold",
+			title: "Title",
+			want:  []string{"first", "second", "**bold**"},
+		},
+		{
+			name:  "stray closing tags",
+			src:   "</div></p><title>Stray</title><p>text</span></li></p>",
+			title: "Stray",
+			want:  []string{"text"},
+		},
+		{
+			name:    "unterminated comment",
+			src:     "<p>before</p><!-- comment <p>after</p>",
+			want:    []string{"before"},
+			exclude: []string{"after", "comment"},
+		},
+		{
+			name:    "unterminated script",
+			src:     "<p>visible</p><script>var a = '<p>hidden</p>';",
+			want:    []string{"visible"},
+			exclude: []string{"hidden"},
+		},
+		{
+			name: "unquoted and broken attributes",
+			src:  `<p><a href=/docs class="x>link</a> after</p><p><a href=/ok>ok</a></p>`,
+		},
+		{
+			name: "lone angle brackets",
+			src:  "<p>1 < 2 and 3 > 2 <</p>",
+			want: []string{"1 < 2 and 3 > 2"},
+		},
+		{
+			name: "deep nesting",
+			src:  strings.Repeat("<div>", 100_000) + "deep" + strings.Repeat("</span>", 1000),
+			want: []string{"deep"},
+		},
+		{
+			name: "deep inline nesting",
+			src:  "<p>" + strings.Repeat("<b><i>", 10_000) + "inline",
+			want: []string{"inline"},
+		},
+		{
+			name: "empty",
+			src:  "",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			title, markdown := HTMLToMarkdown(test.src, base)
+
+			if title != test.title {
+				t.Errorf("title = %q, want %q", title, test.title)
+			}
+
+			for _, want := range test.want {
+				if !strings.Contains(markdown, want) {
+					t.Errorf("markdown does not contain %q:\n%s", want, markdown)
+				}
+			}
+
+			for _, exclude := range test.exclude {
+				if strings.Contains(markdown, exclude) {
+					t.Errorf("markdown contains %q:\n%s", exclude, markdown)
+				}
+			}
+		})
+	}
+}
+
+func TestParseHTMLDepth(t *testing.T) {
+	root := ParseHTML(strings.Repeat("<div>", 10_000))
+
+	var depth int
+
+	for node := root; len(node.Children) > 0; node = node.Children[len(node.Children)-1] {
+		depth++
+	}
+
+	if depth > HTMLMaxDepth+1 {
+		t.Errorf("depth = %d, want at most %d", depth, HTMLMaxDepth+1)
+	}
+}
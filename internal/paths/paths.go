@@ -3,6 +3,9 @@ package paths
 type Paths struct {
 	Config          string
 	Settings        string
+	Chats           string
 	Prompts         string
 	VocabularyCache string
+	ToolCache       string
+	ModelCache      string
 }
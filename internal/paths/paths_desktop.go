@@ -26,8 +26,11 @@ func ResolvePaths() (Paths, error) {
 	return Paths{
 		Config:          filepath.Join(config, "config.yml"),
 		Settings:        filepath.Join(config, "settings.yml"),
+		Chats:           filepath.Join(config, "chats"),
 		Prompts:         filepath.Join(exe, "prompts"),
 		VocabularyCache: filepath.Join(cache, "vocabulary.tiktoken"),
+		ToolCache:       filepath.Join(cache, "tools"),
+		ModelCache:      filepath.Join(cache, "models.msgpack"),
 	}, nil
 }
 
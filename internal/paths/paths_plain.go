@@ -16,7 +16,10 @@ func ResolvePaths() (Paths, error) {
 	return Paths{
 		Config:          filepath.Join(cwd, "config.yml"),
 		Settings:        filepath.Join(cwd, "settings.yml"),
+		Chats:           filepath.Join(cwd, "chats"),
 		Prompts:         filepath.Join(cwd, "prompts"),
 		VocabularyCache: filepath.Join(cwd, "vocabulary.tiktoken"),
+		ToolCache:       filepath.Join(cwd, "cache"),
+		ModelCache:      filepath.Join(cwd, "models.msgpack"),
 	}, nil
 }
@@ -0,0 +1,383 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// A minimal server side implementation of RFC 6455 (no extensions, no
+// subprotocols), which is all whiskr needs.
+
+type Opcode byte
+
+const (
+	OpContinuation Opcode = 0x0
+	OpText         Opcode = 0x1
+	OpBinary       Opcode = 0x2
+	OpClose        Opcode = 0x8
+	OpPing         Opcode = 0x9
+	OpPong         Opcode = 0xA
+)
+
+// Close status codes
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseInvalidPayload  = 1007
+	CloseMessageTooLarge = 1009
+)
+
+const (
+	acceptGUID   = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	writeTimeout = 10 * time.Second
+)
+
+var (
+	ErrClosed       = errors.New("websocket closed")
+	ErrNotWebSocket = errors.New("not a websocket handshake")
+	ErrBadVersion   = errors.New("unsupported websocket version")
+	ErrCrossOrigin  = errors.New("cross-origin websocket request")
+	ErrProtocol     = errors.New("websocket protocol error")
+	ErrTooLarge     = errors.New("websocket message too large")
+	ErrInvalidUTF8  = errors.New("invalid utf-8 in text message")
+)
+
+type Conn struct {
+	conn net.Conn
+	rd   *bufio.Reader
+
+	wmx    sync.Mutex
+	closed bool
+
+	// MaxMessageSize limits the size of (reassembled) incoming messages.
+	MaxMessageSize int64
+
+	// IdleTimeout closes connections that send no frames (including pongs)
+	// for this long, 0 disables it.
+	IdleTimeout time.Duration
+}
+
+func accept(key string) string {
+	hash := sha1.Sum([]byte(key + acceptGUID))
+
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+func headerContains(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for part := range strings.SplitSeq(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// checkOrigin rejects browser requests from other sites, since cookies are
+// sent along with cross-origin websocket handshakes.
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	parsed, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+
+	return strings.EqualFold(parsed.Host, r.Host)
+}
+
+// Upgrade validates the handshake and takes over the connection. Nothing is
+// written to w if an error is returned before the connection is hijacked.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if r.Method != http.MethodGet || !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		return nil, ErrNotWebSocket
+	}
+
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		return nil, ErrBadVersion
+	}
+
+	key := r.Header.Get("Sec-WebSocket-Key")
+
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return nil, ErrNotWebSocket
+	}
+
+	if !checkOrigin(r) {
+		return nil, ErrCrossOrigin
+	}
+
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + accept(key) + "\r\n\r\n"
+
+	conn.SetDeadline(time.Time{})
+
+	if _, err := conn.Write([]byte(response)); err != nil {
+		conn.Close()
+
+		return nil, err
+	}
+
+	return &Conn{
+		conn:           conn,
+		rd:             rw.Reader,
+		MaxMessageSize: 32 * 1024 * 1024,
+	}, nil
+}
+
+func (c *Conn) readFrame() (bool, Opcode, []byte, error) {
+	if c.IdleTimeout > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(c.IdleTimeout))
+	}
+
+	var header [2]byte
+
+	if _, err := io.ReadFull(c.rd, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+
+	var (
+		fin    = header[0]&0x80 != 0
+		rsv    = header[0] & 0x70
+		opcode = Opcode(header[0] & 0x0f)
+		masked = header[1]&0x80 != 0
+		length = int64(header[1] & 0x7f)
+	)
+
+	// clients must mask their frames and we negotiate no extensions
+	if rsv != 0 || !masked {
+		return false, 0, nil, ErrProtocol
+	}
+
+	switch length {
+	case 126:
+		var ext [2]byte
+
+		if _, err := io.ReadFull(c.rd, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+
+		if _, err := io.ReadFull(c.rd, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+
+		raw := binary.BigEndian.Uint64(ext[:])
+		if raw>>63 != 0 {
+			return false, 0, nil, ErrProtocol
+		}
+
+		length = int64(raw)
+	}
+
+	if opcode >= OpClose && (!fin || length > 125) {
+		return false, 0, nil, ErrProtocol
+	}
+
+	if length > c.MaxMessageSize {
+		return false, 0, nil, ErrTooLarge
+	}
+
+	var mask [4]byte
+
+	if _, err := io.ReadFull(c.rd, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+
+	payload := make([]byte, length)
+
+	if _, err := io.ReadFull(c.rd, payload); err != nil {
+		return false, 0, nil, err
+	}
+
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+
+	return fin, opcode, payload, nil
+}
+
+// ReadMessage returns the next text or binary message. Pings are answered and
+// fragmented messages reassembled. A close frame from the client is answered
+// and reported as ErrClosed, protocol violations close the connection.
+func (c *Conn) ReadMessage() (Opcode, []byte, error) {
+	var (
+		opcode  Opcode
+		message []byte
+		started bool
+	)
+
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrProtocol):
+				c.CloseWith(CloseProtocolError, "")
+			case errors.Is(err, ErrTooLarge):
+				c.CloseWith(CloseMessageTooLarge, "")
+			}
+
+			return 0, nil, err
+		}
+
+		switch op {
+		case OpPing:
+			if err := c.writeFrame(OpPong, payload); err != nil {
+				return 0, nil, err
+			}
+
+			continue
+		case OpPong:
+			continue
+		case OpClose:
+			c.CloseWith(CloseNormal, "")
+
+			return 0, nil, ErrClosed
+		case OpText, OpBinary:
+			if started {
+				c.CloseWith(CloseProtocolError, "")
+
+				return 0, nil, ErrProtocol
+			}
+
+			started = true
+			opcode = op
+		case OpContinuation:
+			if !started {
+				c.CloseWith(CloseProtocolError, "")
+
+				return 0, nil, ErrProtocol
+			}
+		default:
+			c.CloseWith(CloseProtocolError, "")
+
+			return 0, nil, ErrProtocol
+		}
+
+		if int64(len(message)+len(payload)) > c.MaxMessageSize {
+			c.CloseWith(CloseMessageTooLarge, "")
+
+			return 0, nil, ErrTooLarge
+		}
+
+		message = append(message, payload...)
+
+		if !fin {
+			continue
+		}
+
+		if opcode == OpText && !utf8.Valid(message) {
+			c.CloseWith(CloseInvalidPayload, "")
+
+			return 0, nil, ErrInvalidUTF8
+		}
+
+		return opcode, message, nil
+	}
+}
+
+func (c *Conn) writeFrame(opcode Opcode, payload []byte) error {
+	c.wmx.Lock()
+	defer c.wmx.Unlock()
+
+	if c.closed {
+		return ErrClosed
+	}
+
+	return c.write(opcode, payload)
+}
+
+func (c *Conn) write(opcode Opcode, payload []byte) error {
+	frame := make([]byte, 0, len(payload)+10)
+
+	frame = append(frame, 0x80|byte(opcode))
+
+	switch length := len(payload); {
+	case length <= 125:
+		frame = append(frame, byte(length))
+	case length <= 0xffff:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(length))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(length))
+	}
+
+	frame = append(frame, payload...)
+
+	c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+
+	_, err := c.conn.Write(frame)
+
+	return err
+}
+
+// WriteMessage writes a single, unfragmented message. It is safe for
+// concurrent use.
+func (c *Conn) WriteMessage(opcode Opcode, payload []byte) error {
+	if opcode != OpText && opcode != OpBinary {
+		return fmt.Errorf("invalid message opcode %d", opcode)
+	}
+
+	return c.writeFrame(opcode, payload)
+}
+
+func (c *Conn) Ping() error {
+	return c.writeFrame(OpPing, nil)
+}
+
+// CloseWith sends a close frame and closes the connection.
+func (c *Conn) CloseWith(code int, reason string) error {
+	c.wmx.Lock()
+	defer c.wmx.Unlock()
+
+	if c.closed {
+		return nil
+	}
+
+	c.closed = true
+
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+
+	payload = append(payload, reason...)
+
+	c.write(OpClose, payload)
+
+	return c.conn.Close()
+}
+
+func (c *Conn) Close() error {
+	return c.CloseWith(CloseNormal, "")
+}
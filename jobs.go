@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	rdebug "runtime/debug"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type ChatJob struct {
+	mx sync.Mutex
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	ID       string
+	Username string
+
+	// the browser session that started the generation
+	session string
+
+	chunks    []*Chunk
+	notify    chan struct{}
+	done      bool
+	finished  time.Time
+	approvals map[string]chan *ToolApproval
+}
+
+// JobMaxChunks limits the chunks buffered for reattaching to a generation.
+const JobMaxChunks = 100_000
+
+var (
+	jobMx   sync.RWMutex
+	jobs    = make(map[string]*ChatJob)
+	running = make(map[string]int64)
+)
+
+var (
+	ErrJobNotFound = errors.New("generation not found")
+	ErrJobTooLarge = errors.New("generation exceeded the chunk limit")
+	ErrJobLimit    = errors.New("too many running generations")
+)
+
+// StartChatJob registers a new generation that outlives the http request which
+// started it. Only the session that started it can access it. The job id is
+// always the first chunk written to the job.
+func StartChatJob(username, session string, run func(ctx context.Context, job *ChatJob)) (*ChatJob, error) {
+	id, err := CreateSecret(16)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	job := &ChatJob{
+		ctx:      ctx,
+		cancel:   cancel,
+		ID:       id,
+		Username: username,
+		session:  session,
+		notify:   make(chan struct{}),
+	}
+
+	jobMx.Lock()
+
+	// without authentication all clients share one limit
+	if running[username] >= env.Settings.MaxGenerations {
+		jobMx.Unlock()
+
+		cancel()
+
+		return nil, ErrJobLimit
+	}
+
+	jobs[id] = job
+	running[username]++
+
+	jobMx.Unlock()
+
+	job.WriteChunk(NewChunk(ChunkID, id))
+
+	go func() {
+		defer job.finish()
+
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				log.Errorf("panic in generation %s: %v\n%s\n", id, recovered, rdebug.Stack())
+
+				job.WriteChunk(NewChunk(ChunkError, fmt.Errorf("internal error: %v", recovered)))
+			}
+		}()
+
+		run(ctx, job)
+	}()
+
+	return job, nil
+}
+
+// RecoverError runs fn and turns a panic into an error, so a single failing
+// tool or worker cannot take down the server.
+func RecoverError(name string, fn func() error) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			log.Errorf("panic in %s: %v\n%s\n", name, recovered, rdebug.Stack())
+
+			err = fmt.Errorf("internal error: %v", recovered)
+		}
+	}()
+
+	return fn()
+}
+
+// GetChatJob returns the generation if it was started by the same user and
+// session.
+func GetChatJob(id, username, session string) *ChatJob {
+	jobMx.RLock()
+	defer jobMx.RUnlock()
+
+	job, ok := jobs[id]
+	if !ok || job.Username != username || session == "" || job.session != session {
+		return nil
+	}
+
+	return job
+}
+
+func (j *ChatJob) WriteChunk(chunk *Chunk) error {
+	j.mx.Lock()
+	defer j.mx.Unlock()
+
+	if j.done {
+		return errors.New("generation already finished")
+	}
+
+	if len(j.chunks) >= JobMaxChunks {
+		return ErrJobTooLarge
+	}
+
+	// the last chunk is reserved for the error aborting the generation
+	var err error
+
+	if len(j.chunks) == JobMaxChunks-1 {
+		chunk = NewChunk(ChunkError, ErrJobTooLarge)
+		err = ErrJobTooLarge
+
+		j.cancel()
+	}
+
+	j.chunks = append(j.chunks, chunk)
+
+	close(j.notify)
+	j.notify = make(chan struct{})
+
+	return err
+}
+
+// Since returns all chunks starting at index from, whether the job has finished
+// and a channel that is closed once new chunks are available.
+func (j *ChatJob) Since(from int) ([]*Chunk, bool, <-chan struct{}) {
+	j.mx.Lock()
+	defer j.mx.Unlock()
+
+	from = min(max(from, 0), len(j.chunks))
+
+	return j.chunks[from:], j.done, j.notify
+}
+
+func (j *ChatJob) Attach(ctx context.Context, response IndexedChunkWriter, from int) error {
+	for {
+		chunks, done, wait := j.Since(from)
+
+		for i, chunk := range chunks {
+			err := response.WriteIndexedChunk(chunk, from+i)
+			if err != nil {
+				return err
+			}
+		}
+
+		from += len(chunks)
+
+		if done {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-wait:
+		}
+	}
+}
+
+// Cancel aborts a running generation, returning false if it already finished.
+func (j *ChatJob) Cancel() bool {
+	j.mx.Lock()
+	done := j.done
+	j.mx.Unlock()
+
+	if done {
+		return false
+	}
+
+	j.cancel()
+
+	return true
+}
+
+func (j *ChatJob) finish() {
+	j.cancel()
+
+	j.mx.Lock()
+
+	j.done = true
+	j.finished = time.Now()
+
+	close(j.notify)
+	j.notify = make(chan struct{})
+
+	j.mx.Unlock()
+
+	jobMx.Lock()
+
+	running[j.Username]--
+
+	if running[j.Username] <= 0 {
+		delete(running, j.Username)
+	}
+
+	jobMx.Unlock()
+
+	debug("generation %s finished", j.ID)
+
+	time.AfterFunc(time.Duration(env.Settings.JobRetention)*time.Minute, func() {
+		jobMx.Lock()
+		delete(jobs, j.ID)
+		jobMx.Unlock()
+
+		debug("generation %s expired", j.ID)
+	})
+}
+
+func ServeChatJob(w http.ResponseWriter, r *http.Request, job *ChatJob, from int) {
+	ctx := r.Context()
+
+	response, err := NewStream(w, r)
+	if err != nil {
+		RespondJson(w, http.StatusBadRequest, map[string]any{
+			"error": err.Error(),
+		})
+
+		return
+	}
+
+	go response.KeepAlive(5 * time.Second)
+
+	err = job.Attach(ctx, response, from)
+	if err != nil && !errors.Is(err, context.Canceled) {
+		debug("detached from generation %s: %v", job.ID, err)
+	}
+}
+
+func HandleChatStream(w http.ResponseWriter, r *http.Request) {
+	var from int
+
+	if raw := r.URL.Query().Get("from"); raw != "" {
+		number, err := strconv.Atoi(raw)
+		if err != nil || number < 0 {
+			RespondJson(w, http.StatusBadRequest, map[string]any{
+				"error": "invalid from index",
+			})
+
+			return
+		}
+
+		from = number
+	} else if raw := r.Header.Get("Last-Event-ID"); raw != "" {
+		// sse clients resume after the last event they received
+		number, err := strconv.Atoi(raw)
+		if err == nil && number >= 0 {
+			from = number + 1
+		}
+	}
+
+	job := GetChatJob(chi.URLParam(r, "id"), GetUsername(r), GetSession(r))
+	if job == nil {
+		RespondJson(w, http.StatusNotFound, map[string]any{
+			"error": ErrJobNotFound.Error(),
+		})
+
+		return
+	}
+
+	debug("reattaching to generation %s from %d", job.ID, from)
+
+	ServeChatJob(w, r, job, from)
+}
+
+func HandleChatCancel(w http.ResponseWriter, r *http.Request) {
+	job := GetChatJob(chi.URLParam(r, "id"), GetUsername(r), GetSession(r))
+	if job == nil {
+		RespondJson(w, http.StatusNotFound, map[string]any{
+			"error": ErrJobNotFound.Error(),
+		})
+
+		return
+	}
+
+	cancelled := job.Cancel()
+
+	debug("cancel generation %s: %v", job.ID, cancelled)
+
+	RespondJson(w, http.StatusOK, map[string]any{
+		"cancelled": cancelled,
+	})
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type KagiProvider struct {
+	token string
+}
+
+type KagiResult struct {
+	Type      int    `json:"t"`
+	Rank      int    `json:"rank"`
+	URL       string `json:"url"`
+	Title     string `json:"title"`
+	Snippet   string `json:"snippet"`
+	Published string `json:"published"`
+}
+
+type KagiResponse struct {
+	Meta struct {
+		ID string `json:"id"`
+	} `json:"meta"`
+	Data  []KagiResult `json:"data"`
+	Error []struct {
+		Code int    `json:"code"`
+		Msg  string `json:"msg"`
+	} `json:"error"`
+}
+
+func (p *KagiProvider) Name() string {
+	return "kagi"
+}
+
+func (p *KagiProvider) Search(ctx context.Context, query string, args *SearchWebArguments) (*SearchResponse, error) {
+	params := url.Values{}
+
+	params.Set("q", SiteFilterQuery(query, args.IncludeDomains, args.ExcludeDomains))
+	params.Set("limit", fmt.Sprint(args.MaxResults))
+
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("https://kagi.com/api/v0/search?%s", params.Encode()), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", "Bot "+p.token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+
+		return nil, fmt.Errorf("kagi api error (%d): %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var response KagiResponse
+
+	err = json.NewDecoder(resp.Body).Decode(&response)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(response.Error) > 0 {
+		return nil, fmt.Errorf("kagi api error (%d): %s", response.Error[0].Code, response.Error[0].Msg)
+	}
+
+	results := &SearchResponse{
+		RequestID: response.Meta.ID,
+		Results:   make([]TavilyResult, 0, len(response.Data)),
+	}
+
+	for _, result := range response.Data {
+		// type 1 entries are related searches
+		if result.Type != 0 {
+			continue
+		}
+
+		results.Results = append(results.Results, TavilyResult{
+			Title:         result.Title,
+			URL:           result.URL,
+			PublishedDate: result.Published,
+			Score:         RankScore(len(results.Results)),
+			Summary:       result.Snippet,
+		})
+	}
+
+	return results, nil
+}
@@ -19,10 +19,12 @@ import (
 var Version = "dev"
 
 var (
-	path     paths.Paths
-	prompts  []Prompt
-	env      *Environment
-	settings *Settings
+	path      paths.Paths
+	prompts   []Prompt
+	env       *Environment
+	settings  *Settings
+	chats     *ChatStore
+	tokenizer *Tokenizer
 
 	log = plain.New(plain.WithDate(plain.RFC3339Local))
 )
@@ -82,10 +84,42 @@ func main() {
 
 	defer settings.Store()
 
-	err = StartModelUpdateLoop()
+	log.Println("Loading chats...")
+
+	chats, err = LoadChatStore()
+	log.MustFail(err)
+
+	toolCache, err = LoadToolCache()
+	if err != nil {
+		log.Warnf("Unable to load tool cache: %v\n", err)
+	}
+
+	err = LoadWebhookTools()
 	log.MustFail(err)
 
-	tokenizer, err := LoadTokenizer(TikTokenSource)
+	err = LoadForgeTools()
+	log.MustFail(err)
+
+	if env.Tools.Documents.Directory != "" {
+		log.Println("Indexing documents...")
+
+		err = LoadDocumentIndex()
+		if err != nil {
+			log.Warnf("Unable to index documents: %v\n", err)
+		}
+	}
+
+	if len(env.Tools.MCP) > 0 {
+		log.Println("Loading mcp servers...")
+
+		LoadMCPServers()
+
+		defer CloseMCPServers()
+	}
+
+	StartModelUpdateLoop()
+
+	tokenizer, err = LoadTokenizer(TikTokenSource)
 	log.MustFail(err)
 
 	log.Println("Calculating overhead...")
@@ -94,7 +128,7 @@ func main() {
 		prompts[i].Tokens = tokenizer.CountTokens(p.Text)
 	}
 
-	searchToolsJson, _ := json.Marshal(GetSearchTools())
+	searchToolsJson, _ := json.Marshal(ToolDefinitions(AllTools()))
 
 	overhead := map[string]any{
 		"files":    tokenizer.CountTokens(InternalFilesPrompt),
@@ -107,6 +141,7 @@ func main() {
 
 	r.Use(middleware.Recoverer)
 	r.Use(log.Middleware())
+	r.Use(Session)
 
 	r.Handle("/*", frontend(env.Debug))
 
@@ -118,7 +153,7 @@ func main() {
 			"authenticated": IsAuthenticated(r),
 			"config": map[string]any{
 				"auth":    env.Authentication.Enabled,
-				"search":  env.Tokens.Tavily != "",
+				"search":  HasTools(),
 				"motion":  env.UI.ReducedMotion,
 				"images":  env.Models.ImageGeneration,
 				"tts":     env.Models.TextToSpeech,
@@ -129,6 +164,8 @@ func main() {
 			"models":       ModelList,
 			"audio_models": AudioList,
 			"prompts":      prompts,
+			"documents":    documents.Stats(),
+			"catalog":      CatalogStatus(),
 			"version":      Version,
 		})
 	})
@@ -146,6 +183,13 @@ func main() {
 
 	r.Post("/-/auth", HandleAuthentication)
 
+	r.Group(func(gr chi.Router) {
+		gr.Use(AuthenticateKey)
+
+		gr.Get("/v1/models", HandleAPIModels)
+		gr.Post("/v1/chat/completions", HandleAPICompletion)
+	})
+
 	r.Group(func(gr chi.Router) {
 		gr.Use(Authenticate)
 
@@ -153,14 +197,26 @@ func main() {
 		gr.Post("/-/title", HandleTitle)
 
 		gr.Post("/-/chat", HandleChat)
+		gr.Get("/-/chat/{id}/stream", HandleChatStream)
+		gr.Post("/-/chat/{id}/cancel", HandleChatCancel)
+		gr.Post("/-/chat/{id}/approve", HandleChatApprove)
+		gr.Get("/-/ws", HandleSocket)
 		gr.Post("/-/dump", HandleDump)
 
-		gr.Post("/-/tokenize", HandleTokenize(tokenizer))
+		gr.Post("/-/tokenize", HandleTokenize)
 		gr.Post("/-/preview", HandlePreview)
+		gr.Post("/-/fetch", HandleFetch)
+		gr.Delete("/-/cache", HandlePurgeCache)
 		gr.Post("/-/image", HandleImage)
 		gr.Post("/-/tts", HandleTTS)
 
 		gr.Patch("/-/settings/{setting}", HandleUserSetting)
+
+		gr.Get("/-/chats", HandleListChats)
+		gr.Post("/-/chats", HandleCreateChat)
+		gr.Get("/-/chats/{id}", HandleGetChat)
+		gr.Put("/-/chats/{id}", HandleUpdateChat)
+		gr.Delete("/-/chats/{id}", HandleDeleteChat)
 	})
 
 	addr := env.Addr()
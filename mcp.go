@@ -0,0 +1,862 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/coalaura/openingrouter"
+)
+
+const MCPProtocolVersion = "2025-06-18"
+
+type MCPRequest struct {
+	JSONRPC string `json:"jsonrpc"`
+	ID      *int64 `json:"id,omitempty"`
+	Method  string `json:"method"`
+	Params  any    `json:"params,omitempty"`
+}
+
+type MCPMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *MCPError       `json:"error,omitempty"`
+}
+
+type MCPResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *MCPError       `json:"error,omitempty"`
+}
+
+type MCPError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type MCPToolInfo struct {
+	Name        string         `json:"name"`
+	Title       string         `json:"title"`
+	Description string         `json:"description"`
+	InputSchema map[string]any `json:"inputSchema"`
+}
+
+type MCPToolList struct {
+	Tools      []MCPToolInfo `json:"tools"`
+	NextCursor string        `json:"nextCursor"`
+}
+
+type MCPResource struct {
+	URI      string `json:"uri"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type MCPContent struct {
+	Type     string       `json:"type"`
+	Text     string       `json:"text"`
+	MimeType string       `json:"mimeType"`
+	URI      string       `json:"uri"`
+	Name     string       `json:"name"`
+	Resource *MCPResource `json:"resource"`
+}
+
+type MCPCallResult struct {
+	Content           []MCPContent `json:"content"`
+	StructuredContent any          `json:"structuredContent"`
+	IsError           bool         `json:"isError"`
+}
+
+// MCPTransport sends a json-rpc message to a server. Requests return their
+// response, notifications (without an id) return nil.
+type MCPTransport interface {
+	Send(ctx context.Context, request *MCPRequest) (*MCPMessage, error)
+	Closed() bool
+	Close() error
+}
+
+type MCPClient struct {
+	mx sync.Mutex
+
+	server    *EnvMCPServer
+	transport MCPTransport
+	next      atomic.Int64
+}
+
+type MCPTool struct {
+	client   *MCPClient
+	remote   string
+	schema   map[string]any
+	function openingrouter.ChatFunction
+}
+
+type MCPStdioTransport struct {
+	mx sync.Mutex
+
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	pending map[int64]chan *MCPMessage
+	closed  chan struct{}
+}
+
+// MCPLogWriter forwards the stderr output of stdio servers to the debug log.
+type MCPLogWriter struct {
+	name string
+}
+
+type MCPHTTPTransport struct {
+	mx sync.Mutex
+
+	client  *http.Client
+	url     string
+	headers map[string]string
+	session string
+	expired bool
+}
+
+var mcpClients []*MCPClient
+
+// returned when the server no longer knows our session, the client has to
+// initialize a new one
+var errMCPSessionExpired = errors.New("mcp session expired")
+
+func (e *MCPError) Error() string {
+	return fmt.Sprintf("mcp error (%d): %s", e.Code, e.Message)
+}
+
+// LoadMCPServers connects to all configured mcp servers and registers their
+// tools. Servers that fail to start are skipped.
+func LoadMCPServers() {
+	for i := range env.Tools.MCP {
+		server := &env.Tools.MCP[i]
+
+		client := &MCPClient{
+			server: server,
+		}
+
+		tools, err := client.ListTools(context.Background())
+		if err != nil {
+			log.Warnf("Unable to load mcp server %q: %v\n", server.Name, err)
+
+			client.Close()
+
+			continue
+		}
+
+		var registered int
+
+		for _, info := range tools {
+			tool := client.NewTool(info)
+
+			err = RegisterTool(tool)
+			if err != nil {
+				log.Warnf("Unable to register mcp tool %q: %v\n", info.Name, err)
+
+				continue
+			}
+
+			registered++
+		}
+
+		mcpClients = append(mcpClients, client)
+
+		log.Printf("Loaded %d tool(s) from mcp server %q\n", registered, server.Name)
+	}
+}
+
+func CloseMCPServers() {
+	for _, client := range mcpClients {
+		client.Close()
+	}
+}
+
+func (c *MCPClient) Close() {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	if c.transport != nil {
+		c.transport.Close()
+
+		c.transport = nil
+	}
+}
+
+// connect returns the current transport, (re)starting and initializing the
+// server if it is not running.
+func (c *MCPClient) connect(ctx context.Context) (MCPTransport, error) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	if c.transport != nil && !c.transport.Closed() {
+		return c.transport, nil
+	}
+
+	var (
+		transport MCPTransport
+		err       error
+	)
+
+	if c.server.Command != "" {
+		transport, err = StartMCPStdioTransport(c.server)
+	} else {
+		transport = NewMCPHTTPTransport(c.server)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(c.server.Timeout)*time.Second)
+	defer cancel()
+
+	var initialized struct {
+		ProtocolVersion string `json:"protocolVersion"`
+		ServerInfo      struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+		} `json:"serverInfo"`
+	}
+
+	err = c.request(ctx, transport, "initialize", map[string]any{
+		"protocolVersion": MCPProtocolVersion,
+		"capabilities":    map[string]any{},
+		"clientInfo": map[string]any{
+			"name":    "whiskr",
+			"version": Version,
+		},
+	}, &initialized)
+	if err != nil {
+		transport.Close()
+
+		return nil, fmt.Errorf("initialize: %v", err)
+	}
+
+	_, err = transport.Send(ctx, &MCPRequest{
+		JSONRPC: "2.0",
+		Method:  "notifications/initialized",
+	})
+	if err != nil {
+		transport.Close()
+
+		return nil, fmt.Errorf("initialized: %v", err)
+	}
+
+	debug("connected to mcp server %q (%s %s, protocol %s)", c.server.Name, initialized.ServerInfo.Name, initialized.ServerInfo.Version, initialized.ProtocolVersion)
+
+	c.transport = transport
+
+	return transport, nil
+}
+
+func (c *MCPClient) Call(ctx context.Context, method string, params, out any) error {
+	err := c.call(ctx, method, params, out)
+
+	if errors.Is(err, errMCPSessionExpired) {
+		debug("mcp session of %q expired, reconnecting", c.server.Name)
+
+		err = c.call(ctx, method, params, out)
+	}
+
+	return err
+}
+
+func (c *MCPClient) call(ctx context.Context, method string, params, out any) error {
+	transport, err := c.connect(ctx)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(c.server.Timeout)*time.Second)
+	defer cancel()
+
+	return c.request(ctx, transport, method, params, out)
+}
+
+func (c *MCPClient) request(ctx context.Context, transport MCPTransport, method string, params, out any) error {
+	id := c.next.Add(1)
+
+	response, err := transport.Send(ctx, &MCPRequest{
+		JSONRPC: "2.0",
+		ID:      &id,
+		Method:  method,
+		Params:  params,
+	})
+	if err != nil {
+		return err
+	}
+
+	if response.Error != nil {
+		return response.Error
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	return json.Unmarshal(response.Result, out)
+}
+
+func (c *MCPClient) ListTools(ctx context.Context) ([]MCPToolInfo, error) {
+	var (
+		tools  []MCPToolInfo
+		cursor string
+	)
+
+	for {
+		params := map[string]any{}
+
+		if cursor != "" {
+			params["cursor"] = cursor
+		}
+
+		var list MCPToolList
+
+		err := c.Call(ctx, "tools/list", params, &list)
+		if err != nil {
+			return nil, err
+		}
+
+		tools = append(tools, list.Tools...)
+
+		if list.NextCursor == "" || list.NextCursor == cursor {
+			break
+		}
+
+		cursor = list.NextCursor
+	}
+
+	return tools, nil
+}
+
+func (c *MCPClient) CallTool(ctx context.Context, name string, arguments map[string]any) (*MCPCallResult, error) {
+	var result MCPCallResult
+
+	err := c.Call(ctx, "tools/call", map[string]any{
+		"name":      name,
+		"arguments": arguments,
+	}, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (c *MCPClient) NewTool(info MCPToolInfo) *MCPTool {
+	schema := info.InputSchema
+	if schema == nil {
+		schema = map[string]any{
+			"type":       "object",
+			"properties": map[string]any{},
+		}
+	}
+
+	description := info.Description
+	if description == "" {
+		description = info.Title
+	}
+
+	return &MCPTool{
+		client: c,
+		remote: info.Name,
+		schema: schema,
+		function: openingrouter.ChatFunction{
+			Name:        MCPToolName(c.server.Name, info.Name),
+			Description: description,
+			Parameters:  schema,
+		},
+	}
+}
+
+// MCPToolName prefixes a tool with its server name, so tools of different
+// servers can't collide, and replaces characters models don't accept.
+func MCPToolName(server, tool string) string {
+	name := []byte(server + "_" + tool)
+
+	for i, b := range name {
+		if (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z') || (b >= '0' && b <= '9') || b == '_' || b == '-' {
+			continue
+		}
+
+		name[i] = '_'
+	}
+
+	if len(name) > 64 {
+		name = name[:64]
+	}
+
+	return string(name)
+}
+
+func (t *MCPTool) Name() string {
+	return t.function.Name
+}
+
+func (t *MCPTool) Definition() openingrouter.ChatFunction {
+	return t.function
+}
+
+func (t *MCPTool) Prompt() string {
+	return ToolPromptSnippet(t.function) + fmt.Sprintf("\n- Provided by the %q MCP server", t.client.server.Name)
+}
+
+func (t *MCPTool) Enabled(_ *ChatRequest, _ *Model) bool {
+	return true
+}
+
+func (t *MCPTool) Execute(ctx context.Context, call *ChatToolCall) error {
+	if strings.TrimSpace(call.Args) == "" {
+		call.Args = "{}"
+	}
+
+	// the server validates the arguments itself, they are only coerced where
+	// the schema expects a number or boolean
+	arguments, err := ParseSchemaArgs(call, t.schema)
+	if err != nil {
+		return err
+	}
+
+	result, err := t.client.CallTool(ctx, t.remote, arguments)
+	if err != nil {
+		call.Result = fmt.Sprintf("error: %v", err)
+
+		return nil
+	}
+
+	call.Result = result.String()
+
+	return nil
+}
+
+func (r *MCPCallResult) String() string {
+	parts := make([]string, 0, len(r.Content))
+
+	for _, content := range r.Content {
+		switch content.Type {
+		case "text":
+			parts = append(parts, content.Text)
+		case "image", "audio":
+			parts = append(parts, fmt.Sprintf("[%s: %s]", content.Type, content.MimeType))
+		case "resource":
+			if content.Resource == nil {
+				continue
+			}
+
+			if content.Resource.Text != "" {
+				parts = append(parts, content.Resource.Text)
+			} else {
+				parts = append(parts, fmt.Sprintf("[resource: %s]", content.Resource.URI))
+			}
+		case "resource_link":
+			parts = append(parts, fmt.Sprintf("[resource: %s] %s", content.URI, content.Name))
+		}
+	}
+
+	if len(parts) == 0 && r.StructuredContent != nil {
+		structured, err := json.Marshal(r.StructuredContent)
+		if err == nil {
+			parts = append(parts, string(structured))
+		}
+	}
+
+	result := strings.Join(parts, "\n\n")
+
+	if r.IsError {
+		return "error: " + result
+	}
+
+	if result == "" {
+		return "(no output)"
+	}
+
+	return result
+}
+
+func StartMCPStdioTransport(server *EnvMCPServer) (*MCPStdioTransport, error) {
+	cmd := exec.Command(server.Command, server.Args...)
+
+	cmd.Env = os.Environ()
+
+	for key, value := range server.Env {
+		cmd.Env = append(cmd.Env, key+"="+value)
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	cmd.Stderr = &MCPLogWriter{
+		name: server.Name,
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+
+	transport := &MCPStdioTransport{
+		cmd:     cmd,
+		stdin:   stdin,
+		pending: make(map[int64]chan *MCPMessage),
+		closed:  make(chan struct{}),
+	}
+
+	go transport.read(server.Name, stdout)
+
+	return transport, nil
+}
+
+func (w *MCPLogWriter) Write(p []byte) (int, error) {
+	for line := range strings.SplitSeq(strings.TrimSpace(string(p)), "\n") {
+		debug("mcp %s: %s", w.name, line)
+	}
+
+	return len(p), nil
+}
+
+func (t *MCPStdioTransport) read(name string, stdout io.Reader) {
+	defer func() {
+		t.cmd.Wait()
+
+		t.mx.Lock()
+		defer t.mx.Unlock()
+
+		close(t.closed)
+
+		debug("mcp server %q exited", name)
+	}()
+
+	reader := bufio.NewReader(stdout)
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			t.handle(line)
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (t *MCPStdioTransport) handle(line []byte) {
+	var message MCPMessage
+
+	err := json.Unmarshal(line, &message)
+	if err != nil {
+		debug("invalid mcp message: %v", err)
+
+		return
+	}
+
+	// requests or notifications sent by the server
+	if message.Method != "" {
+		if len(message.ID) == 0 {
+			return
+		}
+
+		response := MCPResponse{
+			JSONRPC: "2.0",
+			ID:      message.ID,
+		}
+
+		if message.Method == "ping" {
+			response.Result = map[string]any{}
+		} else {
+			response.Error = &MCPError{
+				Code:    -32601,
+				Message: "method not found",
+			}
+		}
+
+		t.write(response)
+
+		return
+	}
+
+	id, err := strconv.ParseInt(string(message.ID), 10, 64)
+	if err != nil {
+		return
+	}
+
+	t.mx.Lock()
+
+	ch, ok := t.pending[id]
+	delete(t.pending, id)
+
+	t.mx.Unlock()
+
+	if ok {
+		ch <- &message
+	}
+}
+
+func (t *MCPStdioTransport) write(message any) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	_, err = t.stdin.Write(append(data, '\n'))
+
+	return err
+}
+
+func (t *MCPStdioTransport) Send(ctx context.Context, request *MCPRequest) (*MCPMessage, error) {
+	if request.ID == nil {
+		return nil, t.write(request)
+	}
+
+	id := *request.ID
+	ch := make(chan *MCPMessage, 1)
+
+	t.mx.Lock()
+	t.pending[id] = ch
+	t.mx.Unlock()
+
+	err := t.write(request)
+	if err != nil {
+		t.mx.Lock()
+		delete(t.pending, id)
+		t.mx.Unlock()
+
+		return nil, err
+	}
+
+	select {
+	case response := <-ch:
+		return response, nil
+	case <-t.closed:
+		return nil, errors.New("mcp server exited")
+	case <-ctx.Done():
+		t.mx.Lock()
+		delete(t.pending, id)
+		t.mx.Unlock()
+
+		t.write(&MCPRequest{
+			JSONRPC: "2.0",
+			Method:  "notifications/cancelled",
+			Params: map[string]any{
+				"requestId": id,
+				"reason":    ctx.Err().Error(),
+			},
+		})
+
+		return nil, ctx.Err()
+	}
+}
+
+func (t *MCPStdioTransport) Closed() bool {
+	select {
+	case <-t.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func (t *MCPStdioTransport) Close() error {
+	t.stdin.Close()
+
+	select {
+	case <-t.closed:
+	case <-time.After(2 * time.Second):
+		t.cmd.Process.Kill()
+	}
+
+	return nil
+}
+
+func NewMCPHTTPTransport(server *EnvMCPServer) *MCPHTTPTransport {
+	return &MCPHTTPTransport{
+		client: &http.Client{
+			Timeout: time.Duration(server.Timeout) * time.Second,
+		},
+		url:     server.URL,
+		headers: server.Headers,
+	}
+}
+
+func (t *MCPHTTPTransport) Send(ctx context.Context, request *MCPRequest) (*MCPMessage, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", t.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	req.Header.Set("MCP-Protocol-Version", MCPProtocolVersion)
+
+	for key, value := range t.headers {
+		req.Header.Set(key, value)
+	}
+
+	t.mx.Lock()
+
+	session := t.session
+
+	if session != "" {
+		req.Header.Set("Mcp-Session-Id", session)
+	}
+
+	t.mx.Unlock()
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound && session != "" {
+		t.mx.Lock()
+
+		t.session = ""
+		t.expired = true
+
+		t.mx.Unlock()
+
+		return nil, errMCPSessionExpired
+	}
+
+	if resp.StatusCode >= 400 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+
+		return nil, fmt.Errorf("mcp server error (%d): %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	if session := resp.Header.Get("Mcp-Session-Id"); session != "" {
+		t.mx.Lock()
+		t.session = session
+		t.mx.Unlock()
+	}
+
+	if request.ID == nil {
+		return nil, nil
+	}
+
+	id := strconv.FormatInt(*request.ID, 10)
+
+	contentType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+
+	if contentType == "text/event-stream" {
+		return readMCPEvents(resp.Body, id)
+	}
+
+	var message MCPMessage
+
+	err = json.NewDecoder(resp.Body).Decode(&message)
+	if err != nil {
+		return nil, err
+	}
+
+	return &message, nil
+}
+
+// readMCPEvents reads server-sent events until the response to id arrives.
+func readMCPEvents(body io.Reader, id string) (*MCPMessage, error) {
+	var (
+		reader = bufio.NewReader(body)
+		data   bytes.Buffer
+	)
+
+	for {
+		line, err := reader.ReadBytes('\n')
+
+		line = bytes.TrimRight(line, "\r\n")
+
+		if len(line) == 0 && data.Len() > 0 {
+			var message MCPMessage
+
+			if json.Unmarshal(data.Bytes(), &message) == nil && message.Method == "" && string(message.ID) == id {
+				return &message, nil
+			}
+
+			data.Reset()
+		} else if payload, ok := bytes.CutPrefix(line, []byte("data:")); ok {
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+
+			data.Write(bytes.TrimPrefix(payload, []byte(" ")))
+		}
+
+		if err != nil {
+			if err == io.EOF {
+				return nil, errors.New("mcp stream ended without response")
+			}
+
+			return nil, err
+		}
+	}
+}
+
+func (t *MCPHTTPTransport) Closed() bool {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	return t.expired
+}
+
+func (t *MCPHTTPTransport) Close() error {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	if t.session == "" {
+		return nil
+	}
+
+	req, err := http.NewRequest("DELETE", t.url, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Mcp-Session-Id", t.session)
+
+	for key, value := range t.headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	resp.Body.Close()
+
+	t.session = ""
+
+	return nil
+}
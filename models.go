@@ -43,6 +43,7 @@ type Model struct {
 	Benchmarks  *ModelBenchmarks `json:"benchmarks,omitempty"`
 	Tags        []string         `json:"tags,omitempty"`
 	Author      string           `json:"author,omitempty"`
+	Backend     string           `json:"backend,omitempty"`
 
 	Reasoning       bool     `json:"reasoning"`
 	ReasoningLevels []string `json:"reasoning_levels,omitempty"`
@@ -76,39 +77,87 @@ func GetModel(name string) *Model {
 	return ModelMap[name]
 }
 
-func StartModelUpdateLoop() error {
-	if err := LoadModels(); err != nil {
-		return err
-	}
+func LoadModels() error {
+	log.Println("Refreshing model list...")
+
+	var (
+		entries = CachedModels()
+		stale   bool
+		failed  error
+		now     = time.Now().Unix()
+	)
 
-	go func() {
-		ticker := time.NewTicker(time.Duration(env.Settings.RefreshInterval) * time.Minute)
+	for _, backend := range Backends() {
+		models, audio, err := backend.ListModels(context.Background())
+		if err != nil {
+			// keep the last models of offline backends, only the
+			// primary backend has to be reachable
+			log.Warnf("Unable to load models of backend %q: %v\n", backend.Name, err)
 
-		for range ticker.C {
-			if err := LoadModels(); err != nil {
-				log.Warnln(err)
+			if backend.primary {
+				failed = err
 			}
+
+			stale = true
+
+			continue
 		}
-	}()
 
-	return nil
-}
+		qualify := func(m *Model) {
+			if backend.primary || m.Backend != "" {
+				return
+			}
 
-func LoadModels() error {
-	log.Println("Refreshing model list...")
+			m.Backend = backend.Name
+			m.Slug = backend.Qualify(m.Slug)
+			m.ID = GetModelShortID(m.Slug)
+		}
 
-	if env.IsOpenAI() {
-		return LoadOpenAIModels()
+		for _, m := range models {
+			qualify(m)
+		}
+
+		for _, m := range audio {
+			qualify(m)
+		}
+
+		entries[backend.Name] = &CatalogEntry{
+			Updated: now,
+			Primary: backend.primary,
+			Models:  models,
+			Audio:   audio,
+		}
 	}
 
-	base, err := OpenRouterListModels(context.Background())
+	newModelList, err := ApplyModelCatalog(entries, stale)
 	if err != nil {
 		return err
 	}
 
-	list, err := openingrouter.ListFrontendModels(context.Background())
+	log.Printf("Loaded %d models\n", len(newModelList))
+
+	if err := StoreModelCatalog(); err != nil {
+		log.Warnf("Unable to store model catalog: %v\n", err)
+	}
+
+	if settings != nil && settings.MigrateFavoriteModelIDs(newModelList) {
+		settings.ScheduleStore()
+	}
+
+	return failed
+}
+
+// LoadOpenRouterModels returns the chat and text-to-speech models of an
+// openrouter backend.
+func LoadOpenRouterModels(ctx context.Context, backend *EnvBackend) ([]*Model, []*Model, error) {
+	base, err := OpenRouterListModels(ctx, backend)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	list, err := openingrouter.ListFrontendModels(ctx)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	sort.Slice(list, func(i, j int) bool {
@@ -116,10 +165,8 @@ func LoadModels() error {
 	})
 
 	var (
-		newModelList  = make([]*Model, 0, len(list))
-		newAudioList  = make([]*Model, 0, len(list))
-		newModelMap   = make(map[string]*Model, len(list))
-		newModelIDMap = make(map[string]*Model, len(list))
+		models = make([]*Model, 0, len(list))
+		audio  = make([]*Model, 0, len(list))
 	)
 
 	for _, model := range list {
@@ -190,44 +237,15 @@ func LoadModels() error {
 		GetModelTags(model, m)
 
 		if canText {
-			if env.Models.filters != nil {
-				matched, err := env.Models.filters.Match(m)
-				if err != nil {
-					return err
-				}
-
-				if !matched {
-					continue
-				}
-			}
-
-			newModelList = append(newModelList, m)
-			newModelIDMap[m.ID] = m
+			models = append(models, m)
 		}
 
 		if canSpeak && len(m.Voices) > 0 {
-			newAudioList = append(newAudioList, m)
+			audio = append(audio, m)
 		}
-
-		newModelMap[slug] = m
-	}
-
-	log.Printf("Loaded %d models\n", len(newModelList))
-
-	modelMx.Lock()
-
-	AudioList = newAudioList
-	ModelList = newModelList
-	ModelMap = newModelMap
-	ModelIDMap = newModelIDMap
-
-	modelMx.Unlock()
-
-	if settings != nil && settings.MigrateFavoriteModelIDs(newModelList) {
-		settings.ScheduleStore()
 	}
 
-	return nil
+	return models, audio, nil
 }
 
 func GetModelShortID(slug string) string {
@@ -7,17 +7,15 @@ import (
 	"github.com/coalaura/openingrouter"
 )
 
-func LoadOpenAIModels() error {
-	base, err := OpenRouterListModels(context.Background())
+// LoadOpenAIModels returns the models of an openai-compatible backend, which
+// has no text-to-speech models.
+func LoadOpenAIModels(ctx context.Context, backend *EnvBackend) ([]*Model, []*Model, error) {
+	base, err := OpenRouterListModels(ctx, backend)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
-	var (
-		newModelList  = make([]*Model, 0, len(base))
-		newModelMap   = make(map[string]*Model, len(base))
-		newModelIDMap = make(map[string]*Model, len(base))
-	)
+	models := make([]*Model, 0, len(base))
 
 	for _, model := range base {
 		m := &Model{
@@ -43,38 +41,15 @@ func LoadOpenAIModels() error {
 
 		SetOpenAITags(model, m)
 
-		if env.Models.filters != nil {
-			matched, err := env.Models.filters.Match(m)
-			if err != nil {
-				return err
-			}
-
-			if !matched {
-				continue
-			}
-		}
-
-		newModelList = append(newModelList, m)
-		newModelMap[model.ID] = m
-		newModelIDMap[m.ID] = m
+		models = append(models, m)
 	}
 
-	log.Printf("Loaded %d models\n", len(newModelList))
-
-	modelMx.Lock()
-
-	AudioList = nil
-	ModelList = newModelList
-	ModelMap = newModelMap
-	ModelIDMap = newModelIDMap
-
-	modelMx.Unlock()
-
-	if settings != nil && settings.MigrateFavoriteModelIDs(newModelList) {
-		settings.ScheduleStore()
-	}
+	// map iteration order is random
+	sort.Slice(models, func(i, j int) bool {
+		return models[i].Slug < models[j].Slug
+	})
 
-	return nil
+	return models, nil, nil
 }
 
 func SetOpenAITags(model openingrouter.Model, m *Model) {
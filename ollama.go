@@ -0,0 +1,412 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coalaura/openingrouter"
+)
+
+type OllamaTags struct {
+	Models []OllamaTag `json:"models"`
+}
+
+type OllamaTag struct {
+	Name       string        `json:"name"`
+	ModifiedAt time.Time     `json:"modified_at"`
+	Details    OllamaDetails `json:"details"`
+}
+
+type OllamaDetails struct {
+	Family            string `json:"family"`
+	ParameterSize     string `json:"parameter_size"`
+	QuantizationLevel string `json:"quantization_level"`
+}
+
+type OllamaShow struct {
+	Capabilities []string       `json:"capabilities"`
+	ModelInfo    map[string]any `json:"model_info"`
+}
+
+type OllamaRequest struct {
+	Model     string          `json:"model"`
+	Messages  []OllamaMessage `json:"messages"`
+	Tools     []OllamaTool    `json:"tools,omitempty"`
+	Format    any             `json:"format,omitempty"`
+	Options   map[string]any  `json:"options,omitempty"`
+	Think     any             `json:"think,omitempty"`
+	KeepAlive any             `json:"keep_alive,omitempty"`
+	Stream    bool            `json:"stream"`
+}
+
+type OllamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	Thinking  string           `json:"thinking,omitempty"`
+	Images    []string         `json:"images,omitempty"`
+	ToolCalls []OllamaToolCall `json:"tool_calls,omitempty"`
+	ToolName  string           `json:"tool_name,omitempty"`
+}
+
+type OllamaTool struct {
+	Type     string                     `json:"type"`
+	Function openingrouter.ChatFunction `json:"function"`
+}
+
+type OllamaToolCall struct {
+	Function OllamaToolCallFunction `json:"function"`
+}
+
+type OllamaToolCallFunction struct {
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+type OllamaChunk struct {
+	Model           string        `json:"model"`
+	Message         OllamaMessage `json:"message"`
+	Done            bool          `json:"done"`
+	DoneReason      string        `json:"done_reason"`
+	PromptEvalCount int           `json:"prompt_eval_count"`
+	EvalCount       int           `json:"eval_count"`
+	Error           string        `json:"error"`
+}
+
+// OllamaStream reads the newline delimited json chunks of /api/chat.
+type OllamaStream struct {
+	body  io.ReadCloser
+	rd    *bufio.Reader
+	calls int
+}
+
+// LoadOllamaModels returns the locally available models of an ollama backend,
+// deriving their capabilities and context from /api/show.
+func LoadOllamaModels(ctx context.Context, backend *EnvBackend) ([]*Model, []*Model, error) {
+	var tags OllamaTags
+
+	_, err := BackendRequest(ctx, backend, nil, "GET", "/api/tags", nil, &tags)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	models := make([]*Model, 0, len(tags.Models))
+
+	for _, tag := range tags.Models {
+		var show OllamaShow
+
+		_, err := BackendRequest(ctx, backend, nil, "POST", "/api/show", map[string]any{
+			"model": tag.Name,
+		}, &show)
+		if err != nil {
+			log.Warnf("Unable to show ollama model %q: %v\n", tag.Name, err)
+
+			continue
+		}
+
+		m := &Model{
+			ID:          GetModelShortID(tag.Name),
+			Slug:        tag.Name,
+			Created:     tag.ModifiedAt.Unix(),
+			Name:        tag.Name,
+			Description: ollamaDescription(tag.Details),
+			Author:      tag.Details.Family,
+
+			Context: ModelContext{
+				Total: ollamaContextLength(show.ModelInfo, backend.NumCtx),
+			},
+
+			JSON: true,
+		}
+
+		SetOllamaTags(tag, show, m)
+
+		// embedding-only models can not chat
+		if !m.Text {
+			continue
+		}
+
+		models = append(models, m)
+	}
+
+	sort.Slice(models, func(i, j int) bool {
+		return models[i].Slug < models[j].Slug
+	})
+
+	return models, nil, nil
+}
+
+func SetOllamaTags(tag OllamaTag, show OllamaShow, m *Model) {
+	for _, capability := range show.Capabilities {
+		switch capability {
+		case "completion":
+			m.Text = true
+		case "vision":
+			m.Vision = true
+
+			m.Tags = append(m.Tags, "vision")
+		case "tools":
+			m.Tools = true
+
+			m.Tags = append(m.Tags, "tools")
+		case "thinking":
+			m.Reasoning = true
+
+			// gpt-oss only thinks in levels, others can just be toggled
+			if ollamaThinkLevels(tag.Name) {
+				m.ReasoningLevels = []string{"low", "medium", "high"}
+			}
+
+			m.Tags = append(m.Tags, "reasoning")
+		}
+	}
+
+	m.Tags = append(m.Tags, "json", "free")
+
+	sort.Strings(m.Tags)
+}
+
+func ollamaDescription(details OllamaDetails) string {
+	parts := make([]string, 0, 3)
+
+	for _, part := range []string{details.Family, details.ParameterSize, details.QuantizationLevel} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+
+	if len(parts) == 0 {
+		return "Local ollama model."
+	}
+
+	return fmt.Sprintf("Local ollama model (%s).", strings.Join(parts, ", "))
+}
+
+func ollamaContextLength(info map[string]any, limit int) int {
+	var length int
+
+	if arch, ok := info["general.architecture"].(string); ok {
+		if value, ok := info[arch+".context_length"].(float64); ok {
+			length = int(value)
+		}
+	}
+
+	// ollama only uses as much context as num_ctx allows
+	if limit > 0 && (length == 0 || limit < length) {
+		return limit
+	}
+
+	return length
+}
+
+func ollamaThinkLevels(model string) bool {
+	return strings.HasPrefix(model, "gpt-oss")
+}
+
+// ollamaKeepAlive returns keep_alive as a number of seconds or a duration.
+func ollamaKeepAlive(value string) any {
+	if value == "" {
+		return nil
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return seconds
+	}
+
+	return value
+}
+
+func NewOllamaRequest(ctx context.Context, request *openingrouter.ChatCompletionRequest, backend *EnvBackend) (*OllamaRequest, error) {
+	ollama := &OllamaRequest{
+		Model:     request.Model,
+		Options:   make(map[string]any),
+		KeepAlive: ollamaKeepAlive(backend.KeepAlive),
+		Stream:    true,
+	}
+
+	// ollama references tool results by name, not id
+	names := make(map[string]string)
+
+	for _, message := range request.Messages {
+		converted := OllamaMessage{
+			Role: string(message.Role),
+		}
+
+		if len(message.Content.Parts) == 0 {
+			converted.Content = message.Content.Text
+		} else {
+			var text []string
+
+			for _, part := range message.Content.Parts {
+				if part.ImageURL != nil {
+					_, data, err := LoadImage(ctx, part.ImageURL.URL)
+					if err != nil {
+						return nil, err
+					}
+
+					converted.Images = append(converted.Images, base64.StdEncoding.EncodeToString(data))
+
+					continue
+				}
+
+				if part.Text != "" {
+					text = append(text, part.Text)
+				}
+			}
+
+			converted.Content = strings.Join(text, "\n\n")
+		}
+
+		for _, call := range message.ToolCalls {
+			arguments := json.RawMessage(call.Function.Arguments)
+
+			if !json.Valid(arguments) {
+				arguments = json.RawMessage("{}")
+			}
+
+			converted.ToolCalls = append(converted.ToolCalls, OllamaToolCall{
+				Function: OllamaToolCallFunction{
+					Name:      call.Function.Name,
+					Arguments: arguments,
+				},
+			})
+
+			names[call.ID] = call.Function.Name
+		}
+
+		if message.ToolCallID != "" {
+			converted.ToolName = names[message.ToolCallID]
+		}
+
+		ollama.Messages = append(ollama.Messages, converted)
+	}
+
+	for _, function := range RequestFunctions(request) {
+		ollama.Tools = append(ollama.Tools, OllamaTool{
+			Type:     "function",
+			Function: function,
+		})
+	}
+
+	if format := request.ResponseFormat; format != nil {
+		if format.JSONSchema != nil {
+			ollama.Format = format.JSONSchema.Schema
+		} else {
+			ollama.Format = "json"
+		}
+	}
+
+	if request.Temperature != nil {
+		ollama.Options["temperature"] = *request.Temperature
+	}
+
+	if request.MaxTokens != nil {
+		ollama.Options["num_predict"] = *request.MaxTokens
+	}
+
+	if backend.NumCtx > 0 {
+		ollama.Options["num_ctx"] = backend.NumCtx
+	}
+
+	if request.Reasoning != nil {
+		switch effort := string(request.Reasoning.Effort); {
+		case effort == "none":
+			ollama.Think = false
+		case ollamaThinkLevels(request.Model) && effort != "":
+			ollama.Think = effort
+		default:
+			ollama.Think = true
+		}
+	}
+
+	return ollama, nil
+}
+
+func OllamaStartStream(ctx context.Context, request *openingrouter.ChatCompletionRequest, backend *EnvBackend, proxy *EnvProxy) (CompletionStream, error) {
+	ollama, err := NewOllamaRequest(ctx, request, backend)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := BackendRequest(ctx, backend, proxy, "POST", "/api/chat", ollama, nil)
+	if err != nil {
+		log.Warnln(err)
+
+		return nil, err
+	}
+
+	return &OllamaStream{
+		body: resp.Body,
+		rd:   bufio.NewReader(resp.Body),
+	}, nil
+}
+
+func (s *OllamaStream) Recv() (*CompletionDelta, error) {
+	for {
+		line, err := s.rd.ReadBytes('\n')
+		if err != nil && (!errors.Is(err, io.EOF) || len(bytes.TrimSpace(line)) == 0) {
+			return nil, err
+		}
+
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		var chunk OllamaChunk
+
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			return nil, err
+		}
+
+		if chunk.Error != "" {
+			return nil, errors.New(chunk.Error)
+		}
+
+		delta := &CompletionDelta{
+			Content:   chunk.Message.Content,
+			Reasoning: chunk.Message.Thinking,
+		}
+
+		if delta.Reasoning != "" {
+			delta.ReasoningType = ReasoningTypeText
+		}
+
+		// tool calls arrive complete and without ids
+		for _, call := range chunk.Message.ToolCalls {
+			delta.ToolCalls = append(delta.ToolCalls, CompletionToolCall{
+				Index: s.calls,
+				Name:  call.Function.Name,
+				Args:  string(call.Function.Arguments),
+			})
+
+			s.calls++
+		}
+
+		if chunk.Done {
+			delta.Finish = openingrouter.ChatFinishReason(chunk.DoneReason)
+
+			debug("usage chunk: model=%q provider=%q prompt=%d completion=%d", chunk.Model, APIOllama, chunk.PromptEvalCount, chunk.EvalCount)
+
+			delta.Usage = CreateStatistics(chunk.Model, APIOllama, &openingrouter.ChatUsage{
+				PromptTokens:     chunk.PromptEvalCount,
+				CompletionTokens: chunk.EvalCount,
+			})
+		}
+
+		return delta, nil
+	}
+}
+
+func (s *OllamaStream) Close() error {
+	return s.body.Close()
+}
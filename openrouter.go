@@ -1,14 +1,33 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/coalaura/openingrouter"
 )
 
+type OpenRouterGeneration struct {
+	ID                     string   `json:"id"`
+	Model                  string   `json:"model"`
+	ProviderName           string   `json:"provider_name"`
+	TotalCost              float64  `json:"total_cost"`
+	UpstreamInferenceCost  *float64 `json:"upstream_inference_cost"`
+	IsBYOK                 bool     `json:"is_byok"`
+	NativeTokensPrompt     int      `json:"native_tokens_prompt"`
+	NativeTokensCompletion int      `json:"native_tokens_completion"`
+	NativeTokensReasoning  int      `json:"native_tokens_reasoning"`
+	NativeTokensCached     int      `json:"native_tokens_cached"`
+}
+
 // NewHttpClient builds the shared http client honoring the proxy and timeout config.
 func NewHttpClient(proxy *EnvProxy) *http.Client {
 	transport := http.DefaultTransport
@@ -22,40 +41,40 @@ func NewHttpClient(proxy *EnvProxy) *http.Client {
 	}
 }
 
-// OpenRouterClient returns the openrouter client used for openrouter-only endpoints.
-func OpenRouterClient(proxy *EnvProxy) *openingrouter.Client {
+// OpenRouterClient returns the openrouter client of a backend, also used for openrouter-only endpoints.
+func OpenRouterClient(backend *EnvBackend, proxy *EnvProxy) *openingrouter.Client {
 	options := []openingrouter.Option{
 		openingrouter.WithTitle("Whiskr"),
 		openingrouter.WithReferer("https://github.com/coalaura/whiskr"),
-		openingrouter.WithClient(NewHttpClient(proxy)),
-		openingrouter.WithBase(env.LLM.BaseURL),
+		openingrouter.WithClient(NewHttpClient(backend.ResolveProxy(proxy))),
+		openingrouter.WithBase(backend.BaseURL),
 	}
 
-	return openingrouter.NewClient(env.Tokens.OpenRouter, options...)
+	return openingrouter.NewClient(backend.Token, options...)
 }
 
-// OpenAIClient returns the openai-compatible client for the configured token and base url.
-func OpenAIClient(proxy *EnvProxy) *openingrouter.OpenAIClient {
+// OpenAIClient returns the openai-compatible client for the token and base url of a backend.
+func OpenAIClient(backend *EnvBackend, proxy *EnvProxy) *openingrouter.OpenAIClient {
 	options := []openingrouter.OpenAIOption{
-		openingrouter.WithOpenAIHTTPClient(NewHttpClient(proxy)),
-		openingrouter.WithOpenAIBase(env.LLM.BaseURL),
+		openingrouter.WithOpenAIHTTPClient(NewHttpClient(backend.ResolveProxy(proxy))),
+		openingrouter.WithOpenAIBase(backend.BaseURL),
 	}
 
-	return openingrouter.NewOpenAIClient(env.Tokens.OpenAI, options...)
+	return openingrouter.NewOpenAIClient(backend.Token, options...)
 }
 
-// NewCompatibleClient returns the chat client for the configured llm api, targeting either
+// NewCompatibleClient returns the chat client for a backend, targeting either
 // openrouter or an openai-compatible endpoint.
-func NewCompatibleClient(proxy *EnvProxy) openingrouter.OpenAICompatibleClient {
-	if env.IsOpenAI() {
-		return OpenAIClient(proxy)
+func NewCompatibleClient(backend *EnvBackend, proxy *EnvProxy) openingrouter.OpenAICompatibleClient {
+	if backend.Type == APIOpenAI || backend.Type == APIResponses {
+		return OpenAIClient(backend, proxy)
 	}
 
-	return OpenRouterClient(proxy)
+	return OpenRouterClient(backend, proxy)
 }
 
-func OpenRouterStartStream(ctx context.Context, request openingrouter.ChatCompletionRequest, proxy *EnvProxy) (openingrouter.OpenrouterStream[openingrouter.ChatStreamChunk], error) {
-	client := NewCompatibleClient(proxy)
+func OpenRouterStartStream(ctx context.Context, request openingrouter.ChatCompletionRequest, backend *EnvBackend, proxy *EnvProxy) (openingrouter.OpenrouterStream[openingrouter.ChatStreamChunk], error) {
+	client := NewCompatibleClient(backend, proxy)
 
 	stream, err := client.CreateChatCompletionStream(ctx, request)
 	if err != nil {
@@ -67,8 +86,8 @@ func OpenRouterStartStream(ctx context.Context, request openingrouter.ChatComple
 	return stream, nil
 }
 
-func OpenRouterRun(ctx context.Context, request openingrouter.ChatCompletionRequest, proxy *EnvProxy) (openingrouter.ChatCompletionResponse, error) {
-	client := NewCompatibleClient(proxy)
+func OpenRouterRun(ctx context.Context, request openingrouter.ChatCompletionRequest, backend *EnvBackend, proxy *EnvProxy) (openingrouter.ChatCompletionResponse, error) {
+	client := NewCompatibleClient(backend, proxy)
 
 	response, err := client.CreateChatCompletion(ctx, request)
 	if err != nil {
@@ -84,8 +103,8 @@ func OpenRouterRun(ctx context.Context, request openingrouter.ChatCompletionRequ
 	return *response, nil
 }
 
-func OpenRouterListModels(ctx context.Context) (map[string]openingrouter.Model, error) {
-	client := NewCompatibleClient(nil)
+func OpenRouterListModels(ctx context.Context, backend *EnvBackend) (map[string]openingrouter.Model, error) {
+	client := NewCompatibleClient(backend, nil)
 
 	models, err := client.ListModels(ctx, nil)
 	if err != nil {
@@ -101,6 +120,138 @@ func OpenRouterListModels(ctx context.Context) (map[string]openingrouter.Model,
 	return mp, nil
 }
 
+// OpenRouterGetGeneration fetches the stats of a (possibly aborted) generation.
+func OpenRouterGetGeneration(ctx context.Context, id string, backend *EnvBackend, proxy *EnvProxy) (*OpenRouterGeneration, error) {
+	endpoint := fmt.Sprintf("%s/generation?id=%s", strings.TrimRight(backend.BaseURL, "/"), url.QueryEscape(id))
+
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+backend.Token)
+
+	resp, err := NewHttpClient(backend.ResolveProxy(proxy)).Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+
+		return nil, fmt.Errorf("openrouter api error (%d): %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var response struct {
+		Data OpenRouterGeneration `json:"data"`
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&response)
+	if err != nil {
+		return nil, err
+	}
+
+	return &response.Data, nil
+}
+
+func (g *OpenRouterGeneration) Statistics() *Statistics {
+	statistics := Statistics{
+		Provider:        g.ProviderName,
+		Model:           g.Model,
+		Cost:            g.TotalCost,
+		InputTokens:     g.NativeTokensPrompt,
+		OutputTokens:    g.NativeTokensCompletion,
+		ReasoningTokens: g.NativeTokensReasoning,
+		CachedTokens:    g.NativeTokensCached,
+	}
+
+	if g.IsBYOK {
+		statistics.Cost += Nullable(g.UpstreamInferenceCost, 0)
+	}
+
+	return &statistics
+}
+
+// OpenRouterEmbeddings creates embeddings for all inputs using the backend owning the model.
+func OpenRouterEmbeddings(ctx context.Context, model string, inputs []string) ([][]float32, error) {
+	backend, model := SplitModelSlug(model)
+
+	if !backend.HasEmbeddings() {
+		return nil, fmt.Errorf("backend %q has no embeddings endpoint", backend.Name)
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"model": model,
+		"input": inputs,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := fmt.Sprintf("%s/embeddings", strings.TrimRight(backend.BaseURL, "/"))
+
+	// ollama serves the openai-compatible endpoints under /v1
+	if backend.Type == APIOllama {
+		endpoint = backend.Endpoint("/v1/embeddings")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	if backend.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+backend.Token)
+	}
+
+	resp, err := NewHttpClient(backend.ResolveProxy(nil)).Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+
+		return nil, fmt.Errorf("embeddings api error (%d): %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var response struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&response)
+	if err != nil {
+		return nil, err
+	}
+
+	embeddings := make([][]float32, len(inputs))
+
+	for _, entry := range response.Data {
+		if entry.Index < 0 || entry.Index >= len(inputs) {
+			return nil, fmt.Errorf("invalid embedding index %d", entry.Index)
+		}
+
+		embeddings[entry.Index] = entry.Embedding
+	}
+
+	for i, embedding := range embeddings {
+		if len(embedding) == 0 {
+			return nil, fmt.Errorf("missing embedding %d", i)
+		}
+	}
+
+	return embeddings, nil
+}
+
 func streamProvider(meta *openingrouter.OpenRouterMetadata) string {
 	if meta == nil {
 		return ""
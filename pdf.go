@@ -0,0 +1,617 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"io"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+type pdfObject struct {
+	dict   []byte
+	stream []byte
+}
+
+// pdfCMap maps character codes of a font to unicode text.
+type pdfCMap struct {
+	width int
+	codes map[uint32]string
+}
+
+// pdfBudget is what is left of the limits of a single document. Compressed
+// streams and character ranges expand a small file into a lot of data, so
+// the limits apply across all streams instead of per stream.
+type pdfBudget struct {
+	decoded int
+	codes   int
+	text    int
+}
+
+const (
+	PDFMaxDecoded = 64 * 1024 * 1024
+	PDFMaxCodes   = 1 << 20
+	PDFMaxText    = 8 * 1024 * 1024
+)
+
+var (
+	pdfObjectRgx   = regexp.MustCompile(`(\d+)\s+(\d+)\s+obj\b`)
+	pdfToUnicodeRx = regexp.MustCompile(`/ToUnicode\s+(\d+)\s+\d+\s+R`)
+	pdfFontRefRgx  = regexp.MustCompile(`/([^\s/<>\[\]()]+)\s+(\d+)\s+\d+\s+R`)
+	pdfFontDictRgx = regexp.MustCompile(`/Font\s*<<([^>]*)>>`)
+	pdfFontResRgx  = regexp.MustCompile(`/Font\s+(\d+)\s+\d+\s+R`)
+	pdfCodespace   = regexp.MustCompile(`begincodespacerange\s*<([0-9A-Fa-f]+)>`)
+	pdfBfCharRgx   = regexp.MustCompile(`(?s)beginbfchar(.*?)endbfchar`)
+	pdfBfRangeRgx  = regexp.MustCompile(`(?s)beginbfrange(.*?)endbfrange`)
+	pdfHexRgx      = regexp.MustCompile(`<([0-9A-Fa-f\s]*)>`)
+	pdfRangeRgx    = regexp.MustCompile(`<([0-9A-Fa-f]+)>\s*<([0-9A-Fa-f]+)>\s*(<[0-9A-Fa-f\s]*>|\[[^\]]*\])`)
+)
+
+// ExtractPDFText extracts the plain text of a pdf. It only supports the
+// common cases (uncompressed or flate encoded content streams, standard
+// and ToUnicode mapped fonts) and returns an error if no text was found.
+func ExtractPDFText(data []byte) (string, error) {
+	if !bytes.HasPrefix(bytes.TrimSpace(data[:min(len(data), 1024)]), []byte("%PDF")) {
+		return "", errors.New("not a pdf document")
+	}
+
+	budget := &pdfBudget{
+		decoded: PDFMaxDecoded,
+		codes:   PDFMaxCodes,
+		text:    PDFMaxText,
+	}
+
+	objects := parsePDFObjects(data, budget)
+
+	// font name -> cmap, font names are global here which is good enough for most documents
+	fonts := make(map[string]*pdfCMap)
+
+	fontRefs := func(dict []byte) [][][]byte {
+		var refs [][][]byte
+
+		for _, match := range pdfFontDictRgx.FindAllSubmatch(dict, -1) {
+			refs = append(refs, pdfFontRefRgx.FindAllSubmatch(match[1], -1)...)
+		}
+
+		for _, match := range pdfFontResRgx.FindAllSubmatch(dict, -1) {
+			if font, ok := objects[string(match[1])]; ok {
+				refs = append(refs, pdfFontRefRgx.FindAllSubmatch(font.dict, -1)...)
+			}
+		}
+
+		return refs
+	}
+
+	for _, object := range objects {
+		for _, ref := range fontRefs(object.dict) {
+			font, ok := objects[string(ref[2])]
+			if !ok {
+				continue
+			}
+
+			match := pdfToUnicodeRx.FindSubmatch(font.dict)
+			if match == nil {
+				continue
+			}
+
+			cmap, ok := objects[string(match[1])]
+			if !ok || cmap.stream == nil {
+				continue
+			}
+
+			fonts[string(ref[1])] = parsePDFCMap(cmap.stream, budget)
+		}
+	}
+
+	var buf strings.Builder
+
+	for _, id := range sortedPDFObjects(objects) {
+		object := objects[id]
+
+		if object.stream == nil || bytes.Contains(object.dict, []byte("/Subtype")) || bytes.Contains(object.dict, []byte("/Type")) {
+			continue
+		}
+
+		if !bytes.Contains(object.stream, []byte("BT")) {
+			continue
+		}
+
+		text := extractPDFContent(object.stream, fonts, budget.text)
+
+		if strings.TrimSpace(text) != "" {
+			buf.WriteString(text)
+			buf.WriteString("\n\n")
+
+			budget.text -= len(text) + 2
+		}
+
+		if budget.text <= 0 {
+			break
+		}
+	}
+
+	text := strings.TrimSpace(htmlNewlinesRgx.ReplaceAllString(buf.String(), "\n\n"))
+	if text == "" {
+		return "", errors.New("no extractable text in pdf")
+	}
+
+	return text, nil
+}
+
+func parsePDFObjects(data []byte, budget *pdfBudget) map[string]*pdfObject {
+	objects := make(map[string]*pdfObject)
+
+	for _, loc := range pdfObjectRgx.FindAllSubmatchIndex(data, -1) {
+		id := string(data[loc[2]:loc[3]])
+		body := data[loc[1]:]
+
+		if end := bytes.Index(body, []byte("endobj")); end != -1 {
+			body = body[:end]
+		}
+
+		object := &pdfObject{
+			dict: body,
+		}
+
+		if start := bytes.Index(body, []byte("stream")); start != -1 {
+			object.dict = body[:start]
+
+			raw := body[start+6:]
+
+			raw = bytes.TrimPrefix(raw, []byte("\r"))
+			raw = bytes.TrimPrefix(raw, []byte("\n"))
+
+			if end := bytes.LastIndex(raw, []byte("endstream")); end != -1 {
+				raw = raw[:end]
+			}
+
+			object.stream = decodePDFStream(object.dict, raw, budget)
+		}
+
+		objects[id] = object
+	}
+
+	return objects
+}
+
+func sortedPDFObjects(objects map[string]*pdfObject) []string {
+	ids := make([]string, 0, len(objects))
+
+	for id := range objects {
+		ids = append(ids, id)
+	}
+
+	slices.SortFunc(ids, func(a, b string) int {
+		x, _ := strconv.Atoi(a)
+		y, _ := strconv.Atoi(b)
+
+		return x - y
+	})
+
+	return ids
+}
+
+func decodePDFStream(dict, raw []byte, budget *pdfBudget) []byte {
+	if !bytes.Contains(dict, []byte("/Filter")) {
+		return raw
+	}
+
+	if !bytes.Contains(dict, []byte("/FlateDecode")) {
+		// other filters (dct, jbig2, ...) are images or unsupported
+		return nil
+	}
+
+	if budget.decoded <= 0 {
+		return nil
+	}
+
+	reader, err := zlib.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		return nil
+	}
+
+	defer reader.Close()
+
+	// truncated streams still contain useful text, so ignore read errors
+	decoded, _ := io.ReadAll(io.LimitReader(reader, int64(budget.decoded)))
+
+	budget.decoded -= len(decoded)
+
+	return decoded
+}
+
+func parsePDFCMap(data []byte, budget *pdfBudget) *pdfCMap {
+	cmap := &pdfCMap{
+		width: 2,
+		codes: make(map[uint32]string),
+	}
+
+	if match := pdfCodespace.FindSubmatch(data); match != nil {
+		cmap.width = max(len(match[1])/2, 1)
+	}
+
+	for _, block := range pdfBfCharRgx.FindAllSubmatch(data, -1) {
+		pairs := pdfHexRgx.FindAllSubmatch(block[1], -1)
+
+		for i := 0; i+1 < len(pairs) && budget.codes > 0; i += 2 {
+			code := parsePDFHexCode(pairs[i][1])
+
+			budget.codes--
+
+			cmap.codes[code] = decodeUTF16Hex(pairs[i+1][1])
+		}
+	}
+
+	for _, block := range pdfBfRangeRgx.FindAllSubmatch(data, -1) {
+		for _, match := range pdfRangeRgx.FindAllSubmatch(block[1], -1) {
+			start := parsePDFHexCode(match[1])
+			end := parsePDFHexCode(match[2])
+
+			if end < start || end-start > 0xffff {
+				continue
+			}
+
+			target := match[3]
+
+			if target[0] == '[' {
+				for i, entry := range pdfHexRgx.FindAllSubmatch(target, -1) {
+					if budget.codes <= 0 {
+						break
+					}
+
+					cmap.codes[start+uint32(i)] = decodeUTF16Hex(entry[1])
+
+					budget.codes--
+				}
+
+				continue
+			}
+
+			base := []rune(decodeUTF16Hex(target[1 : len(target)-1]))
+			if len(base) == 0 {
+				continue
+			}
+
+			for code := start; code <= end && budget.codes > 0; code++ {
+				budget.codes--
+
+				runes := append([]rune{}, base...)
+
+				runes[len(runes)-1] += rune(code - start)
+
+				cmap.codes[code] = string(runes)
+			}
+		}
+	}
+
+	return cmap
+}
+
+func parsePDFHexCode(hex []byte) uint32 {
+	code, _ := strconv.ParseUint(string(hex), 16, 32)
+
+	return uint32(code)
+}
+
+func decodeUTF16Hex(hex []byte) string {
+	hex = bytes.Join(bytes.Fields(hex), nil)
+
+	units := make([]uint16, 0, len(hex)/4)
+
+	for i := 0; i+4 <= len(hex); i += 4 {
+		unit, err := strconv.ParseUint(string(hex[i:i+4]), 16, 16)
+		if err != nil {
+			return ""
+		}
+
+		units = append(units, uint16(unit))
+	}
+
+	return string(utf16.Decode(units))
+}
+
+func (c *pdfCMap) decode(raw []byte, limit int) string {
+	var buf strings.Builder
+
+	for i := 0; i+c.width <= len(raw) && buf.Len() < limit; i += c.width {
+		var code uint32
+
+		for _, b := range raw[i : i+c.width] {
+			code = code<<8 | uint32(b)
+		}
+
+		if text, ok := c.codes[code]; ok {
+			buf.WriteString(text)
+		}
+	}
+
+	return buf.String()
+}
+
+// extractPDFContent interprets the text operators of a content stream,
+// stopping once limit bytes of text were extracted.
+func extractPDFContent(data []byte, fonts map[string]*pdfCMap, limit int) string {
+	var (
+		buf      strings.Builder
+		operands [][]byte
+		strs     [][]byte
+		font     *pdfCMap
+		lastY    float64
+		hasY     bool
+	)
+
+	decode := func(raw []byte) string {
+		if font != nil {
+			return font.decode(raw, limit-buf.Len())
+		}
+
+		return decodePDFDocString(raw)
+	}
+
+	write := func(text string) {
+		if left := limit - buf.Len(); len(text) > left {
+			text = text[:max(left, 0)]
+		}
+
+		buf.WriteString(text)
+	}
+
+	for i := 0; i < len(data) && buf.Len() < limit; {
+		b := data[i]
+
+		switch {
+		case b == '%':
+			for i < len(data) && data[i] != '\n' && data[i] != '\r' {
+				i++
+			}
+		case isPDFSpace(b):
+			i++
+		case b == '(':
+			str, next := readPDFLiteral(data, i)
+
+			strs = append(strs, str)
+			operands = append(operands, nil)
+
+			i = next
+		case b == '<' && i+1 < len(data) && data[i+1] == '<':
+			end := bytes.Index(data[i:], []byte(">>"))
+			if end == -1 {
+				return buf.String()
+			}
+
+			i += end + 2
+		case b == '<':
+			end := bytes.IndexByte(data[i:], '>')
+			if end == -1 {
+				return buf.String()
+			}
+
+			strs = append(strs, decodePDFHex(data[i+1:i+end]))
+			operands = append(operands, nil)
+
+			i += end + 1
+		case b == '[' || b == ']':
+			// TJ arrays, kerning numbers are ignored apart from large gaps
+			if b == '[' {
+				strs = strs[:0]
+			}
+
+			i++
+		default:
+			start := i
+
+			for i < len(data) && !isPDFSpace(data[i]) && !strings.ContainsRune("()<>[]/%", rune(data[i])) {
+				i++
+			}
+
+			if i == start {
+				// names
+				if data[i] == '/' {
+					i++
+
+					for i < len(data) && !isPDFSpace(data[i]) && !strings.ContainsRune("()<>[]/%", rune(data[i])) {
+						i++
+					}
+
+					operands = append(operands, data[start:i])
+
+					continue
+				}
+
+				i++
+
+				continue
+			}
+
+			token := data[start:i]
+
+			if (token[0] >= '0' && token[0] <= '9') || token[0] == '-' || token[0] == '.' || token[0] == '+' {
+				operands = append(operands, token)
+
+				// large negative kerning inside TJ arrays are word gaps
+				if value, err := strconv.ParseFloat(string(token), 64); err == nil && value < -200 && len(strs) > 0 {
+					strs = append(strs, []byte{0})
+				}
+
+				continue
+			}
+
+			switch string(token) {
+			case "BT":
+				hasY = false
+			case "ET":
+				write("\n")
+			case "Tf":
+				if len(operands) >= 2 && len(operands[len(operands)-2]) > 1 {
+					font = fonts[string(operands[len(operands)-2][1:])]
+				}
+			case "Td", "TD", "Tm":
+				if len(operands) >= 2 {
+					y, err := strconv.ParseFloat(string(operands[len(operands)-1]), 64)
+
+					if err == nil {
+						if token[1] == 'm' {
+							if hasY && y != lastY {
+								write("\n")
+							}
+
+							lastY = y
+						} else if y != 0 {
+							write("\n")
+						} else {
+							write(" ")
+						}
+
+						hasY = true
+					}
+				}
+			case "T*":
+				write("\n")
+			case "Tj", "'", "\"", "TJ":
+				if token[0] != 'T' {
+					write("\n")
+				}
+
+				for _, str := range strs {
+					if len(str) == 1 && str[0] == 0 {
+						write(" ")
+
+						continue
+					}
+
+					write(decode(str))
+				}
+			}
+
+			operands = operands[:0]
+			strs = strs[:0]
+		}
+	}
+
+	return buf.String()
+}
+
+func isPDFSpace(b byte) bool {
+	return b == ' ' || b == '\n' || b == '\r' || b == '\t' || b == '\f' || b == 0
+}
+
+// readPDFLiteral reads a (balanced) literal string starting at data[start].
+func readPDFLiteral(data []byte, start int) ([]byte, int) {
+	var (
+		buf   []byte
+		depth int
+	)
+
+	for i := start; i < len(data); i++ {
+		b := data[i]
+
+		switch b {
+		case '(':
+			if depth > 0 {
+				buf = append(buf, b)
+			}
+
+			depth++
+		case ')':
+			depth--
+
+			if depth == 0 {
+				return buf, i + 1
+			}
+
+			buf = append(buf, b)
+		case '\\':
+			i++
+
+			if i >= len(data) {
+				return buf, i
+			}
+
+			switch esc := data[i]; esc {
+			case 'n':
+				buf = append(buf, '\n')
+			case 'r':
+				buf = append(buf, '\r')
+			case 't':
+				buf = append(buf, '\t')
+			case 'b':
+				buf = append(buf, '\b')
+			case 'f':
+				buf = append(buf, '\f')
+			case '\r', '\n':
+				// line continuation
+			default:
+				if esc >= '0' && esc <= '7' {
+					end := i
+
+					for end < len(data) && end < i+3 && data[end] >= '0' && data[end] <= '7' {
+						end++
+					}
+
+					value, _ := strconv.ParseUint(string(data[i:end]), 8, 8)
+
+					buf = append(buf, byte(value))
+
+					i = end - 1
+				} else {
+					buf = append(buf, esc)
+				}
+			}
+		default:
+			buf = append(buf, b)
+		}
+	}
+
+	return buf, len(data)
+}
+
+func decodePDFHex(hex []byte) []byte {
+	hex = bytes.Join(bytes.Fields(hex), nil)
+
+	if len(hex)%2 == 1 {
+		hex = append(hex, '0')
+	}
+
+	out := make([]byte, 0, len(hex)/2)
+
+	for i := 0; i+2 <= len(hex); i += 2 {
+		value, err := strconv.ParseUint(string(hex[i:i+2]), 16, 8)
+		if err != nil {
+			break
+		}
+
+		out = append(out, byte(value))
+	}
+
+	return out
+}
+
+// decodePDFDocString decodes strings of fonts without a ToUnicode map,
+// which are usually latin-1 compatible.
+func decodePDFDocString(raw []byte) string {
+	if len(raw) >= 2 && raw[0] == 0xfe && raw[1] == 0xff {
+		units := make([]uint16, 0, len(raw)/2)
+
+		for i := 2; i+1 < len(raw); i += 2 {
+			units = append(units, uint16(raw[i])<<8|uint16(raw[i+1]))
+		}
+
+		return string(utf16.Decode(units))
+	}
+
+	runes := make([]rune, 0, len(raw))
+
+	for _, b := range raw {
+		if b < 0x20 && b != '\n' && b != '\t' {
+			continue
+		}
+
+		runes = append(runes, rune(b))
+	}
+
+	return string(runes)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func testPDF(objects ...string) []byte {
+	var buf bytes.Buffer
+
+	buf.WriteString("%PDF-1.4\n")
+
+	for i, object := range objects {
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+
+	buf.WriteString("%%EOF\n")
+
+	return buf.Bytes()
+}
+
+func testPDFStream(content string) string {
+	return fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content)
+}
+
+func testPDFFlate(content []byte) string {
+	var buf bytes.Buffer
+
+	writer, _ := zlib.NewWriterLevel(&buf, zlib.BestSpeed)
+
+	writer.Write(content)
+	writer.Close()
+
+	return fmt.Sprintf("<< /Length %d /Filter /FlateDecode >>\nstream\n%s\nendstream", buf.Len(), buf.String())
+}
+
+func TestExtractPDFTextMalformed(t *testing.T) {
+	bomb := bytes.Repeat([]byte("BT (aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa) Tj ET\n"), 1_000_000)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "not a pdf",
+			data:    []byte("<html></html>"),
+			wantErr: true,
+		},
+		{
+			name:    "empty",
+			data:    nil,
+			wantErr: true,
+		},
+		{
+			name: "plain",
+			data: testPDF(testPDFStream("BT /F1 12 Tf (Hello World) Tj ET")),
+			want: "Hello World",
+		},
+		{
+			name: "truncated stream",
+			data: []byte("%PDF-1.4\n1 0 obj\n<< /Length 100 >>\nstream\nBT (Hello) Tj ET BT (cut"),
+			want: "Hello",
+		},
+		{
+			name: "unbalanced literal",
+			data: testPDF(testPDFStream(`BT (Hello) Tj (World \) Tj ET`)),
+			want: "Hello",
+		},
+		{
+			name: "unterminated hex string",
+			data: testPDF(testPDFStream("BT (Hello) Tj <48656c")),
+			want: "Hello",
+		},
+		{
+			name: "unterminated dictionary",
+			data: testPDF(testPDFStream("BT (Hello) Tj ET BT << /MCID 0")),
+			want: "Hello",
+		},
+		{
+			name:    "corrupt flate stream",
+			data:    testPDF("<< /Length 8 /Filter /FlateDecode >>\nstream\nBT notzlib\nendstream"),
+			wantErr: true,
+		},
+		{
+			name: "missing font",
+			data: testPDF(testPDFStream("BT /F9 12 Tf (Hello) Tj ET")),
+			want: "Hello",
+		},
+		{
+			name: "decompression bomb",
+			data: testPDF(testPDFFlate(bomb), testPDFFlate(bomb)),
+			want: "aaaa",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			text, err := ExtractPDFText(test.data)
+
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %q", text)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !strings.Contains(text, test.want) {
+				t.Errorf("text does not contain %q: %q", test.want, text[:min(len(text), 200)])
+			}
+
+			if len(text) > PDFMaxText {
+				t.Errorf("text has %d bytes, limit is %d", len(text), PDFMaxText)
+			}
+		})
+	}
+}
+
+func TestParsePDFCMapBudget(t *testing.T) {
+	cmap := "1 begincodespacerange <0000> <ffff> endcodespacerange\n" + strings.Repeat("1 beginbfrange <0000> <ffff> <0041> endbfrange\n", 64)
+
+	budget := &pdfBudget{
+		codes: 100_000,
+	}
+
+	parsed := parsePDFCMap([]byte(cmap), budget)
+
+	if len(parsed.codes) > 100_000 {
+		t.Errorf("cmap has %d codes, budget was %d", len(parsed.codes), 100_000)
+	}
+
+	if budget.codes != 0 {
+		t.Errorf("budget has %d codes left", budget.codes)
+	}
+
+	if parsed.codes[0] != "A" || parsed.codes[1] != "B" {
+		t.Errorf("unexpected codes %q %q", parsed.codes[0], parsed.codes[1])
+	}
+}
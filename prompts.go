@@ -163,7 +163,7 @@ func BuildPrompt(name string, metadata ChatMetadata, model *Model, bare bool) (s
 
 	err := tmpl.Execute(buf, PromptData{
 		Name:     model.Name,
-		Slug:     model.Slug,
+		Slug:     model.Upstream(),
 		Date:     FormatPromptDate(metadata),
 		Platform: metadata.Platform,
 		Settings: settings,
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/coalaura/openingrouter"
+)
+
+const (
+	// format of the reasoning items stored with tool calls
+	responsesReasoningFormat = "openai-responses-reasoning"
+)
+
+type ResponsesRequest struct {
+	Model           string              `json:"model"`
+	Input           []any               `json:"input"`
+	Tools           []ResponsesTool     `json:"tools,omitempty"`
+	Reasoning       *ResponsesReasoning `json:"reasoning,omitempty"`
+	Text            *ResponsesText      `json:"text,omitempty"`
+	Temperature     *float64            `json:"temperature,omitempty"`
+	MaxOutputTokens *int                `json:"max_output_tokens,omitempty"`
+	Include         []string            `json:"include,omitempty"`
+	Store           bool                `json:"store"`
+	Stream          bool                `json:"stream"`
+}
+
+// ResponsesItem is a message, function call or function call output item.
+// Output items of other types are only read.
+type ResponsesItem struct {
+	Type             string             `json:"type"`
+	ID               string             `json:"id,omitempty"`
+	Role             string             `json:"role,omitempty"`
+	Content          []ResponsesContent `json:"content,omitempty"`
+	CallID           string             `json:"call_id,omitempty"`
+	Name             string             `json:"name,omitempty"`
+	Arguments        string             `json:"arguments,omitempty"`
+	Output           *string            `json:"output,omitempty"`
+	EncryptedContent string             `json:"encrypted_content,omitempty"`
+}
+
+// ResponsesReasoningItem is a reasoning item without its summary, which is
+// only shown to the user.
+type ResponsesReasoningItem struct {
+	Type             string             `json:"type"`
+	ID               string             `json:"id"`
+	Summary          []ResponsesContent `json:"summary"`
+	EncryptedContent string             `json:"encrypted_content"`
+}
+
+type ResponsesContent struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	ImageURL string `json:"image_url,omitempty"`
+}
+
+type ResponsesTool struct {
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Parameters  any    `json:"parameters,omitempty"`
+	Strict      bool   `json:"strict"`
+}
+
+type ResponsesReasoning struct {
+	Effort  string `json:"effort,omitempty"`
+	Summary string `json:"summary,omitempty"`
+}
+
+type ResponsesText struct {
+	Format ResponsesFormat `json:"format"`
+}
+
+type ResponsesFormat struct {
+	Type   string `json:"type"`
+	Name   string `json:"name,omitempty"`
+	Schema any    `json:"schema,omitempty"`
+	Strict *bool  `json:"strict,omitempty"`
+}
+
+type ResponsesEvent struct {
+	Type         string             `json:"type"`
+	OutputIndex  int                `json:"output_index"`
+	SummaryIndex int                `json:"summary_index"`
+	Delta        string             `json:"delta"`
+	Item         *ResponsesItem     `json:"item"`
+	Response     *ResponsesResponse `json:"response"`
+	Code         string             `json:"code"`
+	Message      string             `json:"message"`
+}
+
+type ResponsesResponse struct {
+	ID                string                `json:"id"`
+	Model             string                `json:"model"`
+	Status            string                `json:"status"`
+	Usage             *ResponsesUsage       `json:"usage"`
+	IncompleteDetails *ResponsesIncomplete  `json:"incomplete_details"`
+	Error             *ResponsesStreamError `json:"error"`
+}
+
+type ResponsesUsage struct {
+	InputTokens        int `json:"input_tokens"`
+	OutputTokens       int `json:"output_tokens"`
+	InputTokensDetails struct {
+		CachedTokens int `json:"cached_tokens"`
+	} `json:"input_tokens_details"`
+	OutputTokensDetails struct {
+		ReasoningTokens int `json:"reasoning_tokens"`
+	} `json:"output_tokens_details"`
+}
+
+type ResponsesIncomplete struct {
+	Reason string `json:"reason"`
+}
+
+type ResponsesStreamError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ResponsesStream maps the events of the responses api to completion deltas.
+type ResponsesStream struct {
+	body io.ReadCloser
+	sse  *SSEReader
+
+	calls bool
+
+	// reasoning items have to be sent back along with tool calls
+	reasoning []ResponsesReasoningItem
+	encrypted bool
+}
+
+func NewResponsesRequest(request *openingrouter.ChatCompletionRequest) *ResponsesRequest {
+	responses := &ResponsesRequest{
+		Model:           request.Model,
+		MaxOutputTokens: request.MaxTokens,
+		Include:         []string{"reasoning.encrypted_content"},
+		Stream:          true,
+	}
+
+	for _, message := range request.Messages {
+		switch message.Role {
+		case openingrouter.ChatRoleSystem:
+			text := message.Content.String()
+			if text == "" {
+				continue
+			}
+
+			responses.Input = append(responses.Input, ResponsesItem{
+				Type: "message",
+				Role: "developer",
+				Content: []ResponsesContent{{
+					Type: "input_text",
+					Text: text,
+				}},
+			})
+		case openingrouter.ChatRoleUser:
+			content := responsesContent(message.Content)
+			if len(content) == 0 {
+				continue
+			}
+
+			responses.Input = append(responses.Input, ResponsesItem{
+				Type:    "message",
+				Role:    "user",
+				Content: content,
+			})
+		case openingrouter.ChatRoleAssistant:
+			for _, details := range message.ReasoningDetails {
+				if details.Type != openingrouter.ChatReasoningDetailTypeEncrypted || string(details.Format) != responsesReasoningFormat {
+					continue
+				}
+
+				var items []ResponsesReasoningItem
+
+				if err := json.Unmarshal([]byte(details.Data), &items); err == nil {
+					for _, item := range items {
+						responses.Input = append(responses.Input, item)
+					}
+				}
+			}
+
+			if text := message.Content.String(); strings.TrimSpace(text) != "" {
+				responses.Input = append(responses.Input, ResponsesItem{
+					Type: "message",
+					Role: "assistant",
+					Content: []ResponsesContent{{
+						Type: "output_text",
+						Text: text,
+					}},
+				})
+			}
+
+			for _, call := range message.ToolCalls {
+				arguments := call.Function.Arguments
+				if arguments == "" {
+					arguments = "{}"
+				}
+
+				responses.Input = append(responses.Input, ResponsesItem{
+					Type:      "function_call",
+					CallID:    call.ID,
+					Name:      call.Function.Name,
+					Arguments: arguments,
+				})
+			}
+		case openingrouter.ChatRoleTool:
+			output := message.Content.String()
+
+			responses.Input = append(responses.Input, ResponsesItem{
+				Type:   "function_call_output",
+				CallID: message.ToolCallID,
+				Output: &output,
+			})
+		}
+	}
+
+	for _, function := range RequestFunctions(request) {
+		responses.Tools = append(responses.Tools, ResponsesTool{
+			Type:        "function",
+			Name:        function.Name,
+			Description: function.Description,
+			Parameters:  function.Parameters,
+			Strict:      Nullable(function.Strict, false),
+		})
+	}
+
+	if request.Reasoning != nil {
+		responses.Reasoning = &ResponsesReasoning{
+			Effort:  string(request.Reasoning.Effort),
+			Summary: "auto",
+		}
+	} else {
+		// reasoning models reject a temperature
+		responses.Temperature = request.Temperature
+	}
+
+	if format := request.ResponseFormat; format != nil {
+		responses.Text = &ResponsesText{
+			Format: ResponsesFormat{
+				Type: "json_object",
+			},
+		}
+
+		if schema := format.JSONSchema; schema != nil {
+			responses.Text.Format = ResponsesFormat{
+				Type:   "json_schema",
+				Name:   schema.Name,
+				Schema: schema.Schema,
+				Strict: schema.Strict,
+			}
+		}
+	}
+
+	return responses
+}
+
+func responsesContent(content openingrouter.ChatContent) []ResponsesContent {
+	if len(content.Parts) == 0 {
+		if content.Text == "" {
+			return nil
+		}
+
+		return []ResponsesContent{{
+			Type: "input_text",
+			Text: content.Text,
+		}}
+	}
+
+	parts := make([]ResponsesContent, 0, len(content.Parts))
+
+	for _, part := range content.Parts {
+		if part.ImageURL != nil {
+			parts = append(parts, ResponsesContent{
+				Type:     "input_image",
+				ImageURL: part.ImageURL.URL,
+			})
+		} else if part.Text != "" {
+			parts = append(parts, ResponsesContent{
+				Type: "input_text",
+				Text: part.Text,
+			})
+		}
+	}
+
+	return parts
+}
+
+func ResponsesStartStream(ctx context.Context, request *openingrouter.ChatCompletionRequest, backend *EnvBackend, proxy *EnvProxy) (CompletionStream, error) {
+	resp, err := BackendRequest(ctx, backend, proxy, "POST", "/responses", NewResponsesRequest(request), nil)
+	if err != nil {
+		log.Warnln(err)
+
+		return nil, err
+	}
+
+	return &ResponsesStream{
+		body: resp.Body,
+		sse:  NewSSEReader(resp.Body),
+	}, nil
+}
+
+func (s *ResponsesStream) Recv() (*CompletionDelta, error) {
+	for {
+		_, data, err := s.sse.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var event ResponsesEvent
+
+		if err := json.Unmarshal(data, &event); err != nil {
+			return nil, err
+		}
+
+		switch event.Type {
+		case "error":
+			return nil, fmt.Errorf("responses api error (%s): %s", event.Code, event.Message)
+		case "response.failed":
+			if event.Response != nil && event.Response.Error != nil {
+				return nil, fmt.Errorf("responses api error (%s): %s", event.Response.Error.Code, event.Response.Error.Message)
+			}
+
+			return nil, errors.New("response failed")
+		case "response.created":
+			if event.Response == nil {
+				continue
+			}
+
+			return &CompletionDelta{
+				ID: event.Response.ID,
+			}, nil
+		case "response.output_text.delta":
+			return &CompletionDelta{
+				Content: event.Delta,
+			}, nil
+		case "response.reasoning_summary_text.delta":
+			return &CompletionDelta{
+				Reasoning:     event.Delta,
+				ReasoningType: ReasoningTypeText,
+			}, nil
+		case "response.reasoning_summary_part.added":
+			// separate the summary parts like paragraphs
+			if event.SummaryIndex > 0 {
+				return &CompletionDelta{
+					Reasoning:     "\n\n",
+					ReasoningType: ReasoningTypeText,
+				}, nil
+			}
+		case "response.output_item.added":
+			if event.Item == nil || event.Item.Type != "function_call" {
+				continue
+			}
+
+			s.calls = true
+
+			delta := &CompletionDelta{
+				ToolCalls: []CompletionToolCall{{
+					Index: event.OutputIndex,
+					ID:    event.Item.CallID,
+					Name:  event.Item.Name,
+					Args:  event.Item.Arguments,
+				}},
+			}
+
+			// reasoning preceding the first tool call is stored with it
+			if !s.encrypted && len(s.reasoning) > 0 {
+				encoded, err := json.Marshal(s.reasoning)
+				if err == nil {
+					delta.Encrypted = []ChatToolReasoning{{
+						Format:    responsesReasoningFormat,
+						Encrypted: string(encoded),
+					}}
+
+					s.encrypted = true
+				}
+			}
+
+			return delta, nil
+		case "response.function_call_arguments.delta":
+			return &CompletionDelta{
+				ToolCalls: []CompletionToolCall{{
+					Index: event.OutputIndex,
+					Args:  event.Delta,
+				}},
+			}, nil
+		case "response.output_item.done":
+			if event.Item == nil || event.Item.Type != "reasoning" || event.Item.EncryptedContent == "" {
+				continue
+			}
+
+			s.reasoning = append(s.reasoning, ResponsesReasoningItem{
+				Type:             "reasoning",
+				ID:               event.Item.ID,
+				Summary:          []ResponsesContent{},
+				EncryptedContent: event.Item.EncryptedContent,
+			})
+		case "response.completed", "response.incomplete":
+			if event.Response == nil {
+				continue
+			}
+
+			return s.finish(event.Response), nil
+		}
+	}
+}
+
+func (s *ResponsesStream) finish(response *ResponsesResponse) *CompletionDelta {
+	delta := &CompletionDelta{
+		Finish: "stop",
+	}
+
+	if s.calls {
+		delta.Finish = "tool_calls"
+	}
+
+	if details := response.IncompleteDetails; details != nil {
+		switch details.Reason {
+		case "max_output_tokens":
+			delta.Finish = openingrouter.ChatFinishReasonLength
+		case "content_filter":
+			delta.Finish = openingrouter.ChatFinishReasonContentFilter
+		}
+	}
+
+	if usage := response.Usage; usage != nil {
+		debug("usage chunk: model=%q provider=%q prompt=%d completion=%d reasoning=%d", response.Model, APIResponses, usage.InputTokens, usage.OutputTokens, usage.OutputTokensDetails.ReasoningTokens)
+
+		delta.Usage = &Statistics{
+			Provider:        APIResponses,
+			Model:           response.Model,
+			InputTokens:     usage.InputTokens,
+			OutputTokens:    usage.OutputTokens,
+			ReasoningTokens: usage.OutputTokensDetails.ReasoningTokens,
+			CachedTokens:    usage.InputTokensDetails.CachedTokens,
+		}
+	}
+
+	return delta
+}
+
+func (s *ResponsesStream) Close() error {
+	return s.body.Close()
+}
@@ -0,0 +1,627 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// argv[0] the sandbox init is started with
+	sandboxInit = "whiskr-sandbox"
+
+	// uid and gid of the sandboxed process, never root so capabilities are
+	// dropped on exec
+	sandboxID = 1000
+
+	// host uid and gid the sandbox runs as if whiskr runs as root
+	sandboxNobody = 65534
+
+	// the scratch directory, the only writable path inside the sandbox
+	SandboxWorkDir = "/tmp"
+
+	sandboxMaxFileSize = 256 * 1024 * 1024
+
+	// mount flags of the host that are locked inside the user namespace
+	sandboxLockedFlags = syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC | syscall.MS_NOATIME | syscall.MS_NODIRATIME | syscall.MS_RELATIME
+)
+
+// not part of the syscall package
+const (
+	capSetGID   = 6
+	capSetUID   = 7
+	capSysAdmin = 21
+	rlimitNproc = 6
+
+	oPath = 0x200000
+
+	prSetNoNewPrivs      = 38
+	prCapAmbient         = 47
+	prCapAmbientClearAll = 4
+)
+
+// read-only paths available in every sandbox, missing ones are skipped
+var sandboxMounts = []string{
+	"/usr",
+	"/bin",
+	"/sbin",
+	"/lib",
+	"/lib32",
+	"/lib64",
+	"/etc/alternatives",
+	"/etc/ld.so.cache",
+	"/etc/ld.so.conf",
+	"/etc/ld.so.conf.d",
+	"/etc/localtime",
+	"/etc/ssl",
+	"/etc/ca-certificates",
+}
+
+var sandboxDevices = []string{
+	"/dev/null",
+	"/dev/zero",
+	"/dev/random",
+	"/dev/urandom",
+}
+
+// sandboxMount is a host path opened before the init switches users, so it can
+// be mounted even if its parents are not accessible to the sandbox user.
+type sandboxMount struct {
+	path  string
+	link  string
+	dir   bool
+	flags uintptr
+	fd    int
+}
+
+func init() {
+	if len(os.Args) == 0 || os.Args[0] != sandboxInit {
+		return
+	}
+
+	err := RunSandboxInit(os.Args[1:])
+
+	fmt.Fprintf(os.Stderr, "sandbox: %v\n", err)
+
+	os.Exit(127)
+}
+
+// CheckSandbox verifies that unprivileged user, mount and network namespaces
+// are available, since run_code relies on them to isolate the snippets.
+func CheckSandbox(cfg *EnvCode) error {
+	dir, err := os.MkdirTemp("", "whiskr-check-")
+	if err != nil {
+		return err
+	}
+
+	defer os.RemoveAll(dir)
+
+	cmd, err := NewSandboxCommand(context.Background(), cfg, dir, "true")
+	if err != nil {
+		return err
+	}
+
+	var stderr bytes.Buffer
+
+	cmd.Stderr = &stderr
+
+	err = cmd.Run()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("unable to create sandbox: %s", msg)
+		}
+
+		return fmt.Errorf("unable to create namespaces: %v", err)
+	}
+
+	return nil
+}
+
+// NewSandboxCommand prepares a command running in its own user, mount, pid and
+// network namespace (no network access) with the memory, cpu time and process
+// limits of cfg. The files in dir are copied into a private tmpfs mounted at
+// SandboxWorkDir, the rest of the filesystem only contains the system and
+// toolchain directories, mounted read-only.
+func NewSandboxCommand(ctx context.Context, cfg *EnvCode, dir, name string, args ...string) (*exec.Cmd, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	mounts, err := SandboxMounts(cfg, name)
+	if err != nil {
+		return nil, err
+	}
+
+	argv := []string{
+		dir,
+		strings.Join(mounts, ":"),
+		strconv.FormatInt(cfg.Memory, 10),
+		strconv.FormatInt(cfg.Timeout, 10),
+		strconv.FormatInt(cfg.Processes, 10),
+		name,
+	}
+
+	cmd := exec.CommandContext(ctx, self, append(argv, args...)...)
+
+	cmd.Args[0] = sandboxInit
+	cmd.Dir = dir
+
+	uid, gid := os.Getuid(), os.Getgid()
+
+	// root owns the system files and is exempt from the process limit, the
+	// init switches to an unprivileged host user before exec
+	caps := []uintptr{capSysAdmin}
+
+	if uid == 0 {
+		uid, gid = sandboxNobody, sandboxNobody
+
+		caps = append(caps, capSetUID, capSetGID)
+
+		err = chownTree(dir, uid, gid)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWPID | syscall.CLONE_NEWNET | syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS,
+		UidMappings: []syscall.SysProcIDMap{
+			{ContainerID: sandboxID, HostID: uid, Size: 1},
+		},
+		GidMappings: []syscall.SysProcIDMap{
+			{ContainerID: sandboxID, HostID: gid, Size: 1},
+		},
+		GidMappingsEnableSetgroups: len(caps) > 1,
+
+		// needed by the init to set up the mounts, dropped before exec
+		AmbientCaps: caps,
+
+		Setpgid:   true,
+		Pdeathsig: syscall.SIGKILL,
+	}
+
+	// kill the whole process group, not just the init
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+
+	cmd.WaitDelay = time.Second
+
+	return cmd, nil
+}
+
+// SandboxMounts returns the read-only paths of a sandbox running the given
+// command. Besides the system directories this includes the installation
+// prefix of the command (e.g. /usr/local/go or ~/.pyenv) and the configured
+// extra mounts. If the prefix is a broad directory (like the home directory
+// for ~/bin/node), only the command's directory and the lib directories of
+// the prefix are mounted.
+func SandboxMounts(cfg *EnvCode, name string) ([]string, error) {
+	binary, err := exec.LookPath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	mounts := append(slices.Clone(sandboxMounts), cfg.Mounts...)
+
+	add := func(dir string) {
+		if !filepath.IsAbs(dir) || sandboxBroad(dir) || sandboxCovered(mounts, dir) {
+			return
+		}
+
+		if _, err := os.Stat(dir); err != nil {
+			return
+		}
+
+		mounts = append(mounts, dir)
+	}
+
+	for _, file := range []string{binary, resolveSymlinks(binary)} {
+		prefix := filepath.Dir(filepath.Dir(file))
+
+		if !sandboxBroad(prefix) {
+			add(prefix)
+
+			continue
+		}
+
+		add(filepath.Dir(file))
+		add(filepath.Join(prefix, "lib"))
+		add(filepath.Join(prefix, "lib64"))
+	}
+
+	for _, mount := range mounts {
+		if strings.Contains(mount, ":") {
+			return nil, fmt.Errorf("invalid sandbox mount %q", mount)
+		}
+	}
+
+	return mounts, nil
+}
+
+// RunSandboxInit runs inside the new namespaces. It builds the root
+// filesystem, applies the limits, drops its capabilities and replaces itself
+// with the sandboxed command.
+func RunSandboxInit(args []string) error {
+	if len(args) < 6 {
+		return errors.New("invalid arguments")
+	}
+
+	dir, mounts := args[0], strings.Split(args[1], ":")
+
+	var limits [3]uint64
+
+	for i := range limits {
+		limit, err := strconv.ParseUint(args[2+i], 10, 64)
+		if err != nil {
+			return err
+		}
+
+		limits[i] = limit
+	}
+
+	memory, cpu, processes := limits[0], limits[1], limits[2]
+
+	var binds []*sandboxMount
+
+	for _, path := range mounts {
+		mount, err := openSandboxMount(path, false)
+		if err != nil {
+			return err
+		}
+
+		if mount != nil {
+			binds = append(binds, mount)
+		}
+	}
+
+	for _, path := range sandboxDevices {
+		mount, err := openSandboxMount(path, true)
+		if err != nil {
+			return err
+		}
+
+		binds = append(binds, mount)
+	}
+
+	// started by root, which is not mapped inside the namespace. Since root is
+	// not mapped either, the capabilities are kept.
+	if os.Getuid() != sandboxID {
+		err := syscall.Setgroups(nil)
+		if err != nil {
+			return err
+		}
+
+		err = syscall.Setresgid(sandboxID, sandboxID, sandboxID)
+		if err != nil {
+			return err
+		}
+
+		err = syscall.Setresuid(sandboxID, sandboxID, sandboxID)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := SetupSandboxRoot(filepath.Join(dir, ".root"), dir, binds, memory)
+	if err != nil {
+		return err
+	}
+
+	rlimits := map[int]uint64{
+		syscall.RLIMIT_DATA:  memory * 1024 * 1024,
+		syscall.RLIMIT_CPU:   cpu,
+		syscall.RLIMIT_FSIZE: sandboxMaxFileSize,
+		syscall.RLIMIT_CORE:  0,
+		rlimitNproc:          processes,
+	}
+
+	for resource, limit := range rlimits {
+		err = syscall.Setrlimit(resource, &syscall.Rlimit{
+			Cur: limit,
+			Max: limit,
+		})
+
+		if err != nil {
+			return fmt.Errorf("unable to set limit %d: %v", resource, err)
+		}
+	}
+
+	err = prctl(prSetNoNewPrivs, 1, 0)
+	if err != nil {
+		return fmt.Errorf("unable to set no_new_privs: %v", err)
+	}
+
+	err = prctl(prCapAmbient, prCapAmbientClearAll, 0)
+	if err != nil {
+		return fmt.Errorf("unable to drop capabilities: %v", err)
+	}
+
+	binary, err := exec.LookPath(args[5])
+	if err != nil {
+		return err
+	}
+
+	return syscall.Exec(binary, append([]string{args[5]}, args[6:]...), os.Environ())
+}
+
+// SetupSandboxRoot builds a read-only tmpfs root with the given paths bind
+// mounted read-only, a private scratch tmpfs containing the files of src and
+// pivots into it.
+func SetupSandboxRoot(root, src string, binds []*sandboxMount, memory uint64) error {
+	// keep our mounts from propagating to the host
+	err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, "")
+	if err != nil {
+		return fmt.Errorf("unable to make mounts private: %v", err)
+	}
+
+	err = os.Mkdir(root, 0755)
+	if err != nil {
+		return err
+	}
+
+	err = syscall.Mount("tmpfs", root, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "size=1m,mode=0755")
+	if err != nil {
+		return fmt.Errorf("unable to mount root: %v", err)
+	}
+
+	for _, mount := range binds {
+		err = mount.Bind(root)
+		if err != nil {
+			return err
+		}
+	}
+
+	// only possible if the host's /proc is fully visible, programs work without it
+	proc := filepath.Join(root, "proc")
+
+	if os.Mkdir(proc, 0755) == nil {
+		syscall.Mount("proc", proc, "proc", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, "")
+	}
+
+	work := filepath.Join(root, SandboxWorkDir)
+
+	err = os.MkdirAll(work, 0755)
+	if err != nil {
+		return err
+	}
+
+	err = syscall.Mount("tmpfs", work, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, fmt.Sprintf("size=%dm,mode=0700", memory))
+	if err != nil {
+		return fmt.Errorf("unable to mount scratch directory: %v", err)
+	}
+
+	err = sandboxCopyFiles(src, work)
+	if err != nil {
+		return err
+	}
+
+	old := filepath.Join(root, ".old")
+
+	err = os.Mkdir(old, 0700)
+	if err != nil {
+		return err
+	}
+
+	err = syscall.PivotRoot(root, old)
+	if err != nil {
+		return fmt.Errorf("unable to pivot root: %v", err)
+	}
+
+	err = syscall.Unmount("/.old", syscall.MNT_DETACH)
+	if err != nil {
+		return fmt.Errorf("unable to unmount host root: %v", err)
+	}
+
+	err = os.Remove("/.old")
+	if err != nil {
+		return err
+	}
+
+	err = syscall.Mount("", "/", "", syscall.MS_REMOUNT|syscall.MS_BIND|syscall.MS_RDONLY|syscall.MS_NOSUID|syscall.MS_NODEV, "")
+	if err != nil {
+		return fmt.Errorf("unable to remount root: %v", err)
+	}
+
+	return os.Chdir(SandboxWorkDir)
+}
+
+// openSandboxMount opens a host path for mounting it into the sandbox. Missing
+// optional paths are skipped, symlinks are recreated instead of followed.
+func openSandboxMount(path string, required bool) (*sandboxMount, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		if !required && errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	mount := &sandboxMount{
+		path: path,
+		dir:  info.IsDir(),
+		fd:   -1,
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		mount.link, err = os.Readlink(path)
+
+		return mount, err
+	}
+
+	mount.fd, err = syscall.Open(path, oPath|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open %s: %v", path, err)
+	}
+
+	// flags of the original mount are locked and have to be kept
+	var stat syscall.Statfs_t
+
+	err = syscall.Fstatfs(mount.fd, &stat)
+	if err != nil {
+		return nil, err
+	}
+
+	mount.flags = uintptr(stat.Flags) & sandboxLockedFlags
+
+	return mount, nil
+}
+
+// Bind mounts the path read-only at the same location inside root.
+func (m *sandboxMount) Bind(root string) error {
+	target := filepath.Join(root, m.path)
+
+	err := os.MkdirAll(filepath.Dir(target), 0755)
+	if err != nil {
+		return err
+	}
+
+	if m.link != "" {
+		return os.Symlink(m.link, target)
+	}
+
+	if m.dir {
+		err = os.Mkdir(target, 0755)
+	} else {
+		err = os.WriteFile(target, nil, 0644)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	source := fmt.Sprintf("/proc/self/fd/%d", m.fd)
+
+	err = syscall.Mount(source, target, "", syscall.MS_BIND|syscall.MS_REC, "")
+	if err != nil {
+		return fmt.Errorf("unable to mount %s: %v", m.path, err)
+	}
+
+	err = syscall.Mount("", target, "", syscall.MS_REMOUNT|syscall.MS_BIND|syscall.MS_RDONLY|m.flags, "")
+	if err != nil {
+		return fmt.Errorf("unable to remount %s read-only: %v", m.path, err)
+	}
+
+	return nil
+}
+
+// sandboxCopyFiles copies the regular files of src into dst.
+func sandboxCopyFiles(src, dst string) error {
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+
+		err = sandboxCopyFile(filepath.Join(src, entry.Name()), filepath.Join(dst, entry.Name()))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func sandboxCopyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+
+	defer out.Close()
+
+	_, err = io.Copy(out, in)
+
+	return err
+}
+
+// sandboxCovered checks if path is one of mounts or inside of one.
+// sandboxBroad reports whether a directory is too broad to be mounted into
+// the sandbox: top-level directories, home directories and directories
+// containing a home directory or the config.
+func sandboxBroad(dir string) bool {
+	dir = filepath.Clean(dir)
+
+	if strings.Count(strings.Trim(dir, "/"), "/") < 1 || filepath.Dir(dir) == "/home" {
+		return true
+	}
+
+	contains := func(target string) bool {
+		return target != "" && (target == dir || strings.HasPrefix(target, dir+"/"))
+	}
+
+	if home, err := os.UserHomeDir(); err == nil && contains(filepath.Clean(home)) {
+		return true
+	}
+
+	if path.Config != "" {
+		if config, err := filepath.Abs(path.Config); err == nil && contains(filepath.Dir(config)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func sandboxCovered(mounts []string, path string) bool {
+	for _, mount := range mounts {
+		if path == mount || strings.HasPrefix(path, strings.TrimRight(mount, "/")+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func chownTree(dir string, uid, gid int) error {
+	return filepath.WalkDir(dir, func(path string, _ os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		return os.Lchown(path, uid, gid)
+	})
+}
+
+func resolveSymlinks(path string) string {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return path
+	}
+
+	return resolved
+}
+
+func prctl(option, arg2, arg3 uintptr) error {
+	_, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, option, arg2, arg3)
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
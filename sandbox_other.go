@@ -0,0 +1,21 @@
+//go:build !linux
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+)
+
+const SandboxWorkDir = "/tmp"
+
+var errSandboxUnsupported = errors.New("sandboxed code execution requires linux")
+
+func CheckSandbox(_ *EnvCode) error {
+	return errSandboxUnsupported
+}
+
+func NewSandboxCommand(_ context.Context, _ *EnvCode, _, _ string, _ ...string) (*exec.Cmd, error) {
+	return nil, errSandboxUnsupported
+}
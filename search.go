@@ -7,6 +7,11 @@ import (
 	"errors"
 	"fmt"
 	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
 
 	"github.com/coalaura/openingrouter"
 )
@@ -32,124 +37,402 @@ type GitHubRepositoryArguments struct {
 	Repo  string `json:"repo"`
 }
 
-func GetSearchTools() []openingrouter.ChatTool {
+// SearchProvider runs a single web search query. Results are normalized into
+// TavilyResult, so all providers produce the same tool output.
+type SearchProvider interface {
+	Name() string
+	Search(ctx context.Context, query string, args *SearchWebArguments) (*SearchResponse, error)
+}
+
+// ContentProvider is implemented by search providers that can also extract
+// the contents of urls.
+type ContentProvider interface {
+	Contents(ctx context.Context, args *FetchContentsArguments) (*TavilyResults, error)
+}
+
+type SearchResponse struct {
+	RequestID string
+	Credits   int
+	Results   []TavilyResult
+}
+
+type SearchQueryResult struct {
+	Resp *SearchResponse
+	Err  error
+}
+
+var (
+	//go:embed internal/tools/search_web.txt
+	SearchWebPrompt string
+
+	//go:embed internal/tools/fetch_contents.txt
+	FetchContentsPrompt string
+
+	//go:embed internal/tools/github_repository.txt
+	GitHubRepositoryPrompt string
+)
+
+func init() {
 	strict := true
 
-	return []openingrouter.ChatTool{
-		openingrouter.ChatFunctionTool{
-			Type: openingrouter.ChatToolTypeFunction,
-			Function: openingrouter.ChatFunction{
-				Name:        "search_web",
-				Description: "Search the live web via Tavily to discover relevant pages. Returns titles, URLs, and relevant content snippets ranked by relevance. Use this to find sources; use fetch_contents to read the full text of a specific URL.",
-				Parameters: map[string]any{
-					"type":     "object",
-					"required": []string{"queries"},
-					"properties": map[string]any{
-						"queries": map[string]any{
-							"type": "array",
-							"items": map[string]any{
-								"type": "string",
-							},
-							"minItems":    1,
-							"maxItems":    5,
-							"description": "One or more concise, keyword-focused search queries (ideally 3-8 words each, like a search engine query rather than a sentence). For complex questions, decompose into several focused sub-queries that run in parallel; their results are merged and de-duplicated. Examples: ['tavily api pricing 2025'], ['rust async runtime comparison', 'tokio vs async-std performance'].",
-						},
-						"topic": map[string]any{
-							"type":        "string",
-							"enum":        []string{"general", "news", "finance"},
-							"description": "Search topic. 'news' for recent events and current affairs, 'finance' for markets and financial data, 'general' for everything else. Default 'general'.",
-						},
-						"depth": map[string]any{
-							"type":        "string",
-							"enum":        []string{"quick", "thorough"},
-							"description": "'quick' for fast, broad lookups (low latency); 'thorough' for harder questions needing the highest-quality, most relevant snippets. Default 'quick'.",
-						},
-						"time_range": map[string]any{
-							"type":        "string",
-							"enum":        []string{"day", "week", "month", "year"},
-							"description": "Restrict results to content published within this recent window. Prefer this over start_date/end_date for relative recency.",
-						},
-						"start_date": map[string]any{
-							"type":        "string",
-							"description": "Filter results published ON OR AFTER this date (YYYY-MM-DD). Use for absolute ranges.",
-						},
-						"end_date": map[string]any{
-							"type":        "string",
-							"description": "Filter results published ON OR BEFORE this date (YYYY-MM-DD). Use for absolute ranges.",
-						},
-						"max_results": map[string]any{
-							"type":        "integer",
-							"description": "Number of results to return (1-20). Default is 5.",
-							"minimum":     1,
-							"maximum":     20,
+	MustRegisterTool(&FunctionTool[SearchWebArguments]{
+		Function: openingrouter.ChatFunction{
+			Name:        "search_web",
+			Description: "Search the live web to discover relevant pages. Returns titles, URLs, and relevant content snippets ranked by relevance. Use this to find sources; use fetch_contents to read the full text of a specific URL.",
+			Parameters: map[string]any{
+				"type":     "object",
+				"required": []string{"queries"},
+				"properties": map[string]any{
+					"queries": map[string]any{
+						"type": "array",
+						"items": map[string]any{
+							"type": "string",
 						},
-						"include_domains": map[string]any{
-							"type": "array",
-							"items": map[string]any{
-								"type": "string",
-							},
-							"description": "Restrict search to these specific website domains (e.g., ['europa.eu', 'who.int']).",
+						"minItems":    1,
+						"maxItems":    5,
+						"description": "One or more concise, keyword-focused search queries (ideally 3-8 words each, like a search engine query rather than a sentence). For complex questions, decompose into several focused sub-queries that run in parallel; their results are merged and de-duplicated. Examples: ['kagi api pricing 2025'], ['rust async runtime comparison', 'tokio vs async-std performance'].",
+					},
+					"topic": map[string]any{
+						"type":        "string",
+						"enum":        []string{"general", "news", "finance"},
+						"description": "Search topic. 'news' for recent events and current affairs, 'finance' for markets and financial data, 'general' for everything else. Default 'general'.",
+					},
+					"depth": map[string]any{
+						"type":        "string",
+						"enum":        []string{"quick", "thorough"},
+						"description": "'quick' for fast, broad lookups (low latency); 'thorough' for harder questions needing the highest-quality, most relevant snippets. Default 'quick'.",
+					},
+					"time_range": map[string]any{
+						"type":        "string",
+						"enum":        []string{"day", "week", "month", "year"},
+						"description": "Restrict results to content published within this recent window. Prefer this over start_date/end_date for relative recency.",
+					},
+					"start_date": map[string]any{
+						"type":        "string",
+						"description": "Filter results published ON OR AFTER this date (YYYY-MM-DD). Use for absolute ranges.",
+					},
+					"end_date": map[string]any{
+						"type":        "string",
+						"description": "Filter results published ON OR BEFORE this date (YYYY-MM-DD). Use for absolute ranges.",
+					},
+					"max_results": map[string]any{
+						"type":        "integer",
+						"description": "Number of results to return (1-20). Default is 5.",
+						"minimum":     1,
+						"maximum":     20,
+					},
+					"include_domains": map[string]any{
+						"type": "array",
+						"items": map[string]any{
+							"type": "string",
 						},
-						"exclude_domains": map[string]any{
-							"type": "array",
-							"items": map[string]any{
-								"type": "string",
-							},
-							"description": "Exclude results from these website domains.",
+						"description": "Restrict search to these specific website domains (e.g., ['europa.eu', 'who.int']).",
+					},
+					"exclude_domains": map[string]any{
+						"type": "array",
+						"items": map[string]any{
+							"type": "string",
 						},
+						"description": "Exclude results from these website domains.",
 					},
-					"additionalProperties": false,
 				},
+				"additionalProperties": false,
 			},
 		},
-		openingrouter.ChatFunctionTool{
-			Type: openingrouter.ChatToolTypeFunction,
-			Function: openingrouter.ChatFunction{
-				Name:        "fetch_contents",
-				Description: "Fetch the full text content of one or more specific URLs via Tavily. Use this to read pages in depth, including links found via search_web or provided by the user.",
-				Parameters: map[string]any{
-					"type":     "object",
-					"required": []string{"urls"},
-					"properties": map[string]any{
-						"urls": map[string]any{
-							"type":        "array",
-							"description": "List of URLs to fetch.",
-							"items": map[string]any{
-								"type": "string",
-							},
-							"minItems": 1,
-							"maxItems": 5,
+		Snippet: SearchWebPrompt,
+		Enable:  HasSearchProvider,
+		Handler: HandleSearchWebTool,
+	})
+
+	MustRegisterTool(&FunctionTool[FetchContentsArguments]{
+		Function: openingrouter.ChatFunction{
+			Name:        "fetch_contents",
+			Description: "Fetch the full text content of one or more specific URLs. Use this to read pages in depth, including links found via search_web or provided by the user.",
+			Parameters: map[string]any{
+				"type":     "object",
+				"required": []string{"urls"},
+				"properties": map[string]any{
+					"urls": map[string]any{
+						"type":        "array",
+						"description": "List of URLs to fetch.",
+						"items": map[string]any{
+							"type": "string",
 						},
+						"minItems": 1,
+						"maxItems": 5,
 					},
-					"additionalProperties": false,
 				},
-				Strict: &strict,
+				"additionalProperties": false,
 			},
+			Strict: &strict,
 		},
-		openingrouter.ChatFunctionTool{
-			Type: openingrouter.ChatToolTypeFunction,
-			Function: openingrouter.ChatFunction{
-				Name:        "github_repository",
-				Description: "Fetch repository metadata and README from GitHub.",
-				Parameters: map[string]any{
-					"type":     "object",
-					"required": []string{"owner", "repo"},
-					"properties": map[string]any{
-						"owner": map[string]any{
-							"type":        "string",
-							"description": "Repository owner (e.g., 'torvalds').",
-						},
-						"repo": map[string]any{
-							"type":        "string",
-							"description": "Repository name (e.g., 'linux').",
-						},
+		Snippet: FetchContentsPrompt,
+		Handler: HandleFetchContentsTool,
+	})
+
+	MustRegisterTool(&FunctionTool[GitHubRepositoryArguments]{
+		Function: openingrouter.ChatFunction{
+			Name:        "github_repository",
+			Description: "Fetch repository metadata and README from GitHub.",
+			Parameters: map[string]any{
+				"type":     "object",
+				"required": []string{"owner", "repo"},
+				"properties": map[string]any{
+					"owner": map[string]any{
+						"type":        "string",
+						"description": "Repository owner (e.g., 'torvalds').",
+					},
+					"repo": map[string]any{
+						"type":        "string",
+						"description": "Repository name (e.g., 'linux').",
 					},
-					"additionalProperties": false,
 				},
-				Strict: &strict,
+				"additionalProperties": false,
 			},
+			Strict: &strict,
 		},
+		Snippet: GitHubRepositoryPrompt,
+		Handler: HandleGitHubRepositoryTool,
+	})
+}
+
+// NewSearchProvider creates the configured search provider, or the first one
+// with credentials if none is selected. It returns nil if none is available.
+func NewSearchProvider(tokens *EnvTokens, search *EnvSearch) (SearchProvider, error) {
+	provider := search.Provider
+
+	if provider == "" {
+		switch {
+		case tokens.Tavily != "":
+			provider = "tavily"
+		case search.SearXNG != "":
+			provider = "searxng"
+		case tokens.Brave != "":
+			provider = "brave"
+		case tokens.Kagi != "":
+			provider = "kagi"
+		default:
+			return nil, nil
+		}
+	}
+
+	switch provider {
+	case "tavily":
+		if tokens.Tavily == "" {
+			return nil, errors.New("missing tokens.tavily")
+		}
+
+		return &TavilyProvider{
+			token: tokens.Tavily,
+		}, nil
+	case "searxng":
+		if search.SearXNG == "" {
+			return nil, errors.New("missing tools.search.searxng")
+		}
+
+		return &SearXNGProvider{
+			base: strings.TrimRight(search.SearXNG, "/"),
+		}, nil
+	case "brave":
+		if tokens.Brave == "" {
+			return nil, errors.New("missing tokens.brave")
+		}
+
+		return &BraveProvider{
+			token: tokens.Brave,
+		}, nil
+	case "kagi":
+		if tokens.Kagi == "" {
+			return nil, errors.New("missing tokens.kagi")
+		}
+
+		return &KagiProvider{
+			token: tokens.Kagi,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("invalid tools.search.provider %q", provider)
+}
+
+func HasSearchProvider(_ *ChatRequest, _ *Model) bool {
+	return env.Tools.Search.provider != nil
+}
+
+// RunWebSearch runs all queries in parallel and merges their results,
+// de-duplicated by url and sorted by score.
+func RunWebSearch(ctx context.Context, provider SearchProvider, args *SearchWebArguments) (*TavilyResults, error) {
+	queries := make([]string, 0, len(args.Queries))
+	for _, q := range args.Queries {
+		if q = strings.TrimSpace(q); q != "" {
+			queries = append(queries, q)
+		}
+	}
+
+	if len(queries) == 0 {
+		return nil, fmt.Errorf("no search query")
+	}
+
+	if len(queries) > 5 {
+		queries = queries[:5]
+	}
+
+	if args.MaxResults <= 0 {
+		args.MaxResults = 5
+	} else if args.MaxResults > 20 {
+		args.MaxResults = 20
+	}
+
+	var (
+		wg      sync.WaitGroup
+		outputs = make([]SearchQueryResult, len(queries))
+	)
+
+	for i, query := range queries {
+		wg.Go(func() {
+			resp, err := provider.Search(ctx, query, args)
+
+			outputs[i] = SearchQueryResult{
+				Resp: resp,
+				Err:  err,
+			}
+		})
 	}
+
+	wg.Wait()
+
+	results := &TavilyResults{
+		Query:   strings.Join(queries, " | "),
+		Results: make([]TavilyResult, 0),
+	}
+
+	var (
+		firstErr error
+		seen     = make(map[string]int)
+	)
+
+	for _, result := range outputs {
+		if result.Err != nil {
+			if firstErr == nil {
+				firstErr = result.Err
+			}
+
+			continue
+		}
+
+		if results.RequestID == "" {
+			results.RequestID = result.Resp.RequestID
+		}
+
+		results.Usage.Credits += result.Resp.Credits
+
+		for _, converted := range result.Resp.Results {
+			if idx, ok := seen[converted.URL]; ok {
+				if converted.Score > results.Results[idx].Score {
+					results.Results[idx] = converted
+				}
+
+				continue
+			}
+
+			seen[converted.URL] = len(results.Results)
+			results.Results = append(results.Results, converted)
+		}
+	}
+
+	if len(results.Results) == 0 && firstErr != nil {
+		return nil, firstErr
+	}
+
+	sort.SliceStable(results.Results, func(i, j int) bool {
+		return results.Results[i].Score > results.Results[j].Score
+	})
+
+	results.Results = DedupeResults(results.Results)
+
+	return results, nil
+}
+
+// DedupeResults drops results whose snippet is nearly identical to a higher
+// ranked one, e.g. syndicated articles or mirrors found by different queries.
+func DedupeResults(results []TavilyResult) []TavilyResult {
+	var (
+		kept  = make([]TavilyResult, 0, len(results))
+		words = make([]map[string]struct{}, 0, len(results))
+	)
+
+	for _, result := range results {
+		set := snippetWords(result.Summary + " " + result.Text)
+
+		// very short snippets are too similar by chance
+		if len(set) >= 8 && slices.ContainsFunc(words, func(other map[string]struct{}) bool {
+			return wordSimilarity(set, other) >= 0.85
+		}) {
+			debug("dropping duplicate result %q", result.URL)
+
+			continue
+		}
+
+		kept = append(kept, result)
+		words = append(words, set)
+	}
+
+	return kept
+}
+
+func snippetWords(text string) map[string]struct{} {
+	set := make(map[string]struct{})
+
+	for word := range strings.FieldsFuncSeq(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}) {
+		set[word] = struct{}{}
+	}
+
+	return set
+}
+
+// wordSimilarity is the jaccard index of two word sets.
+func wordSimilarity(a, b map[string]struct{}) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+
+	var shared int
+
+	for word := range a {
+		if _, ok := b[word]; ok {
+			shared++
+		}
+	}
+
+	return float64(shared) / float64(len(a)+len(b)-shared)
+}
+
+// SiteFilterQuery adds site: operators for providers without domain filters.
+func SiteFilterQuery(query string, include, exclude []string) string {
+	var filters []string
+
+	for _, domain := range include {
+		filters = append(filters, "site:"+domain)
+	}
+
+	if len(filters) > 1 {
+		query += " (" + strings.Join(filters, " OR ") + ")"
+	} else if len(filters) == 1 {
+		query += " " + filters[0]
+	}
+
+	for _, domain := range exclude {
+		query += " -site:" + domain
+	}
+
+	return query
+}
+
+// RankScore turns a 0-based result position into a score for providers that
+// don't report relevance scores themselves.
+func RankScore(rank int) float64 {
+	return 1 / float64(rank+1)
 }
 
 func HandleSearchWebTool(ctx context.Context, tool *ChatToolCall, arguments *SearchWebArguments) error {
@@ -157,7 +440,7 @@ func HandleSearchWebTool(ctx context.Context, tool *ChatToolCall, arguments *Sea
 		return errors.New("no search query")
 	}
 
-	results, err := TavilyRunSearch(ctx, arguments)
+	results, err := RunWebSearch(ctx, env.Tools.Search.provider, arguments)
 	if err != nil {
 		tool.Result = fmt.Sprintf("error: %v", err)
 
@@ -180,12 +463,7 @@ func HandleFetchContentsTool(ctx context.Context, tool *ChatToolCall, arguments
 		return errors.New("no urls")
 	}
 
-	results, err := TavilyRunContents(ctx, arguments)
-	if err != nil {
-		tool.Result = fmt.Sprintf("error: %v", err)
-
-		return nil
-	}
+	results := FetchContents(ctx, arguments)
 
 	if len(results.Results) == 0 {
 		tool.Result = "error: no search results"
@@ -198,6 +476,41 @@ func HandleFetchContentsTool(ctx context.Context, tool *ChatToolCall, arguments
 	return nil
 }
 
+// FetchContents uses the search provider to extract the contents if it
+// supports it and falls back to the built-in fetcher otherwise.
+func FetchContents(ctx context.Context, arguments *FetchContentsArguments) *TavilyResults {
+	provider, ok := env.Tools.Search.provider.(ContentProvider)
+	if !ok {
+		return FetchContentsNative(ctx, arguments)
+	}
+
+	results, err := provider.Contents(ctx, arguments)
+	if err != nil {
+		log.Warnf("Unable to extract contents with %s, using built-in fetcher: %v\n", env.Tools.Search.provider.Name(), err)
+
+		return FetchContentsNative(ctx, arguments)
+	}
+
+	if len(results.Results) < len(arguments.URLs) {
+		// fetch the urls the provider failed to extract
+		missing := &FetchContentsArguments{}
+
+		for _, link := range arguments.URLs {
+			if !slices.ContainsFunc(results.Results, func(result TavilyResult) bool {
+				return result.URL == link
+			}) {
+				missing.URLs = append(missing.URLs, link)
+			}
+		}
+
+		if len(missing.URLs) > 0 {
+			results.Results = append(results.Results, FetchContentsNative(ctx, missing).Results...)
+		}
+	}
+
+	return results
+}
+
 func HandleGitHubRepositoryTool(ctx context.Context, tool *ChatToolCall, arguments *GitHubRepositoryArguments) error {
 	result, err := RepoOverview(ctx, arguments)
 	if err != nil {
@@ -240,3 +553,80 @@ func ParseAndUpdateArgs[T any](tool *ChatToolCall) (*T, error) {
 
 	return &arguments, nil
 }
+
+// ParseSchemaArgs parses the arguments of a tool call defined by a json schema.
+// Values are kept as sent (numbers keep their precision), only strings where
+// the schema expects a number or boolean are converted, since some models
+// quote them.
+func ParseSchemaArgs(tool *ChatToolCall, schema map[string]any) (map[string]any, error) {
+	var arguments map[string]any
+
+	dec := json.NewDecoder(strings.NewReader(tool.Args))
+	dec.UseNumber()
+
+	err := dec.Decode(&arguments)
+	if err != nil {
+		return nil, fmt.Errorf("json.unmarshal: %v", err)
+	}
+
+	if arguments == nil {
+		arguments = make(map[string]any)
+	}
+
+	coerceSchemaValue(schema, arguments)
+
+	buf := GetFreeBuffer()
+	defer pool.Put(buf)
+
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+
+	err = enc.Encode(arguments)
+	if err != nil {
+		return nil, fmt.Errorf("json.marshal: %v", err)
+	}
+
+	tool.Args = buf.String()
+
+	return arguments, nil
+}
+
+func coerceSchemaValue(schema map[string]any, value any) any {
+	typ, _ := schema["type"].(string)
+
+	switch v := value.(type) {
+	case string:
+		switch typ {
+		case "number", "integer":
+			var number json.Number
+
+			// only valid json numbers, "0123" stays a string
+			if json.Unmarshal([]byte(strings.TrimSpace(v)), &number) == nil {
+				return number
+			}
+		case "boolean":
+			switch v {
+			case "true":
+				return true
+			case "false":
+				return false
+			}
+		}
+	case map[string]any:
+		properties, _ := schema["properties"].(map[string]any)
+
+		for name, item := range v {
+			if property, ok := properties[name].(map[string]any); ok {
+				v[name] = coerceSchemaValue(property, item)
+			}
+		}
+	case []any:
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range v {
+				v[i] = coerceSchemaValue(items, item)
+			}
+		}
+	}
+
+	return value
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type SearXNGProvider struct {
+	base string
+}
+
+type SearXNGResult struct {
+	Title         string  `json:"title"`
+	URL           string  `json:"url"`
+	Content       string  `json:"content"`
+	PublishedDate string  `json:"publishedDate"`
+	Score         float64 `json:"score"`
+}
+
+type SearXNGResponse struct {
+	Query   string          `json:"query"`
+	Results []SearXNGResult `json:"results"`
+}
+
+func (p *SearXNGProvider) Name() string {
+	return "searxng"
+}
+
+func (p *SearXNGProvider) Search(ctx context.Context, query string, args *SearchWebArguments) (*SearchResponse, error) {
+	params := url.Values{}
+
+	params.Set("q", SiteFilterQuery(query, args.IncludeDomains, args.ExcludeDomains))
+	params.Set("format", "json")
+
+	if args.Topic == "news" {
+		params.Set("categories", "news")
+	} else {
+		params.Set("categories", "general")
+	}
+
+	if args.TimeRange != "" {
+		params.Set("time_range", args.TimeRange)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/search?%s", p.base, params.Encode()), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+
+		return nil, fmt.Errorf("searxng error (%d): %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var response SearXNGResponse
+
+	err = json.NewDecoder(resp.Body).Decode(&response)
+	if err != nil {
+		return nil, err
+	}
+
+	results := &SearchResponse{
+		Results: make([]TavilyResult, 0, min(len(response.Results), args.MaxResults)),
+	}
+
+	for i, result := range response.Results {
+		if i >= args.MaxResults {
+			break
+		}
+
+		score := result.Score
+		if score <= 0 {
+			score = RankScore(i)
+		}
+
+		results.Results = append(results.Results, TavilyResult{
+			Title:         result.Title,
+			URL:           result.URL,
+			PublishedDate: result.PublishedDate,
+			Score:         score,
+			Summary:       result.Content,
+		})
+	}
+
+	return results, nil
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/coalaura/whiskr/internal/websocket"
+)
+
+// Socket multiplexes generations over a single websocket connection. Chunks
+// are sent as json text messages, control messages (chat, attach, detach,
+// cancel, approve, ping) are answered with events carrying the client's ref.
+type Socket struct {
+	mx   sync.Mutex
+	conn *websocket.Conn
+	ctx  context.Context
+	r    *http.Request
+
+	username string
+	session  string
+	attached map[string]*SocketAttachment
+}
+
+type SocketAttachment struct {
+	socket *Socket
+	job    string
+	cancel context.CancelFunc
+}
+
+type SocketMessage struct {
+	Type     string          `json:"type"`
+	Ref      string          `json:"ref"`
+	ID       string          `json:"id"`
+	From     int             `json:"from"`
+	Chat     json.RawMessage `json:"chat"`
+	Approval *ToolApproval   `json:"approval"`
+}
+
+type SocketChunk struct {
+	Event string `json:"event"`
+	ID    string `json:"id"`
+	Index int    `json:"index"`
+	Type  string `json:"type"`
+	Data  any    `json:"data"`
+}
+
+func HandleSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		status := http.StatusBadRequest
+
+		if errors.Is(err, websocket.ErrCrossOrigin) {
+			status = http.StatusForbidden
+		}
+
+		RespondJson(w, status, map[string]any{
+			"error": err.Error(),
+		})
+
+		return
+	}
+
+	// the request context ends once the connection is hijacked
+	ctx, cancel := context.WithCancel(context.Background())
+
+	socket := &Socket{
+		conn:     conn,
+		ctx:      ctx,
+		r:        r,
+		username: GetUsername(r),
+		session:  GetSession(r),
+		attached: make(map[string]*SocketAttachment),
+	}
+
+	conn.IdleTimeout = 60 * time.Second
+
+	debug("socket connected")
+
+	go socket.KeepAlive(20 * time.Second)
+
+	socket.Serve()
+
+	cancel()
+
+	conn.Close()
+
+	debug("socket disconnected")
+}
+
+func (s *Socket) Serve() {
+	for {
+		_, data, err := s.conn.ReadMessage()
+		if err != nil {
+			if !errors.Is(err, websocket.ErrClosed) {
+				debug("socket read failed: %v", err)
+			}
+
+			return
+		}
+
+		var message SocketMessage
+
+		if err := json.Unmarshal(data, &message); err != nil {
+			s.Send(map[string]any{
+				"event": "error",
+				"error": fmt.Sprintf("invalid message: %v", err),
+			})
+
+			continue
+		}
+
+		err = s.Handle(&message)
+		if err != nil {
+			s.Send(map[string]any{
+				"event": "error",
+				"ref":   message.Ref,
+				"id":    message.ID,
+				"error": err.Error(),
+			})
+		}
+	}
+}
+
+// KeepAlive pings the client, replacing the alive chunks of http streams.
+func (s *Socket) KeepAlive(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.conn.Ping(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *Socket) Handle(message *SocketMessage) error {
+	switch message.Type {
+	case "ping":
+		return s.Send(map[string]any{
+			"event": "pong",
+			"ref":   message.Ref,
+		})
+	case "chat":
+		var raw ChatRequest
+
+		if err := json.Unmarshal(message.Chat, &raw); err != nil {
+			return err
+		}
+
+		request, err := raw.Parse()
+		if err != nil {
+			return err
+		}
+
+		job, _, err := StartChat(s.r, &raw, request)
+		if err != nil {
+			return err
+		}
+
+		s.Send(map[string]any{
+			"event": "started",
+			"ref":   message.Ref,
+			"id":    job.ID,
+		})
+
+		s.Attach(job, 0)
+	case "attach":
+		job := GetChatJob(message.ID, s.username, s.session)
+		if job == nil {
+			return ErrJobNotFound
+		}
+
+		debug("socket attaching to generation %s from %d", job.ID, message.From)
+
+		s.Attach(job, message.From)
+	case "detach":
+		s.Detach(message.ID)
+	case "cancel":
+		job := GetChatJob(message.ID, s.username, s.session)
+		if job == nil {
+			return ErrJobNotFound
+		}
+
+		cancelled := job.Cancel()
+
+		debug("cancel generation %s: %v", job.ID, cancelled)
+
+		return s.Send(map[string]any{
+			"event":     "cancel",
+			"ref":       message.Ref,
+			"id":        job.ID,
+			"cancelled": cancelled,
+		})
+	case "approve":
+		job := GetChatJob(message.ID, s.username, s.session)
+		if job == nil {
+			return ErrJobNotFound
+		}
+
+		if message.Approval == nil {
+			return errors.New("missing approval")
+		}
+
+		if err := message.Approval.Validate(); err != nil {
+			return err
+		}
+
+		if !job.Approve(message.Approval) {
+			return errors.New("no pending approval")
+		}
+
+		return s.Send(map[string]any{
+			"event": "approve",
+			"ref":   message.Ref,
+			"id":    job.ID,
+		})
+	default:
+		return fmt.Errorf("unknown message type %q", message.Type)
+	}
+
+	return nil
+}
+
+// Attach streams the chunks of a job starting at index from, replacing an
+// earlier attachment to the same job.
+func (s *Socket) Attach(job *ChatJob, from int) {
+	ctx, cancel := context.WithCancel(s.ctx)
+
+	attachment := &SocketAttachment{
+		socket: s,
+		job:    job.ID,
+		cancel: cancel,
+	}
+
+	s.mx.Lock()
+
+	if previous, ok := s.attached[job.ID]; ok {
+		previous.cancel()
+	}
+
+	s.attached[job.ID] = attachment
+
+	s.mx.Unlock()
+
+	go func() {
+		defer cancel()
+
+		err := job.Attach(ctx, attachment, from)
+
+		s.mx.Lock()
+
+		if s.attached[job.ID] == attachment {
+			delete(s.attached, job.ID)
+		}
+
+		s.mx.Unlock()
+
+		if err != nil {
+			if !errors.Is(err, context.Canceled) {
+				debug("socket detached from generation %s: %v", job.ID, err)
+			}
+
+			return
+		}
+
+		s.Send(map[string]any{
+			"event": "done",
+			"id":    job.ID,
+		})
+	}()
+}
+
+func (s *Socket) Detach(id string) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if attachment, ok := s.attached[id]; ok {
+		attachment.cancel()
+
+		delete(s.attached, id)
+	}
+}
+
+func (s *Socket) Send(data any) error {
+	buf := GetFreeBuffer()
+	defer pool.Put(buf)
+
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+
+	if err := enc.Encode(data); err != nil {
+		return err
+	}
+
+	return s.conn.WriteMessage(websocket.OpText, buf.Bytes())
+}
+
+func (a *SocketAttachment) WriteIndexedChunk(chunk *Chunk, index int) error {
+	debugIf(chunk.Type == ChunkError, "error: %v", chunk.Data)
+
+	return a.socket.Send(SocketChunk{
+		Event: "chunk",
+		ID:    a.job,
+		Index: index,
+		Type:  chunk.Type.String(),
+		Data:  chunk.Data,
+	})
+}
@@ -49,6 +49,8 @@ const ChunkType = {
 	9: "end",
 	10: "alive",
 	11: "audio",
+	12: "cancelled",
+	13: "approval",
 };
 
 const $version = document.getElementById("version"),
@@ -660,6 +662,7 @@ class Message {
 	#_edit;
 	#_images;
 	#_tool;
+	#_approval;
 	#_statistics;
 	#_roleSelect;
 	#_optSpeak;
@@ -1174,6 +1177,11 @@ class Message {
 
 		this.#_tool.appendChild(_callResult);
 
+		// tool call approval
+		this.#_approval = make("div", "approval");
+
+		_body.appendChild(this.#_approval);
+
 		// statistics
 		this.#_statistics = make("div", "statistics");
 
@@ -1522,7 +1530,7 @@ class Message {
 
 		if (!only || only === "tool") {
 			if (this.#tool) {
-				const { name, args, result, cost, invalid } = this.#tool;
+				const { name, args, result, cost, invalid, cached } = this.#tool;
 
 				const _name = this.#_tool.querySelector(".name"),
 					_arguments = this.#_tool.querySelector(".arguments"),
@@ -1535,7 +1543,8 @@ class Message {
 				_arguments.title = args;
 				_arguments.textContent = args;
 
-				_cost.textContent = cost ? `${formatMoney(cost)}` : "";
+				_cost.textContent = cost ? `${formatMoney(cost)}` : cached ? "cached" : "";
+				_cost.title = cached ? "Result was served from the tool cache" : "Cost of this tool call";
 
 				_result.classList.toggle("error", result?.startsWith("error: "));
 				_result.innerHTML = render(result ? wrapJSON(result) : "*processing*").html;
@@ -2460,6 +2469,8 @@ class Message {
 		if (state) {
 			this.#_message.classList.add(state);
 		} else {
+			this.#clearApproval();
+
 			if (this.#tool && !this.#tool.result) {
 				this.#tool.result = "failed to run tool";
 
@@ -2475,10 +2486,92 @@ class Message {
 
 		this.#compressedToolTokens = undefined;
 
+		if (tool?.done) {
+			this.#clearApproval();
+		}
+
 		this.#queueRender("tool");
 		this.save();
 	}
 
+	requestApproval(approval, respond) {
+		const args = JSON.stringify(approval.args ?? {}, null, 2);
+
+		this.#_approval.innerHTML = "";
+
+		const _label = make("div", "label");
+
+		_label.textContent = `Allow ${approval.name} to run? Edit the arguments to change the call.`;
+		_label.title = `Denied automatically after ${approval.timeout}s`;
+
+		this.#_approval.appendChild(_label);
+
+		const _arguments = make("textarea", "arguments");
+
+		_arguments.value = args;
+		_arguments.spellcheck = false;
+		_arguments.rows = Math.min(args.split("\n").length, 12);
+
+		this.#_approval.appendChild(_arguments);
+
+		const _buttons = make("div", "buttons");
+
+		this.#_approval.appendChild(_buttons);
+
+		const _deny = make("button", "deny");
+
+		_deny.textContent = "Deny";
+
+		_buttons.appendChild(_deny);
+
+		const _approve = make("button", "approve");
+
+		_approve.textContent = "Approve";
+
+		_buttons.appendChild(_approve);
+
+		const submit = async action => {
+			const decision = {
+				id: approval.id,
+				action: action,
+			};
+
+			if (action === "approve" && _arguments.value.trim() !== args) {
+				try {
+					decision.args = JSON.parse(_arguments.value);
+				} catch {
+					notify("The arguments are not valid JSON.", "error");
+
+					return;
+				}
+
+				decision.action = "edit";
+			}
+
+			this.#_approval.classList.add("submitting");
+
+			if (await respond(decision)) {
+				this.#clearApproval();
+			} else {
+				this.#_approval.classList.remove("submitting");
+			}
+		};
+
+		_deny.addEventListener("click", () => submit("deny"));
+		_approve.addEventListener("click", () => submit("approve"));
+
+		this.#_message.classList.add("awaiting-approval");
+
+		scroll();
+	}
+
+	#clearApproval() {
+		this.#_approval.innerHTML = "";
+		this.#_approval.classList.remove("submitting");
+
+		this.#_message.classList.remove("awaiting-approval");
+	}
+
 	addImage(image) {
 		this.#images.push(image);
 
@@ -3025,16 +3118,56 @@ async function generateTTS(proxy, model, voice, input) {
 
 let abortCallback;
 
-function abortNow() {
+function abortNow(graceful = false) {
 	if (!abortCallback) {
 		return false;
 	}
 
-	abortCallback();
+	abortCallback(graceful);
 
 	return true;
 }
 
+async function cancelGeneration(id) {
+	try {
+		const response = await fetch(`/-/chat/${id}/cancel`, {
+			method: "POST",
+		});
+
+		return response.ok;
+	} catch (err) {
+		console.error(err);
+
+		return false;
+	}
+}
+
+async function approveToolCall(id, decision) {
+	try {
+		const response = await fetch(`/-/chat/${id}/approve`, {
+			method: "POST",
+			headers: {
+				"Content-Type": "application/json",
+			},
+			body: JSON.stringify(decision),
+		});
+
+		if (!response.ok) {
+			const err = await response.json();
+
+			throw new Error(err?.error || response.statusText);
+		}
+
+		return true;
+	} catch (err) {
+		console.error(err);
+
+		notify(`Failed to respond to approval: ${err.message}`, "error");
+
+		return false;
+	}
+}
+
 async function buildRequest(noPush = false) {
 	let temperature = parseFloat($temperature.value);
 
@@ -3118,7 +3251,7 @@ async function buildRequest(noPush = false) {
 }
 
 async function generate(cancel = false, noPush = false) {
-	if (abortNow() && cancel) {
+	if (abortNow(cancel) && cancel) {
 		return;
 	}
 
@@ -3230,7 +3363,26 @@ async function generate(cancel = false, noPush = false) {
 		}, 100);
 	}
 
-	abortCallback = () => {
+	let generationId, cancelling;
+
+	abortCallback = (graceful = false) => {
+		if (generationId && !cancelling) {
+			cancelling = true;
+
+			if (graceful) {
+				// keep reading, so we receive the final usage and cancellation
+				cancelGeneration(generationId).then(ok => {
+					if (!ok) {
+						abortCallback?.();
+					}
+				});
+
+				return;
+			}
+
+			cancelGeneration(generationId);
+		}
+
 		abortCallback = null;
 		aborted = true;
 
@@ -3263,6 +3415,8 @@ async function generate(cancel = false, noPush = false) {
 			}
 
 			if (chunk === "done") {
+				generationId = null;
+
 				abortCallback();
 
 				return;
@@ -3271,9 +3425,17 @@ async function generate(cancel = false, noPush = false) {
 			stopTimeout?.();
 
 			switch (chunk.type) {
+				case "id":
+					generationId = chunk.data;
+
+					break;
 				case "start":
 					start(chunk.data);
 
+					break;
+				case "cancelled":
+					finish();
+
 					break;
 				case "end":
 					finish();
@@ -3302,6 +3464,12 @@ async function generate(cancel = false, noPush = false) {
 					}
 
 					break;
+				case "approval":
+					setGenerationState("tooling");
+
+					message.requestApproval(chunk.data, decision => approveToolCall(generationId, decision));
+
+					return; // prevent loading bar
 				case "image":
 					receivedCompletion = true;
 
@@ -4456,6 +4624,54 @@ async function uploadToMessage(self, message = false) {
 	isUploading = false;
 }
 
+async function uploadFromUrl(self, message = false) {
+	if (isUploading) {
+		return;
+	}
+
+	const url = await promptDialog("Enter the URL of a page or document to attach.", "", {
+		title: "Attach URL",
+		confirmLabel: "Fetch",
+	});
+
+	if (!url?.trim()) {
+		return;
+	}
+
+	isUploading = true;
+
+	self.classList.add("loading");
+
+	try {
+		const response = await fetch("/-/fetch", {
+			method: "POST",
+			headers: {
+				"Content-Type": "application/json",
+			},
+			body: JSON.stringify({
+				url: url.trim(),
+			}),
+		}),
+			file = await response.json();
+
+		if (!response.ok) {
+			throw new Error(file?.error || response.statusText);
+		}
+
+		file.tokens = (await resolveTokenCount(file.content)) || 0;
+
+		pushAttachment(file, message);
+	} catch (err) {
+		console.error(err);
+
+		notify(`Unable to fetch URL: ${err.message}`, "error");
+	}
+
+	self.classList.remove("loading");
+
+	isUploading = false;
+}
+
 async function uploadImageInline() {
 	if (isUploading) {
 		return;
@@ -4626,6 +4842,7 @@ function importMessage(message) {
 				result: importString(tool.result),
 				done: importBoolean(tool.done),
 				invalid: importBoolean(tool.invalid),
+				cached: importBoolean(tool.cached),
 			};
 		}
 	}
@@ -5500,6 +5717,8 @@ $message.addEventListener("paste", async event => {
 $upload.addEventListener("click", event => {
 	if (event.shiftKey) {
 		uploadImageInline();
+	} else if (event.altKey) {
+		uploadFromUrl($upload, false);
 	} else {
 		uploadToMessage($upload, false);
 	}
@@ -5,13 +5,13 @@ import (
 )
 
 type Statistics struct {
-	Provider        string  `msgpack:"provider"`
-	Model           string  `msgpack:"model"`
-	Cost            float64 `msgpack:"cost"`
-	InputTokens     int     `msgpack:"input"`
-	OutputTokens    int     `msgpack:"output"`
-	ReasoningTokens int     `msgpack:"reasoning"`
-	CachedTokens    int     `msgpack:"-"`
+	Provider        string  `json:"provider" msgpack:"provider"`
+	Model           string  `json:"model" msgpack:"model"`
+	Cost            float64 `json:"cost" msgpack:"cost"`
+	InputTokens     int     `json:"input" msgpack:"input"`
+	OutputTokens    int     `json:"output" msgpack:"output"`
+	ReasoningTokens int     `json:"reasoning" msgpack:"reasoning"`
+	CachedTokens    int     `json:"-" msgpack:"-"`
 }
 
 func CreateStatistics(model, provider string, usage *openingrouter.ChatUsage) *Statistics {
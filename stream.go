@@ -4,9 +4,13 @@ import (
 	"bytes"
 	"context"
 	"encoding/binary"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/vmihailenco/msgpack/v5"
 )
@@ -24,6 +28,8 @@ const (
 	ChunkEnd           ChunkType = 9
 	ChunkAlive         ChunkType = 10
 	ChunkAudio         ChunkType = 11
+	ChunkCancelled     ChunkType = 12
+	ChunkApproval      ChunkType = 13
 )
 
 type ChunkType uint8
@@ -31,17 +37,48 @@ type ChunkType uint8
 type Chunk struct {
 	Type ChunkType
 	Data any
+
+	// the original error of error chunks
+	err error
 }
 
 type StartChunk struct {
-	Iteration int64 `msgpack:"iteration"`
-	Total     int64 `msgpack:"total"`
+	Iteration int64 `json:"iteration" msgpack:"iteration"`
+	Total     int64 `json:"total" msgpack:"total"`
+}
+
+// event names used by the sse encoding (matching the frontend)
+var chunkNames = map[ChunkType]string{
+	ChunkStart:         "start",
+	ChunkID:            "id",
+	ChunkReasoning:     "reason",
+	ChunkReasoningType: "reason_type",
+	ChunkText:          "text",
+	ChunkImage:         "image",
+	ChunkTool:          "tool",
+	ChunkError:         "error",
+	ChunkUsage:         "usage",
+	ChunkEnd:           "end",
+	ChunkAlive:         "alive",
+	ChunkAudio:         "audio",
+	ChunkCancelled:     "cancelled",
+	ChunkApproval:      "approval",
+}
+
+type ChunkWriter interface {
+	WriteChunk(chunk *Chunk) error
+}
+
+// IndexedChunkWriter writes chunks along with their index in a generation.
+type IndexedChunkWriter interface {
+	WriteIndexedChunk(chunk *Chunk, index int) error
 }
 
 type Stream struct {
 	mx  sync.Mutex
 	wr  http.ResponseWriter
 	ctx context.Context
+	sse bool
 }
 
 var pool = sync.Pool{
@@ -58,27 +95,52 @@ func GetFreeBuffer() *bytes.Buffer {
 	return buf
 }
 
-func NewStream(w http.ResponseWriter, ctx context.Context) (*Stream, error) {
+// NewStream creates a chunk stream for a response. Chunks are binary encoded
+// (1-byte type, uint32 length, msgpack data) unless the client asks for
+// standard server-sent events with `?format=sse` or `Accept: text/event-stream`.
+func NewStream(w http.ResponseWriter, r *http.Request) (*Stream, error) {
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
 
 	return &Stream{
 		wr:  w,
-		ctx: ctx,
+		ctx: r.Context(),
+		sse: WantsSSE(r),
 	}, nil
 }
 
+func WantsSSE(r *http.Request) bool {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return format == "sse"
+	}
+
+	return strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
+func (t ChunkType) String() string {
+	if name, ok := chunkNames[t]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("chunk_%d", t)
+}
+
 func NewChunk(typ ChunkType, data any) *Chunk {
+	var original error
+
 	if str, ok := data.(string); ok {
 		data = CleanChunk(str)
 	} else if err, ok := data.(error); ok {
 		data = err.Error()
+
+		original = err
 	}
 
 	return &Chunk{
 		Type: typ,
 		Data: data,
+		err:  original,
 	}
 }
 
@@ -87,6 +149,12 @@ func GetErrorMessage(err error) string {
 }
 
 func (s *Stream) WriteChunk(chunk *Chunk) error {
+	return s.WriteIndexedChunk(chunk, -1)
+}
+
+// WriteIndexedChunk writes a chunk with its index in the generation, which is
+// used as the sse event id so clients can resume with Last-Event-ID.
+func (s *Stream) WriteIndexedChunk(chunk *Chunk, index int) error {
 	s.mx.Lock()
 	defer s.mx.Unlock()
 
@@ -99,19 +167,16 @@ func (s *Stream) WriteChunk(chunk *Chunk) error {
 	buf := GetFreeBuffer()
 	defer pool.Put(buf)
 
-	binary.Write(buf, binary.LittleEndian, chunk.Type)
-
-	if chunk.Data != nil {
-		data, err := msgpack.Marshal(chunk.Data)
-		if err != nil {
-			return err
-		}
-
-		binary.Write(buf, binary.LittleEndian, uint32(len(data)))
+	var err error
 
-		buf.Write(data)
+	if s.sse {
+		err = encodeEvent(buf, chunk, index)
 	} else {
-		binary.Write(buf, binary.LittleEndian, uint32(0))
+		err = encodeBinary(buf, chunk)
+	}
+
+	if err != nil {
+		return err
 	}
 
 	if _, err := s.wr.Write(buf.Bytes()); err != nil {
@@ -132,3 +197,68 @@ func (s *Stream) WriteChunk(chunk *Chunk) error {
 		return nil
 	}
 }
+
+// KeepAlive periodically writes alive chunks until the stream's context is done.
+func (s *Stream) KeepAlive(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			s.WriteChunk(NewChunk(ChunkAlive, nil))
+		}
+	}
+}
+
+func encodeBinary(buf *bytes.Buffer, chunk *Chunk) error {
+	binary.Write(buf, binary.LittleEndian, chunk.Type)
+
+	if chunk.Data == nil {
+		binary.Write(buf, binary.LittleEndian, uint32(0))
+
+		return nil
+	}
+
+	data, err := msgpack.Marshal(chunk.Data)
+	if err != nil {
+		return err
+	}
+
+	binary.Write(buf, binary.LittleEndian, uint32(len(data)))
+
+	buf.Write(data)
+
+	return nil
+}
+
+// encodeEvent writes a chunk as a server-sent event with a json payload.
+// Keep-alive chunks become sse comments, which clients ignore.
+func encodeEvent(buf *bytes.Buffer, chunk *Chunk, index int) error {
+	if chunk.Type == ChunkAlive {
+		buf.WriteString(": alive\n\n")
+
+		return nil
+	}
+
+	if index >= 0 {
+		fmt.Fprintf(buf, "id: %d\n", index)
+	}
+
+	fmt.Fprintf(buf, "event: %s\ndata: ", chunk.Type)
+
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+
+	// the encoder terminates the data line with a newline
+	err := enc.Encode(chunk.Data)
+	if err != nil {
+		return err
+	}
+
+	buf.WriteString("\n")
+
+	return nil
+}
@@ -7,11 +7,13 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"sort"
 	"strings"
-	"sync"
 )
 
+type TavilyProvider struct {
+	token string
+}
+
 type TavilyResult struct {
 	Title         string  `json:"title"`
 	URL           string  `json:"url"`
@@ -115,11 +117,6 @@ type TavilySearchResponse struct {
 	RequestID      string               `json:"request_id,omitempty"`
 }
 
-type TavilyQueryResult struct {
-	Resp TavilySearchResponse
-	Err  error
-}
-
 func (t *TavilyResults) String() string {
 	buf := GetFreeBuffer()
 	defer pool.Put(buf)
@@ -131,7 +128,7 @@ func (t *TavilyResults) String() string {
 	return buf.String()
 }
 
-func doTavilyRequest(ctx context.Context, path string, data, out any) error {
+func (p *TavilyProvider) request(ctx context.Context, path string, data, out any) error {
 	var buf bytes.Buffer
 
 	err := json.NewEncoder(&buf).Encode(data)
@@ -145,7 +142,7 @@ func doTavilyRequest(ctx context.Context, path string, data, out any) error {
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", env.Tokens.Tavily))
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", p.token))
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
@@ -166,42 +163,25 @@ func doTavilyRequest(ctx context.Context, path string, data, out any) error {
 	return json.NewDecoder(resp.Body).Decode(out)
 }
 
-func DoTavilySearch(ctx context.Context, data TavilySearchRequest) (TavilySearchResponse, error) {
+func (p *TavilyProvider) DoSearch(ctx context.Context, data TavilySearchRequest) (TavilySearchResponse, error) {
 	var resp TavilySearchResponse
 
-	err := doTavilyRequest(ctx, "/search", data, &resp)
+	err := p.request(ctx, "/search", data, &resp)
 	return resp, err
 }
 
-func DoTavilyExtract(ctx context.Context, data TavilyExtractRequest) (TavilyExtractResponse, error) {
+func (p *TavilyProvider) DoExtract(ctx context.Context, data TavilyExtractRequest) (TavilyExtractResponse, error) {
 	var resp TavilyExtractResponse
 
-	err := doTavilyRequest(ctx, "/extract", data, &resp)
+	err := p.request(ctx, "/extract", data, &resp)
 	return resp, err
 }
 
-func TavilyRunSearch(ctx context.Context, args *SearchWebArguments) (*TavilyResults, error) {
-	queries := make([]string, 0, len(args.Queries))
-	for _, q := range args.Queries {
-		if q = strings.TrimSpace(q); q != "" {
-			queries = append(queries, q)
-		}
-	}
-
-	if len(queries) == 0 {
-		return nil, fmt.Errorf("no search query")
-	}
-
-	if len(queries) > 5 {
-		queries = queries[:5]
-	}
-
-	if args.MaxResults <= 0 {
-		args.MaxResults = 5
-	} else if args.MaxResults > 20 {
-		args.MaxResults = 20
-	}
+func (p *TavilyProvider) Name() string {
+	return "tavily"
+}
 
+func (p *TavilyProvider) Search(ctx context.Context, query string, args *SearchWebArguments) (*SearchResponse, error) {
 	var (
 		searchDepth     = "fast"
 		chunksPerSource int
@@ -212,103 +192,51 @@ func TavilyRunSearch(ctx context.Context, args *SearchWebArguments) (*TavilyResu
 		chunksPerSource = 3
 	}
 
-	var (
-		wg      sync.WaitGroup
-		outputs = make([]TavilyQueryResult, len(queries))
-	)
-
-	for i, query := range queries {
-		wg.Go(func() {
-			req := TavilySearchRequest{
-				Query:           query,
-				MaxResults:      args.MaxResults,
-				Topic:           args.Topic,
-				IncludeDomains:  args.IncludeDomains,
-				ExcludeDomains:  args.ExcludeDomains,
-				SearchDepth:     searchDepth,
-				ChunksPerSource: chunksPerSource,
-				IncludeUsage:    true,
-			}
-
-			if args.TimeRange != "" {
-				req.TimeRange = args.TimeRange
-			} else {
-				req.StartDate = args.StartDate
-				req.EndDate = args.EndDate
-			}
-
-			resp, err := DoTavilySearch(ctx, req)
-
-			outputs[i] = TavilyQueryResult{
-				Resp: resp,
-				Err:  err,
-			}
-		})
+	req := TavilySearchRequest{
+		Query:           query,
+		MaxResults:      args.MaxResults,
+		Topic:           args.Topic,
+		IncludeDomains:  args.IncludeDomains,
+		ExcludeDomains:  args.ExcludeDomains,
+		SearchDepth:     searchDepth,
+		ChunksPerSource: chunksPerSource,
+		IncludeUsage:    true,
 	}
 
-	wg.Wait()
-
-	results := &TavilyResults{
-		Query:   strings.Join(queries, " | "),
-		Results: make([]TavilyResult, 0),
+	if args.TimeRange != "" {
+		req.TimeRange = args.TimeRange
+	} else {
+		req.StartDate = args.StartDate
+		req.EndDate = args.EndDate
 	}
 
-	var (
-		firstErr error
-		seen     = make(map[string]int)
-	)
-
-	for _, result := range outputs {
-		if result.Err != nil {
-			if firstErr == nil {
-				firstErr = result.Err
-			}
-
-			continue
-		}
-
-		if results.RequestID == "" {
-			results.RequestID = result.Resp.RequestID
-		}
-
-		results.Usage.Credits += result.Resp.Usage.Credits
-
-		for _, result := range result.Resp.Results {
-			converted := TavilyResult{
-				Title:         result.Title,
-				URL:           result.Url,
-				PublishedDate: result.PublishedDate,
-				Score:         result.Score,
-				Summary:       result.Content,
-				Text:          result.RawContent,
-			}
-
-			if idx, ok := seen[result.Url]; ok {
-				if converted.Score > results.Results[idx].Score {
-					results.Results[idx] = converted
-				}
-
-				continue
-			}
-
-			seen[result.Url] = len(results.Results)
-			results.Results = append(results.Results, converted)
-		}
+	resp, err := p.DoSearch(ctx, req)
+	if err != nil {
+		return nil, err
 	}
 
-	if len(results.Results) == 0 && firstErr != nil {
-		return nil, firstErr
+	response := &SearchResponse{
+		RequestID: resp.RequestID,
+		Credits:   resp.Usage.Credits,
+		Results:   make([]TavilyResult, 0, len(resp.Results)),
 	}
 
-	sort.SliceStable(results.Results, func(i, j int) bool {
-		return results.Results[i].Score > results.Results[j].Score
-	})
+	for _, result := range resp.Results {
+		response.Results = append(response.Results, TavilyResult{
+			Title:         result.Title,
+			URL:           result.Url,
+			PublishedDate: result.PublishedDate,
+			Score:         result.Score,
+			Summary:       result.Content,
+			Text:          result.RawContent,
+		})
+	}
 
-	return results, nil
+	return response, nil
 }
 
-func TavilyRunContents(ctx context.Context, args *FetchContentsArguments) (*TavilyResults, error) {
-	resp, err := DoTavilyExtract(ctx, TavilyExtractRequest{
+func (p *TavilyProvider) Contents(ctx context.Context, args *FetchContentsArguments) (*TavilyResults, error) {
+	resp, err := p.DoExtract(ctx, TavilyExtractRequest{
 		Urls:         args.URLs,
 		ExtractDepth: "advanced",
 		Format:       "markdown",
@@ -80,6 +80,11 @@ func LoadTokenizer(url string) (*Tokenizer, error) {
 }
 
 func (t *Tokenizer) CountTokens(text string) int {
+	// rough estimate if the tokenizer is not loaded (yet)
+	if t == nil {
+		return (len(text) + 3) / 4
+	}
+
 	input := []byte(text)
 	n := len(input)
 
@@ -127,8 +127,10 @@ func HandleTitle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	backend, model := SplitModelSlug(env.Models.TitleModel)
+
 	request := openingrouter.ChatCompletionRequest{
-		Model: env.Models.TitleModel,
+		Model: model,
 		Messages: []openingrouter.ChatMessage{
 			openingrouter.SystemMessage(buf.String()),
 			openingrouter.UserMessage(strings.Join(messages, "\n")),
@@ -156,7 +158,7 @@ func HandleTitle(w http.ResponseWriter, r *http.Request) {
 
 	debug("generating title")
 
-	response, err := OpenRouterRun(r.Context(), request, proxy)
+	choice, cost, err := backend.Complete(r.Context(), &request, proxy)
 	if err != nil {
 		RespondJson(w, http.StatusInternalServerError, map[string]any{
 			"error": err.Error(),
@@ -165,15 +167,6 @@ func HandleTitle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	choice := response.Choices[0].Message.Content.String()
-	var cost float64
-	if response.Usage != nil {
-		cost = Nullable(response.Usage.Cost, 0)
-		if response.Usage.CostDetails != nil {
-			cost += Nullable(response.Usage.CostDetails.UpstreamInferenceCost, 0)
-		}
-	}
-
 	var result TitleResponse
 
 	err = json.Unmarshal([]byte(choice), &result)
@@ -9,24 +9,22 @@ type TokenizeRequest struct {
 	String string `json:"string"`
 }
 
-func HandleTokenize(tokenizer *Tokenizer) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		debug("parsing tokenize")
+func HandleTokenize(w http.ResponseWriter, r *http.Request) {
+	debug("parsing tokenize")
 
-		var raw TokenizeRequest
+	var raw TokenizeRequest
 
-		if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
-			RespondJson(w, http.StatusBadRequest, map[string]any{
-				"error": err.Error(),
-			})
+	if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+		RespondJson(w, http.StatusBadRequest, map[string]any{
+			"error": err.Error(),
+		})
 
-			return
-		}
+		return
+	}
 
-		tokens := tokenizer.CountTokens(raw.String)
+	tokens := tokenizer.CountTokens(raw.String)
 
-		RespondJson(w, http.StatusOK, map[string]any{
-			"tokens": tokens,
-		})
-	}
+	RespondJson(w, http.StatusOK, map[string]any{
+		"tokens": tokens,
+	})
 }
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/coalaura/openingrouter"
+)
+
+// Tool is a function the model can call during a chat.
+type Tool interface {
+	// Name is the unique function name exposed to the model.
+	Name() string
+
+	// Definition returns the function definition including its json schema.
+	Definition() openingrouter.ChatFunction
+
+	// Prompt returns the snippet describing the tool in the tools system prompt.
+	Prompt() string
+
+	// Enabled reports whether the tool can be offered for a request and model.
+	Enabled(request *ChatRequest, model *Model) bool
+
+	// Execute runs the tool call, storing its output in call.Result.
+	Execute(ctx context.Context, call *ChatToolCall) error
+}
+
+// FunctionTool implements Tool for a handler receiving typed arguments.
+type FunctionTool[T any] struct {
+	Function openingrouter.ChatFunction
+	Snippet  string
+	Enable   func(request *ChatRequest, model *Model) bool
+	Handler  func(ctx context.Context, call *ChatToolCall, arguments *T) error
+}
+
+var (
+	toolNameRgx = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+	toolMx sync.RWMutex
+
+	toolList   []Tool
+	toolLookup = make(map[string]Tool)
+)
+
+func (t *FunctionTool[T]) Name() string {
+	return t.Function.Name
+}
+
+func (t *FunctionTool[T]) Definition() openingrouter.ChatFunction {
+	return t.Function
+}
+
+func (t *FunctionTool[T]) Prompt() string {
+	return t.Snippet
+}
+
+func (t *FunctionTool[T]) Enabled(request *ChatRequest, model *Model) bool {
+	if t.Enable == nil {
+		return true
+	}
+
+	return t.Enable(request, model)
+}
+
+func (t *FunctionTool[T]) Execute(ctx context.Context, call *ChatToolCall) error {
+	arguments, err := ParseAndUpdateArgs[T](call)
+	if err != nil {
+		return err
+	}
+
+	return t.Handler(ctx, call, arguments)
+}
+
+func RegisterTool(tool Tool) error {
+	toolMx.Lock()
+	defer toolMx.Unlock()
+
+	name := tool.Name()
+
+	if _, ok := toolLookup[name]; ok {
+		return fmt.Errorf("duplicate tool %q", name)
+	}
+
+	toolList = append(toolList, tool)
+	toolLookup[name] = tool
+
+	return nil
+}
+
+func MustRegisterTool(tool Tool) {
+	err := RegisterTool(tool)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func GetTool(name string) Tool {
+	toolMx.RLock()
+	defer toolMx.RUnlock()
+
+	return toolLookup[name]
+}
+
+func AllTools() []Tool {
+	toolMx.RLock()
+	defer toolMx.RUnlock()
+
+	return slices.Clone(toolList)
+}
+
+// ResolveTools returns all registered tools that are enabled by the config,
+// the request and the capabilities of the model.
+func ResolveTools(request *ChatRequest, model *Model) []Tool {
+	if !model.Tools {
+		return nil
+	}
+
+	var tools []Tool
+
+	for _, tool := range AllTools() {
+		name := tool.Name()
+
+		if slices.Contains(env.Tools.Disabled, name) {
+			continue
+		}
+
+		if len(request.Tools.Enabled) > 0 && !slices.Contains(request.Tools.Enabled, name) {
+			continue
+		}
+
+		if !tool.Enabled(request, model) {
+			continue
+		}
+
+		tools = append(tools, tool)
+	}
+
+	return tools
+}
+
+// HasTools reports whether any registered tool is usable with the current config.
+func HasTools() bool {
+	for _, tool := range AllTools() {
+		if !slices.Contains(env.Tools.Disabled, tool.Name()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func ToolDefinitions(tools []Tool) []openingrouter.ChatTool {
+	definitions := make([]openingrouter.ChatTool, 0, len(tools))
+
+	for _, tool := range tools {
+		definitions = append(definitions, openingrouter.ChatFunctionTool{
+			Type:     openingrouter.ChatToolTypeFunction,
+			Function: tool.Definition(),
+		})
+	}
+
+	return definitions
+}
+
+func ToolPrompts(tools []Tool) string {
+	snippets := make([]string, 0, len(tools))
+
+	for _, tool := range tools {
+		snippet := strings.TrimSpace(tool.Prompt())
+		if snippet == "" {
+			continue
+		}
+
+		snippets = append(snippets, snippet)
+	}
+
+	return strings.Join(snippets, "\n\n")
+}
+
+func (r *ChatRequest) GetTool(name string) Tool {
+	for _, tool := range r.tools {
+		if tool.Name() == name {
+			return tool
+		}
+	}
+
+	return nil
+}
+
+func IsToolName(name string) bool {
+	return toolNameRgx.MatchString(name)
+}
+
+// ToolPromptSnippet builds a prompt snippet in the style of the built-in tools
+// from a function definition, e.g. "**name({a, b?})**".
+func ToolPromptSnippet(function openingrouter.ChatFunction) string {
+	var (
+		arguments []string
+		required  []string
+	)
+
+	if schema, ok := function.Parameters.(map[string]any); ok {
+		required = schemaStrings(schema["required"])
+
+		if properties, ok := schema["properties"].(map[string]any); ok {
+			for name := range properties {
+				if !slices.Contains(required, name) {
+					name += "?"
+				}
+
+				arguments = append(arguments, name)
+			}
+		}
+	}
+
+	sort.Slice(arguments, func(i, j int) bool {
+		// required arguments first
+		ri := !strings.HasSuffix(arguments[i], "?")
+		rj := !strings.HasSuffix(arguments[j], "?")
+
+		if ri != rj {
+			return ri
+		}
+
+		return arguments[i] < arguments[j]
+	})
+
+	var snippet strings.Builder
+
+	fmt.Fprintf(&snippet, "**%s({%s})**", function.Name, strings.Join(arguments, ", "))
+
+	for line := range strings.SplitSeq(strings.TrimSpace(function.Description), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		snippet.WriteString("\n- ")
+		snippet.WriteString(line)
+	}
+
+	return snippet.String()
+}
@@ -22,8 +22,8 @@ type TTSRequest struct {
 }
 
 type TTSResponseChunk struct {
-	Audio       []byte `msgpack:"audio"`
-	ContentType string `msgpack:"content_type"`
+	Audio       []byte `json:"audio" msgpack:"audio"`
+	ContentType string `json:"content_type" msgpack:"content_type"`
 }
 
 const (
@@ -41,14 +41,6 @@ func HandleTTS(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if env.IsOpenAI() {
-		RespondJson(w, http.StatusForbidden, map[string]any{
-			"error": "Text-to-speech is only available with the openrouter api",
-		})
-
-		return
-	}
-
 	var req TTSRequest
 
 	err := json.NewDecoder(r.Body).Decode(&req)
@@ -63,6 +55,16 @@ func HandleTTS(w http.ResponseWriter, r *http.Request) {
 	req.Model = strings.TrimSpace(req.Model)
 	req.Input = strings.TrimSpace(req.Input)
 
+	backend, model := SplitModelSlug(req.Model)
+
+	if !backend.IsOpenRouter() {
+		RespondJson(w, http.StatusForbidden, map[string]any{
+			"error": "Text-to-speech is only available with openrouter backends",
+		})
+
+		return
+	}
+
 	if req.Input == "" {
 		RespondJson(w, http.StatusBadRequest, map[string]any{
 			"error": "input text is required",
@@ -90,7 +92,7 @@ func HandleTTS(w http.ResponseWriter, r *http.Request) {
 
 	ctx := r.Context()
 
-	stream, err := NewStream(w, ctx)
+	stream, err := NewStream(w, r)
 	if err != nil {
 		RespondJson(w, http.StatusBadRequest, map[string]any{
 			"error": err.Error(),
@@ -99,29 +101,17 @@ func HandleTTS(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	go func() {
-		ticker := time.NewTicker(5 * time.Second)
-		defer ticker.Stop()
-
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case <-ticker.C:
-				stream.WriteChunk(NewChunk(ChunkAlive, nil))
-			}
-		}
-	}()
+	go stream.KeepAlive(5 * time.Second)
 
-	client := OpenRouterClient(proxy)
+	client := OpenRouterClient(backend, proxy)
 
 	speechReq := openingrouter.SpeechRequest{
-		Model: req.Model,
+		Model: model,
 		Input: req.Input,
 		Voice: req.Voice,
 	}
 
-	format, ok := AudioFormats[req.Model]
+	format, ok := AudioFormats[model]
 	if ok {
 		speechReq.ResponseFormat = format.Optimal
 	}
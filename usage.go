@@ -10,13 +10,14 @@ type Usage struct {
 }
 
 func HandleUsage(w http.ResponseWriter, r *http.Request) {
-	if env.IsOpenAI() {
+	backend := OpenRouterBackend()
+	if backend == nil {
 		RespondJson(w, http.StatusOK, Usage{})
 
 		return
 	}
 
-	client := OpenRouterClient(nil)
+	client := OpenRouterClient(backend, nil)
 
 	current, err := client.GetCurrentApiKey(r.Context())
 	if err != nil {
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/coalaura/openingrouter"
+)
+
+type WebhookTool struct {
+	webhook  *EnvWebhookTool
+	function openingrouter.ChatFunction
+}
+
+func LoadWebhookTools() error {
+	for i := range env.Tools.Webhooks {
+		webhook := &env.Tools.Webhooks[i]
+
+		err := RegisterTool(&WebhookTool{
+			webhook: webhook,
+			function: openingrouter.ChatFunction{
+				Name:        webhook.Name,
+				Description: webhook.Description,
+				Parameters:  webhook.Parameters,
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("webhook tool: %v", err)
+		}
+	}
+
+	return nil
+}
+
+func (t *WebhookTool) Name() string {
+	return t.function.Name
+}
+
+func (t *WebhookTool) Definition() openingrouter.ChatFunction {
+	return t.function
+}
+
+func (t *WebhookTool) Prompt() string {
+	return ToolPromptSnippet(t.function)
+}
+
+func (t *WebhookTool) Enabled(_ *ChatRequest, _ *Model) bool {
+	return true
+}
+
+func (t *WebhookTool) Execute(ctx context.Context, call *ChatToolCall) error {
+	if strings.TrimSpace(call.Args) == "" {
+		call.Args = "{}"
+	}
+
+	arguments, err := ParseSchemaArgs(call, t.webhook.Parameters)
+	if err != nil {
+		return err
+	}
+
+	err = ValidateToolArguments(t.webhook.Parameters, arguments)
+	if err != nil {
+		call.Result = fmt.Sprintf("error: invalid arguments: %v", err)
+
+		return nil
+	}
+
+	result, err := t.Call(ctx, arguments)
+	if err != nil {
+		call.Result = fmt.Sprintf("error: %v", err)
+
+		return nil
+	}
+
+	call.Result = result
+
+	return nil
+}
+
+func (t *WebhookTool) Call(ctx context.Context, arguments map[string]any) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(t.webhook.Timeout)*time.Second)
+	defer cancel()
+
+	var (
+		target = t.webhook.URL
+		body   io.Reader
+	)
+
+	if t.webhook.Method == http.MethodGet {
+		parsed, err := url.Parse(target)
+		if err != nil {
+			return "", err
+		}
+
+		query := parsed.Query()
+
+		for key, value := range arguments {
+			if str, ok := value.(string); ok {
+				query.Set(key, str)
+
+				continue
+			}
+
+			encoded, err := json.Marshal(value)
+			if err != nil {
+				return "", err
+			}
+
+			query.Set(key, string(encoded))
+		}
+
+		parsed.RawQuery = query.Encode()
+
+		target = parsed.String()
+	} else {
+		encoded, err := json.Marshal(arguments)
+		if err != nil {
+			return "", err
+		}
+
+		body = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, t.webhook.Method, target, body)
+	if err != nil {
+		return "", err
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	req.Header.Set("User-Agent", "whiskr/"+Version)
+
+	for key, value := range t.webhook.Headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, t.webhook.MaxSize+1))
+	if err != nil {
+		return "", err
+	}
+
+	truncated := int64(len(data)) > t.webhook.MaxSize
+
+	if truncated {
+		data = data[:t.webhook.MaxSize]
+	}
+
+	result := strings.ToValidUTF8(string(data), "")
+
+	if resp.StatusCode >= 400 {
+		return "", fmt.Errorf("webhook returned status %d: %s", resp.StatusCode, strings.TrimSpace(result))
+	}
+
+	if strings.TrimSpace(result) == "" {
+		return "(no output)", nil
+	}
+
+	if truncated {
+		result += fmt.Sprintf("\n\n(response truncated to %d bytes)", t.webhook.MaxSize)
+	}
+
+	return result, nil
+}
+
+// ValidateToolArguments checks arguments against the top-level properties of
+// a json schema (required, types, enums and additionalProperties). Numbers
+// are expected as json.Number, enums of the schema as float64.
+func ValidateToolArguments(schema map[string]any, arguments map[string]any) error {
+	properties, _ := schema["properties"].(map[string]any)
+
+	for _, name := range schemaStrings(schema["required"]) {
+		if _, ok := arguments[name]; !ok {
+			return fmt.Errorf("missing %q", name)
+		}
+	}
+
+	for name, value := range arguments {
+		property, ok := properties[name].(map[string]any)
+		if !ok {
+			if additional, ok := schema["additionalProperties"].(bool); ok && !additional {
+				return fmt.Errorf("unknown argument %q", name)
+			}
+
+			continue
+		}
+
+		typ, _ := property["type"].(string)
+
+		if typ != "" && !schemaTypeMatches(typ, value) {
+			return fmt.Errorf("%q must be of type %s", name, typ)
+		}
+
+		if number, ok := value.(json.Number); ok {
+			if float, err := number.Float64(); err == nil {
+				value = float
+			}
+		}
+
+		if enum, ok := property["enum"].([]any); ok && !slices.Contains(enum, value) {
+			return fmt.Errorf("%q must be one of %v", name, enum)
+		}
+	}
+
+	return nil
+}
+
+// NormalizeSchemaNumbers converts all numbers of a schema loaded from yaml
+// (ints, uints and float32) to float64.
+func NormalizeSchemaNumbers(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, item := range v {
+			v[key] = NormalizeSchemaNumbers(item)
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = NormalizeSchemaNumbers(item)
+		}
+	case int:
+		return float64(v)
+	case int8:
+		return float64(v)
+	case int16:
+		return float64(v)
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case uint:
+		return float64(v)
+	case uint8:
+		return float64(v)
+	case uint16:
+		return float64(v)
+	case uint32:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	case float32:
+		return float64(v)
+	}
+
+	return value
+}
+
+func schemaStrings(value any) []string {
+	switch list := value.(type) {
+	case []string:
+		return list
+	case []any:
+		result := make([]string, 0, len(list))
+
+		for _, entry := range list {
+			if str, ok := entry.(string); ok {
+				result = append(result, str)
+			}
+		}
+
+		return result
+	}
+
+	return nil
+}
+
+func schemaTypeMatches(typ string, value any) bool {
+	switch typ {
+	case "string":
+		_, ok := value.(string)
+
+		return ok
+	case "number":
+		_, ok := value.(json.Number)
+
+		return ok
+	case "integer":
+		number, ok := value.(json.Number)
+		if !ok {
+			return false
+		}
+
+		float, err := number.Float64()
+
+		return err == nil && float == math.Trunc(float)
+	case "boolean":
+		_, ok := value.(bool)
+
+		return ok
+	case "array":
+		_, ok := value.([]any)
+
+		return ok
+	case "object":
+		_, ok := value.(map[string]any)
+
+		return ok
+	case "null":
+		return value == nil
+	}
+
+	return true
+}